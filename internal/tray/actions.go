@@ -0,0 +1,289 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"deedles.dev/trayscale/internal/tsutil"
+)
+
+// A menuAction identifies one of the fixed, clickable items in the
+// tray menu.
+type menuAction int
+
+const (
+	actionShow menuAction = iota
+	actionConnToggle
+	actionServiceToggle
+	actionFinishLogin
+	actionCaptivePortal
+	actionUpgradeDaemon
+	actionConnect
+	actionDisconnect
+	actionPanic
+	actionExitToggle
+	actionSuggestedExit
+	actionSelfNode
+	actionSelfItem
+	actionRename
+	actionCopyReport
+	actionCopyDNSSuffix
+	actionAdminConsole
+	actionApplyUpdate
+	actionOpenTaildropDir
+	actionClearTaildrop
+	actionAbout
+	actionQuit
+)
+
+// actions returns the function that should be called when the item
+// for each menu action is clicked. Both platforms wire their menus
+// from it so that the wiring can be tested without a real tray.
+func (t *trayImpl) actions() map[menuAction]func() {
+	return map[menuAction]func(){
+		actionShow:            t.toggleWindow,
+		actionConnToggle:      t.unlessReadOnly(t.toggleConnection),
+		actionServiceToggle:   t.unlessReadOnly(t.toggleService),
+		actionFinishLogin:     t.finishLogin,
+		actionCaptivePortal:   t.openCaptivePortal,
+		actionUpgradeDaemon:   t.openDaemonUpgrade,
+		actionConnect:         t.unlessReadOnly(t.connect),
+		actionDisconnect:      t.unlessReadOnly(t.disconnect),
+		actionPanic:           t.unlessReadOnly(optional("OnPanic", t.OnPanic)),
+		actionExitToggle:      t.unlessReadOnly(t.toggleExitNode),
+		actionSuggestedExit:   t.unlessReadOnly(t.useSuggestedExit),
+		actionSelfNode:        optional("OnSelfNode", t.OnSelfNode),
+		actionSelfItem:        t.selfItemClicked(),
+		actionRename:          t.unlessReadOnly(optional("OnRename", t.OnRename)),
+		actionCopyReport:      t.copyStatusReport,
+		actionCopyDNSSuffix:   t.copyDNSSuffix,
+		actionAdminConsole:    t.openAdminConsole,
+		actionApplyUpdate:     t.unlessReadOnly(optional("OnApplyUpdate", t.OnApplyUpdate)),
+		actionOpenTaildropDir: optional("OnOpenTaildropDir", t.OnOpenTaildropDir),
+		actionClearTaildrop:   t.unlessReadOnly(optional("OnClearTaildrop", t.OnClearTaildrop)),
+		actionAbout:           optional("OnAbout", t.OnAbout),
+		actionQuit:            t.quit,
+	}
+}
+
+// triggerIDs maps the item IDs accepted by Trigger to the actions
+// that they perform.
+var triggerIDs = map[string]menuAction{
+	"show":            actionShow,
+	"connToggle":      actionConnToggle,
+	"serviceToggle":   actionServiceToggle,
+	"finishLogin":     actionFinishLogin,
+	"captivePortal":   actionCaptivePortal,
+	"upgradeDaemon":   actionUpgradeDaemon,
+	"connect":         actionConnect,
+	"disconnect":      actionDisconnect,
+	"panic":           actionPanic,
+	"exitToggle":      actionExitToggle,
+	"suggestedExit":   actionSuggestedExit,
+	"selfNode":        actionSelfNode,
+	"selfItem":        actionSelfItem,
+	"rename":          actionRename,
+	"copyReport":      actionCopyReport,
+	"copyDNSSuffix":   actionCopyDNSSuffix,
+	"adminConsole":    actionAdminConsole,
+	"applyUpdate":     actionApplyUpdate,
+	"openTaildropDir": actionOpenTaildropDir,
+	"clearTaildrop":   actionClearTaildrop,
+	"about":           actionAbout,
+	"quit":            actionQuit,
+}
+
+// TriggerItemIDs returns the item IDs accepted by [Tray.Trigger],
+// sorted.
+func TriggerItemIDs() []string {
+	ids := make([]string, 0, len(triggerIDs))
+	for id := range triggerIDs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// actionItem returns the item that performs action, or nil if it
+// isn't in the menu. It must be called with t.m held.
+func (t *trayImpl) actionItem(action menuAction) menuItem {
+	switch action {
+	case actionShow:
+		return t.showItem
+	case actionConnToggle:
+		return t.connToggleItem
+	case actionServiceToggle:
+		return t.serviceItem
+	case actionFinishLogin:
+		return t.loginItem
+	case actionCaptivePortal:
+		return t.captiveItem
+	case actionUpgradeDaemon:
+		return t.daemonVerItem
+	case actionConnect:
+		return t.connectItem
+	case actionDisconnect:
+		return t.disconnectItem
+	case actionPanic:
+		return t.panicItem
+	case actionExitToggle:
+		return t.exitToggleItem
+	case actionSuggestedExit:
+		return t.suggestedItem
+	case actionSelfNode:
+		return t.selfShowItem
+	case actionSelfItem:
+		return t.selfNodeItem
+	case actionRename:
+		return t.renameItem
+	case actionCopyReport:
+		return t.reportItem
+	case actionCopyDNSSuffix:
+		return t.dnsSuffixItem
+	case actionAdminConsole:
+		return t.adminItem
+	case actionApplyUpdate:
+		return t.updateItem
+	case actionOpenTaildropDir:
+		return t.taildropOpen
+	case actionClearTaildrop:
+		return t.taildropClear
+	case actionAbout:
+		return t.aboutMenuItem
+	case actionQuit:
+		return t.quitItem
+	default:
+		return nil
+	}
+}
+
+func (t *trayImpl) Trigger(itemID string) error {
+	action, ok := triggerIDs[itemID]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownItem, itemID)
+	}
+
+	t.m.Lock()
+	available := !t.closed && (t.host != nil)
+	if available {
+		item := t.actionItem(action)
+		available = (item != nil) && item.Enabled()
+	}
+	t.m.Unlock()
+	if !available {
+		return fmt.Errorf("%w: %q", ErrItemUnavailable, itemID)
+	}
+
+	// The handlers lock t.m themselves, so it can't be held while one
+	// is called.
+	t.actions()[action]()
+	return nil
+}
+
+// selfItemClicked returns the function that is called when the self
+// item is clicked. See [WithSelfItemAction].
+func (t *trayImpl) selfItemClicked() func() {
+	switch t.selfItemAction {
+	case SelfItemCopyAddr:
+		return t.copySelfAddr
+	case SelfItemShowDetails:
+		return t.show
+	case SelfItemNone:
+		return func() {}
+	default:
+		return optional("OnSelfNode", t.OnSelfNode)
+	}
+}
+
+// operatorIsCurrent returns true if status says that the current user
+// is allowed to change Tailscale's settings. It is a variable so that
+// tests don't depend on the user that they're run as.
+var operatorIsCurrent = (*tsutil.IPNStatus).OperatorIsCurrent
+
+// isReadOnly returns true if the items that change Tailscale's state
+// should be disabled, either because the tray was created with
+// [WithReadOnly] or because the most recent status says that the
+// current user isn't allowed to make changes. See
+// operatorIsCurrent.
+func (t *trayImpl) isReadOnly() bool {
+	return t.readOnly || t.notOperator.Load()
+}
+
+// unlessReadOnly returns a function that calls f unless the tray is
+// read-only. It is used for every action that changes Tailscale's
+// state so that such clicks are ignored even if an item that should
+// be disabled somehow isn't. See [WithReadOnly].
+func (t *trayImpl) unlessReadOnly(f func()) func() {
+	return func() {
+		if t.isReadOnly() {
+			slog.Debug("ignoring tray action in read-only mode")
+			return
+		}
+		f()
+	}
+}
+
+// optional returns a function that calls f if it is non-nil, so that
+// clicking an item whose callback wasn't provided does nothing instead
+// of panicking. Such clicks are logged with the callback's name.
+func optional(name string, f func()) func() {
+	return func() {
+		if f == nil {
+			slog.Debug("tray callback not set", "callback", name)
+			return
+		}
+		f()
+	}
+}
+
+// call calls f if it is non-nil.
+func call(f func()) {
+	if f != nil {
+		f()
+	}
+}
+
+// bind returns a function that calls f with v, or nil if f is nil, so
+// that callbacks that take arguments can be passed to optional and
+// call too.
+func bind[T any](f func(T), v T) func() {
+	if f == nil {
+		return nil
+	}
+	return func() { f(v) }
+}
+
+// bind2 is like bind for callbacks that take two arguments.
+func bind2[T, U any](f func(T, U), v T, w U) func() {
+	if f == nil {
+		return nil
+	}
+	return func() { f(v, w) }
+}
+
+// A clickSource is a menu item that reports clicks on a channel.
+type clickSource interface {
+	Clicked() <-chan struct{}
+}
+
+// bindClicks calls f each time that item is clicked until its channel
+// is closed. Items whose channel is nil, which some backends hand out
+// for items created before they're ready, are left unbound instead of
+// leaking a goroutine that can never finish. They're replaced with
+// fresh items, and so bound again, the next time the menu is built.
+func bindClicks(item clickSource, f func()) {
+	clicked := item.Clicked()
+	if clicked == nil {
+		slog.Warn("tray item has no click channel")
+		return
+	}
+
+	go func() {
+		for range clicked {
+			f()
+		}
+	}()
+}
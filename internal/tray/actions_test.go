@@ -0,0 +1,179 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+type fakeItem chan struct{}
+
+func (item fakeItem) Clicked() <-chan struct{} {
+	return item
+}
+
+func TestActionWiring(t *testing.T) {
+	fired := make(chan string, 8)
+	record := func(name string) func() {
+		return func() { fired <- name }
+	}
+
+	tr := New(Callbacks{
+		OnShowWithHint: func(ShowHint) { fired <- "show" },
+		OnConnToggle:   record("conn"),
+		OnServiceStartStop: func(start bool) {
+			if !start {
+				fired <- "stop service"
+			}
+		},
+		OnConnect:          record("connect"),
+		OnDisconnect:       record("disconnect"),
+		OnPanic:            record("panic"),
+		OnExitToggle:       record("exit"),
+		OnUseSuggestedExit: record("suggested"),
+		OnSelfNode:         record("self"),
+		OnRename:           record("rename"),
+		OnCopy:             func(string) { fired <- "copy" },
+		OnOpenURL:          func(url string) { fired <- url },
+		OnApplyUpdate:      record("update"),
+		OnOpenTaildropDir:  record("taildrop dir"),
+		OnClearTaildrop:    record("clear taildrop"),
+		OnCaptivePortal:    record("captive portal"),
+		OnAbout:            record("about"),
+		OnQuit:             record("quit"),
+	}).(*trayImpl)
+	tr.status = &tsutil.IPNStatus{
+		State:       ipn.NeedsLogin,
+		BrowseToURL: "https://login.tailscale.com/a/1234",
+		Prefs:       ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{}).View(),
+			Name:     "self.tail1234.ts.net.",
+			DNS:      tailcfg.DNSConfig{Proxied: true},
+		},
+	}
+
+	tests := map[menuAction]string{
+		actionShow:            "show",
+		actionConnToggle:      "conn",
+		actionServiceToggle:   "stop service",
+		actionConnect:         "connect",
+		actionDisconnect:      "disconnect",
+		actionPanic:           "panic",
+		actionExitToggle:      "exit",
+		actionSuggestedExit:   "suggested",
+		actionSelfNode:        "self",
+		actionSelfItem:        "self",
+		actionRename:          "rename",
+		actionCopyReport:      "copy",
+		actionCopyDNSSuffix:   "copy",
+		actionFinishLogin:     "https://login.tailscale.com/a/1234",
+		actionAdminConsole:    "https://login.tailscale.com/admin",
+		actionApplyUpdate:     "update",
+		actionOpenTaildropDir: "taildrop dir",
+		actionClearTaildrop:   "clear taildrop",
+		actionCaptivePortal:   "captive portal",
+		actionUpgradeDaemon:   "https://tailscale.com/kb/1067/update",
+		actionAbout:           "about",
+		actionQuit:            "quit",
+	}
+
+	actions := tr.actions()
+	require.Len(t, actions, len(tests))
+	for action, name := range tests {
+		item := make(fakeItem)
+		bindClicks(item, actions[action])
+		item <- struct{}{}
+		close(item)
+
+		select {
+		case got := <-fired:
+			require.Equal(t, name, got)
+		case <-time.After(time.Second):
+			t.Fatalf("no callback fired for %v", name)
+		}
+		require.Empty(t, fired)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	var fired []string
+	tr := New(Callbacks{
+		OnConnToggle: func() { fired = append(fired, "conn") },
+		OnExitToggle: func() { fired = append(fired, "exit") },
+	}).(*trayImpl)
+
+	covered := make(map[menuAction]bool)
+	for _, action := range triggerIDs {
+		covered[action] = true
+	}
+	require.Len(t, covered, len(tr.actions()))
+	require.Len(t, TriggerItemIDs(), len(triggerIDs))
+
+	require.ErrorIs(t, tr.Trigger("bogus"), ErrUnknownItem)
+	require.ErrorIs(t, tr.Trigger("connToggle"), ErrItemUnavailable)
+
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()})
+	require.NoError(t, tr.Trigger("connToggle"))
+	require.ErrorIs(t, tr.Trigger("exitToggle"), ErrItemUnavailable)
+	require.ErrorIs(t, tr.Trigger("about"), ErrItemUnavailable)
+	require.Equal(t, []string{"conn"}, fired)
+}
+
+func TestNilCallbacks(t *testing.T) {
+	tr := New(Callbacks{}, WithQRItems(true)).(*trayImpl)
+	tr.status = &tsutil.IPNStatus{
+		Prefs: ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{
+				Name:      "self.tail1234.ts.net.",
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			}).View(),
+			Name: "self.tail1234.ts.net.",
+			DNS:  tailcfg.DNSConfig{Proxied: true},
+		},
+	}
+
+	for action, f := range tr.actions() {
+		require.NotPanics(t, f, "action %v", action)
+	}
+	require.NotPanics(t, func() { tr.showSelfQR(selfAddrText) })
+	require.NotPanics(t, func() { tr.selectExitNode("exit") })
+	require.NotPanics(t, tr.toggleDefaultLANAccess)
+	require.NotPanics(t, func() { tr.copyPeerName("peer") })
+
+	host := &fakeMenuHost{}
+	tr.build(host, tr.status)
+	var click func([]*fakeMenuItem)
+	click = func(items []*fakeMenuItem) {
+		for _, item := range items {
+			if item.onClick != nil {
+				require.NotPanics(t, item.onClick, "item %q", item.title)
+			}
+			click(item.children)
+		}
+	}
+	click(host.items)
+}
+
+func TestBindClicksNil(t *testing.T) {
+	before := runtime.NumGoroutine()
+	bindClicks(fakeItem(nil), func() { t.Fatal("nil item clicked") })
+	require.Equal(t, before, runtime.NumGoroutine())
+
+	fired := make(chan struct{}, 1)
+	item := make(fakeItem)
+	bindClicks(item, func() { fired <- struct{}{} })
+	item <- struct{}{}
+	close(item)
+	<-fired
+}
@@ -0,0 +1,72 @@
+package tray
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// warningColor is the color of the badge on the warning status icon.
+var warningColor = color.NRGBA{R: 0xE5, G: 0xA5, B: 0x0A, A: 0xFF}
+
+// servingColor is the color of the badge on the status icon shown
+// while the local node is serving as an exit node.
+var servingColor = color.NRGBA{R: 0x1C, G: 0x71, B: 0xD8, A: 0xFF}
+
+// updateColor is the color of the badge on the status icon shown
+// while an update is waiting for a restart to be applied.
+var updateColor = color.NRGBA{R: 0x2E, G: 0xC2, B: 0x7E, A: 0xFF}
+
+// setupColor is the color of the badge on the status icon shown on a
+// machine where Tailscale has never been logged in.
+var setupColor = color.NRGBA{R: 0x91, G: 0x41, B: 0xAC, A: 0xFF}
+
+// fairColor and poorColor are the colors of the badges on the status
+// icons shown while the connection quality is only fair or poor.
+var (
+	fairColor = color.NRGBA{R: 0xF6, G: 0xD3, B: 0x2D, A: 0xFF}
+	poorColor = color.NRGBA{R: 0xE0, G: 0x1B, B: 0x24, A: 0xFF}
+)
+
+// badge returns a copy of img with a filled circle of color c drawn
+// over its bottom-right corner. It is used to derive variants of the
+// status icons without needing a separate asset for each one.
+func badge(img image.Image, c color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	r := min(b.Dx(), b.Dy()) / 4
+	fillCircle(dst, b.Max.X-r-1, b.Max.Y-r-1, r, c)
+	return dst
+}
+
+// fillCircle draws a filled circle of color c and radius r centered
+// on (cx, cy).
+func fillCircle(dst draw.Image, cx, cy, r int, c color.Color) {
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			dx, dy := x-cx, y-cy
+			if dx*dx+dy*dy <= r*r {
+				dst.Set(x, y, c)
+			}
+		}
+	}
+}
+
+// badgePNG is like badge but operates on PNG-encoded data.
+func badgePNG(data []byte, c color.Color) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, badge(img, c))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
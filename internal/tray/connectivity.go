@@ -0,0 +1,110 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+)
+
+// connCheckTimeout is the longest that a single connectivity check is
+// allowed to take. Checks are also limited to the interval between
+// them.
+const connCheckTimeout = 10 * time.Second
+
+// pingTarget checks that a peer or DERP relay can be reached. It is a
+// variable so that tests can avoid the Tailscale daemon.
+var pingTarget = tsutil.Ping
+
+// connectivityResult is the outcome of a connectivity check.
+type connectivityResult struct {
+	at time.Time
+	ok bool
+}
+
+// startConnectivityCheck starts checking connectivity if the tray was
+// configured with [WithConnectivityCheck] and it isn't already doing
+// so. It must be called with t.m held.
+func (t *trayImpl) startConnectivityCheck() {
+	if (t.connCheckTarget == "") || (t.connCheckInterval <= 0) || (t.connCheckDone != nil) {
+		return
+	}
+
+	stop := make(chan struct{})
+	t.connCheckDone = stop
+	go t.connectivityCheck(stop)
+}
+
+// stopConnectivityCheck stops the checks started by
+// startConnectivityCheck. It must be called with t.m held.
+func (t *trayImpl) stopConnectivityCheck() {
+	if t.connCheckDone != nil {
+		close(t.connCheckDone)
+		t.connCheckDone = nil
+	}
+}
+
+// connectivityCheck checks connectivity once immediately and then
+// every interval until stop is closed, cancelling any check that is
+// in progress when it is.
+func (t *trayImpl) connectivityCheck(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	tick := time.NewTicker(t.connCheckInterval)
+	defer tick.Stop()
+
+	for {
+		checkCtx, checkCancel := context.WithTimeout(ctx, min(t.connCheckInterval, connCheckTimeout))
+		err := pingTarget(checkCtx, t.connCheckTarget)
+		checkCancel()
+		if err != nil {
+			slog.Warn("connectivity check failed", "target", t.connCheckTarget, "err", err)
+		}
+
+		t.m.Lock()
+		select {
+		case <-stop:
+			t.m.Unlock()
+			return
+		default:
+		}
+		t.lastCheck = connectivityResult{at: t.currentTime(), ok: err == nil}
+		if t.host != nil {
+			t.host.Batch(t.updateConnCheck)
+		}
+		t.m.Unlock()
+
+		call(bind(t.OnConnectivityResult, err == nil))
+
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// updateConnCheck brings the connectivity check item up to date with
+// the most recent result. Those come from the checks rather than
+// IPNStatus, so it is also called directly by connectivityCheck.
+func (t *trayImpl) updateConnCheck() {
+	if t.connCheckItem == nil {
+		return
+	}
+	label, ok := connectivityText(t.lastCheck, t.currentTime(), t.formatter)
+	if t.dirty(connCheckHandle, label, ok) {
+		t.connCheckItem.SetTitle(label)
+		setVisible(t.connCheckItem, ok)
+	}
+}
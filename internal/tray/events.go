@@ -0,0 +1,164 @@
+package tray
+
+import (
+	"net/netip"
+	"time"
+	"unique"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/set"
+)
+
+var (
+	onlineHandle   = unique.Make("online")
+	exitNodeHandle = unique.Make("exitNode")
+	selfAddrHandle = unique.Make("selfAddr")
+)
+
+// An Event is a state transition that the tray notices and reports
+// via OnNotify so that the app can, for example, show a notification
+// for it.
+type Event string
+
+const (
+	// EventConnected is emitted when the local node comes online.
+	EventConnected Event = "connected"
+
+	// EventDisconnected is emitted when the local node goes offline.
+	EventDisconnected Event = "disconnected"
+
+	// EventExitNodeChanged is emitted when the exit node in use
+	// changes, including when one is enabled or disabled.
+	EventExitNodeChanged Event = "exit-node-changed"
+
+	// EventConnectionUnstable is emitted in place of EventConnected
+	// and EventDisconnected once the connection has gone up or down
+	// flapThreshold times within flapWindow. Neither of those is
+	// emitted again until the connection settles down. Use
+	// [ConnectionFlaps] with [Tray.History] to find out how often it
+	// has changed.
+	EventConnectionUnstable Event = "connection-unstable"
+)
+
+const (
+	// flapWindow is how far back connection state changes are counted
+	// to decide whether the connection is unstable.
+	flapWindow = 2 * time.Minute
+
+	// flapThreshold is the number of connection state changes within
+	// flapWindow at which the connection is considered unstable.
+	flapThreshold = 3
+
+	// pathSettle is the number of path reports in a row that a peer's
+	// new path has to appear in before it counts.
+	pathSettle = 3
+)
+
+func peerPathHandle(id tailcfg.StableNodeID) unique.Handle[string] {
+	return unique.Make("peerPath:" + string(id))
+}
+
+// updatePeerPaths records the paths in s and queues the changes that
+// have settled. For each active peer, prev holds the path that last
+// settled, or nil if none has yet, the path most recently seen, and
+// the number of reports in a row that it has been seen in. Peers that
+// are no longer active are forgotten so that their path settles anew
+// when they next connect.
+func (t *trayImpl) updatePeerPaths(s *tsutil.PeerPathStatus) {
+	if (t.OnPeerPathChanged == nil) || (t.prev == nil) {
+		return
+	}
+
+	for id := range t.activePaths {
+		if _, ok := s.Direct[id]; !ok {
+			delete(t.prev, peerPathHandle(id))
+		}
+	}
+	t.activePaths = make(set.Set[tailcfg.StableNodeID], len(s.Direct))
+
+	for id, direct := range s.Direct {
+		t.activePaths.Add(id)
+
+		key := peerPathHandle(id)
+		settled, seen, count := any(nil), direct, 0
+		if prev, ok := t.prev[key]; ok {
+			settled, seen, count = prev[0], prev[1].(bool), prev[2].(int)
+		}
+		if direct != seen {
+			seen, count = direct, 0
+		}
+		count = min(count+1, pathSettle)
+
+		if (count == pathSettle) && (settled != seen) {
+			if settled != nil {
+				t.events.pushPathChange(id, seen)
+			}
+			settled = seen
+		}
+		t.prev[key] = []any{settled, seen, count}
+	}
+}
+
+// eventQueue holds events that have been noticed but not yet passed
+// to OnNotify. Events disabled in prefs are never queued at all.
+type eventQueue struct {
+	prefs   map[string]bool
+	pending []Event
+
+	// transitions are changes of the connection state, true being
+	// connected, that have yet to be passed to OnConnected and
+	// OnDisconnected. Unlike events, they aren't affected by prefs.
+	transitions []bool
+
+	// addrChanges are changes of the local node's address that have
+	// yet to be passed to OnSelfAddrChanged. Like transitions, they
+	// aren't affected by prefs.
+	addrChanges []addrChange
+
+	// pathChanges are changes of the paths to peers that have yet to
+	// be passed to OnPeerPathChanged.
+	pathChanges []pathChange
+}
+
+// An addrChange is a change of the local node's address.
+type addrChange struct {
+	old, new netip.Addr
+}
+
+// A pathChange is a change of the path to a peer.
+type pathChange struct {
+	id     tailcfg.StableNodeID
+	direct bool
+}
+
+// push queues event unless it has been disabled.
+func (q *eventQueue) push(event Event) {
+	if enabled, ok := q.prefs[string(event)]; ok && !enabled {
+		return
+	}
+	q.pending = append(q.pending, event)
+}
+
+// pushTransition queues a change of the connection state.
+func (q *eventQueue) pushTransition(online bool) {
+	q.transitions = append(q.transitions, online)
+}
+
+// pushAddrChange queues a change of the local node's address.
+func (q *eventQueue) pushAddrChange(old, new netip.Addr) {
+	q.addrChanges = append(q.addrChanges, addrChange{old: old, new: new})
+}
+
+// pushPathChange queues a change of the path to a peer.
+func (q *eventQueue) pushPathChange(id tailcfg.StableNodeID, direct bool) {
+	q.pathChanges = append(q.pathChanges, pathChange{id: id, direct: direct})
+}
+
+// take removes and returns all of the currently queued events,
+// connection state changes, address changes, and path changes.
+func (q *eventQueue) take() ([]Event, []bool, []addrChange, []pathChange) {
+	pending, transitions, addrChanges, pathChanges := q.pending, q.transitions, q.addrChanges, q.pathChanges
+	q.pending, q.transitions, q.addrChanges, q.pathChanges = nil, nil, nil, nil
+	return pending, transitions, addrChanges, pathChanges
+}
@@ -0,0 +1,148 @@
+package tray
+
+import (
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// localeFormats are the format strings used to describe amounts of
+// time in a single locale. Each is passed a single integer, except
+// for duration which is passed hours and then minutes.
+type localeFormats struct {
+	justNow    string
+	minutesAgo string
+	hoursAgo   string
+	daysAgo    string
+	duration   string
+	latency    string
+}
+
+// locales are the locales that times can be formatted for. The first
+// is the default.
+var locales = []struct {
+	tag     language.Tag
+	formats localeFormats
+}{
+	{
+		tag: language.English,
+		formats: localeFormats{
+			justNow:    "just now",
+			minutesAgo: "%dm ago",
+			hoursAgo:   "%dh ago",
+			daysAgo:    "%dd ago",
+			duration:   "%dh %dm",
+			latency:    "%dms",
+		},
+	},
+	{
+		tag: language.German,
+		formats: localeFormats{
+			justNow:    "gerade eben",
+			minutesAgo: "vor %d Min.",
+			hoursAgo:   "vor %d Std.",
+			daysAgo:    "vor %d Tg.",
+			duration:   "%d Std. %d Min.",
+			latency:    "%d ms",
+		},
+	},
+	{
+		tag: language.French,
+		formats: localeFormats{
+			justNow:    "à l'instant",
+			minutesAgo: "il y a %d min",
+			hoursAgo:   "il y a %d h",
+			daysAgo:    "il y a %d j",
+			duration:   "%d h %d min",
+			latency:    "%d ms",
+		},
+	},
+}
+
+var localeMatcher = func() language.Matcher {
+	tags := make([]language.Tag, 0, len(locales))
+	for _, locale := range locales {
+		tags = append(tags, locale.tag)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// formatter formats numbers and amounts of time for display in the
+// menu according to a locale. The zero value formats for the default
+// locale.
+type formatter struct {
+	printer *message.Printer
+	formats *localeFormats
+}
+
+// newFormatter returns a formatter for the supported locale that most
+// closely matches tag.
+func newFormatter(tag language.Tag) formatter {
+	_, i, _ := localeMatcher.Match(tag)
+	return formatter{
+		printer: message.NewPrinter(locales[i].tag),
+		formats: &locales[i].formats,
+	}
+}
+
+// orDefault returns f, or a formatter for the default locale if f is
+// the zero value.
+func (f formatter) orDefault() formatter {
+	if f.printer == nil {
+		return defaultFormatter
+	}
+	return f
+}
+
+var defaultFormatter = newFormatter(locales[0].tag)
+
+// since formats d as a short, human-readable amount of time in the
+// past, such as "2h ago". It is deliberately coarse, with nothing
+// finer than a minute, so that the result doesn't change on every
+// update.
+func (f formatter) since(d time.Duration) string {
+	f = f.orDefault()
+	switch {
+	case d < time.Minute:
+		return f.printer.Sprintf(f.formats.justNow)
+	case d < time.Hour:
+		return f.printer.Sprintf(f.formats.minutesAgo, int64(d/time.Minute))
+	case d < 24*time.Hour:
+		return f.printer.Sprintf(f.formats.hoursAgo, int64(d/time.Hour))
+	default:
+		return f.printer.Sprintf(f.formats.daysAgo, int64(d/(24*time.Hour)))
+	}
+}
+
+// duration formats d as a number of hours and minutes, such as
+// "2h 13m".
+func (f formatter) duration(d time.Duration) string {
+	f = f.orDefault()
+	return f.printer.Sprintf(f.formats.duration, int64(d/time.Hour), int64(d%time.Hour/time.Minute))
+}
+
+// rateUnits are the units used by rate, each 1000 times the last.
+var rateUnits = []string{"B/s", "KB/s", "MB/s", "GB/s"}
+
+// rate formats a rate in bytes per second using the largest unit that
+// keeps the number at least 1, such as "1.2 MB/s". Only numbers less
+// than 10 get a decimal place.
+func (f formatter) rate(rate float64) string {
+	f = f.orDefault()
+	i := 0
+	for (rate >= 1000) && (i < len(rateUnits)-1) {
+		rate /= 1000
+		i++
+	}
+	if (i == 0) || (rate >= 10) {
+		return f.printer.Sprintf("%.0f %v", rate, rateUnits[i])
+	}
+	return f.printer.Sprintf("%.1f %v", rate, rateUnits[i])
+}
+
+// latency formats d as a whole number of milliseconds, such as "12ms".
+func (f formatter) latency(d time.Duration) string {
+	f = f.orDefault()
+	return f.printer.Sprintf(f.formats.latency, d.Milliseconds())
+}
@@ -0,0 +1,67 @@
+package tray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestFormatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        formatter
+		since    []string
+		duration string
+		latency  string
+		rates    []string
+	}{
+		{
+			name:     "Default",
+			since:    []string{"just now", "5m ago", "2h ago", "1,234d ago"},
+			duration: "2h 13m",
+			latency:  "12ms",
+			rates:    []string{"0 B/s", "999 B/s", "1.2 MB/s", "2,000 GB/s"},
+		},
+		{
+			name:     "German",
+			f:        newFormatter(language.MustParse("de-AT")),
+			since:    []string{"gerade eben", "vor 5 Min.", "vor 2 Std.", "vor 1.234 Tg."},
+			duration: "2 Std. 13 Min.",
+			latency:  "12 ms",
+			rates:    []string{"0 B/s", "999 B/s", "1,2 MB/s", "2.000 GB/s"},
+		},
+		{
+			name:     "French",
+			f:        newFormatter(language.French),
+			since:    []string{"à l'instant", "il y a 5 min", "il y a 2 h", "il y a 1 234 j"},
+			duration: "2 h 13 min",
+			latency:  "12 ms",
+			rates:    []string{"0 B/s", "999 B/s", "1,2 MB/s", "2 000 GB/s"},
+		},
+		{
+			name:     "Unsupported",
+			f:        newFormatter(language.Japanese),
+			since:    []string{"just now", "5m ago", "2h ago", "1,234d ago"},
+			duration: "2h 13m",
+			latency:  "12ms",
+			rates:    []string{"0 B/s", "999 B/s", "1.2 MB/s", "2,000 GB/s"},
+		},
+	}
+
+	rates := []float64{0, 999, 1_234_567, 2e12}
+	since := []time.Duration{30 * time.Second, 5*time.Minute + 40*time.Second, 2 * time.Hour, 1234 * 24 * time.Hour}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, d := range since {
+				require.Equal(t, test.since[i], test.f.since(d))
+			}
+			require.Equal(t, test.duration, test.f.duration(2*time.Hour+13*time.Minute+59*time.Second))
+			require.Equal(t, test.latency, test.f.latency(12500*time.Microsecond))
+			for i, rate := range rates {
+				require.Equal(t, test.rates[i], test.f.rate(rate))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// healthServer serves the current status as JSON. See
+// [WithHealthEndpoint].
+type healthServer struct {
+	ln  net.Listener
+	srv *http.Server
+}
+
+// checkLoopback returns an error unless addr, which must include a
+// port, only refers to the loopback interface.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("parse host: %w", err)
+	}
+	if !ip.IsLoopback() {
+		return errors.New("not a loopback address")
+	}
+	return nil
+}
+
+// startHealthServer starts serving the health endpoint if the tray
+// was configured with [WithHealthEndpoint] and it isn't already doing
+// so. It must be called with t.m held.
+func (t *trayImpl) startHealthServer() {
+	if (t.healthAddr == "") || (t.health != nil) {
+		return
+	}
+
+	err := checkLoopback(t.healthAddr)
+	if err != nil {
+		slog.Error("refusing to serve tray health endpoint", "addr", t.healthAddr, "err", err)
+		return
+	}
+
+	ln, err := net.Listen("tcp", t.healthAddr)
+	if err != nil {
+		slog.Error("listen for tray health endpoint", "addr", t.healthAddr, "err", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", t.serveHealth)
+	srv := &http.Server{Handler: mux}
+	t.health = &healthServer{ln: ln, srv: srv}
+
+	go func() {
+		err := srv.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("serve tray health endpoint", "err", err)
+		}
+	}()
+}
+
+// stopHealthServer stops the server started by startHealthServer. It
+// must be called with t.m held, so it doesn't wait for requests in
+// progress, which need t.m themselves, to finish.
+func (t *trayImpl) stopHealthServer() {
+	if t.health == nil {
+		return
+	}
+	err := t.health.srv.Close()
+	if err != nil {
+		slog.Error("close tray health endpoint", "err", err)
+	}
+	t.health = nil
+}
+
+func (t *trayImpl) serveHealth(rw http.ResponseWriter, req *http.Request) {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	if status == nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("{}\n"))
+		return
+	}
+
+	err := json.NewEncoder(rw).Encode(status.Summary())
+	if err != nil {
+		slog.Error("write tray health response", "err", err)
+	}
+}
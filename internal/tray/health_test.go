@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	require.NoError(t, checkLoopback("localhost:9191"))
+	require.NoError(t, checkLoopback("127.0.0.1:9191"))
+	require.NoError(t, checkLoopback("[::1]:9191"))
+	require.Error(t, checkLoopback(":9191"))
+	require.Error(t, checkLoopback("0.0.0.0:9191"))
+	require.Error(t, checkLoopback("192.168.1.2:9191"))
+	require.Error(t, checkLoopback("localhost"))
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	tr := New(Callbacks{}, WithHealthEndpoint("127.0.0.1:0")).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.NotNil(t, tr.health)
+	url := "http://" + tr.health.ln.Addr().String() + "/"
+
+	rsp, err := http.Get(url)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var summary tsutil.StatusSummary
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&summary))
+	require.True(t, summary.Online)
+	require.Equal(t, "Running", summary.State)
+
+	tr.m.Lock()
+	tr.reset()
+	tr.m.Unlock()
+	require.Nil(t, tr.health)
+	_, err = http.Get(url)
+	require.Error(t, err)
+
+	tr = New(Callbacks{}, WithHealthEndpoint("0.0.0.0:0")).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.health)
+}
@@ -0,0 +1,99 @@
+package tray
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/health"
+	"tailscale.com/tailcfg"
+)
+
+// historySize is the number of status transitions that are kept in
+// the history. Older ones are discarded.
+const historySize = 64
+
+// A StatusEvent is a change in the status of the local node, as
+// recorded in the tray's history.
+type StatusEvent struct {
+	Time     time.Time
+	Online   bool
+	ExitNode tailcfg.StableNodeID
+
+	// Warnings are the codes of the health warnings that were active,
+	// sorted.
+	Warnings []health.WarnableCode
+}
+
+func (e StatusEvent) sameState(other StatusEvent) bool {
+	return (e.Online == other.Online) &&
+		(e.ExitNode == other.ExitNode) &&
+		slices.Equal(e.Warnings, other.Warnings)
+}
+
+// statusEvent returns a StatusEvent describing status at the given
+// time.
+func statusEvent(now time.Time, status *tsutil.IPNStatus) StatusEvent {
+	event := StatusEvent{
+		Time:   now,
+		Online: status.Online(),
+	}
+	if status.Prefs.Valid() {
+		event.ExitNode = status.Prefs.ExitNodeID()
+	}
+	if status.Health != nil {
+		event.Warnings = slices.Sorted(maps.Keys(status.Health.Warnings))
+	}
+	return event
+}
+
+// ConnectionFlaps returns the number of times that the local node went
+// online or offline in events, which should be ordered oldest first,
+// within window of the most recent one.
+func ConnectionFlaps(events []StatusEvent, window time.Duration) int {
+	if len(events) == 0 {
+		return 0
+	}
+
+	since := events[len(events)-1].Time.Add(-window)
+	var flaps int
+	for i := 1; i < len(events); i++ {
+		if events[i].Time.Before(since) {
+			continue
+		}
+		if events[i].Online != events[i-1].Online {
+			flaps++
+		}
+	}
+	return flaps
+}
+
+// history is a fixed-size ring buffer of status transitions.
+type history struct {
+	events [historySize]StatusEvent
+	next   int
+	len    int
+}
+
+// record adds event to the history if it differs in anything but time
+// from the most recently recorded one, overwriting the oldest event if
+// the history is full.
+func (h *history) record(event StatusEvent) {
+	if (h.len > 0) && h.events[(h.next+historySize-1)%historySize].sameState(event) {
+		return
+	}
+
+	h.events[h.next] = event
+	h.next = (h.next + 1) % historySize
+	h.len = min(h.len+1, historySize)
+}
+
+// all returns the recorded events, oldest first.
+func (h *history) all() []StatusEvent {
+	events := make([]StatusEvent, 0, h.len)
+	for i := range h.len {
+		events = append(events, h.events[(h.next-h.len+i+historySize)%historySize])
+	}
+	return events
+}
@@ -0,0 +1,46 @@
+package tray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"tailscale.com/health"
+)
+
+func TestConnectionFlaps(t *testing.T) {
+	require.Zero(t, ConnectionFlaps(nil, time.Minute))
+
+	start := time.Unix(0, 0)
+	events := []StatusEvent{
+		{Time: start, Online: true},
+		{Time: start.Add(time.Minute), Online: false},
+		{Time: start.Add(5 * time.Minute), Online: true},
+		{Time: start.Add(5*time.Minute + time.Second), Online: true, ExitNode: "exit"},
+		{Time: start.Add(6 * time.Minute), Online: false},
+	}
+	require.Equal(t, 2, ConnectionFlaps(events, 2*time.Minute))
+	require.Equal(t, 3, ConnectionFlaps(events, time.Hour))
+}
+
+func TestHistory(t *testing.T) {
+	var h history
+	require.Empty(t, h.all())
+
+	start := time.Unix(0, 0)
+	h.record(StatusEvent{Time: start, Online: true})
+	h.record(StatusEvent{Time: start.Add(time.Second), Online: true})
+	h.record(StatusEvent{Time: start.Add(2 * time.Second), Online: true, Warnings: []health.WarnableCode{"warn"}})
+	require.Equal(t, []StatusEvent{
+		{Time: start, Online: true},
+		{Time: start.Add(2 * time.Second), Online: true, Warnings: []health.WarnableCode{"warn"}},
+	}, h.all())
+
+	for i := range 2 * historySize {
+		h.record(StatusEvent{Time: start.Add(time.Duration(i) * time.Minute), Online: i%2 == 0})
+	}
+	events := h.all()
+	require.Len(t, events, historySize)
+	require.Equal(t, start.Add(historySize*time.Minute), events[0].Time)
+	require.Equal(t, start.Add((2*historySize-1)*time.Minute), events[len(events)-1].Time)
+}
@@ -0,0 +1,211 @@
+package tray
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"slices"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	//go:embed status-icon-active.png
+	statusIconActiveData []byte
+	//go:embed status-icon-active-template.png
+	statusIconActiveTemplateData []byte
+	statusIconActive             = orGenerated("active", newIcon(statusIconActiveData, statusIconActiveTemplateData))
+
+	//go:embed status-icon-inactive.png
+	statusIconInactiveData []byte
+	//go:embed status-icon-inactive-template.png
+	statusIconInactiveTemplateData []byte
+	statusIconInactive             = orGenerated("inactive", newIcon(statusIconInactiveData, statusIconInactiveTemplateData))
+
+	//go:embed status-icon-exit-node.png
+	statusIconExitNodeData []byte
+	//go:embed status-icon-exit-node-template.png
+	statusIconExitNodeTemplateData []byte
+	statusIconExitNode             = orGenerated("exit node", newIcon(statusIconExitNodeData, statusIconExitNodeTemplateData))
+
+	statusIconWarning = orGenerated("warning", newBadgedIcon(statusIconInactiveData, statusIconInactiveTemplateData, warningColor))
+	statusIconSetup   = orGenerated("setup", newBadgedIcon(statusIconInactiveData, statusIconInactiveTemplateData, setupColor))
+	statusIconServing = orGenerated("serving", newBadgedIcon(statusIconActiveData, statusIconActiveTemplateData, servingColor))
+	statusIconUpdate  = orGenerated("update", newBadgedIcon(statusIconActiveData, statusIconActiveTemplateData, updateColor))
+	statusIconFair    = orGenerated("fair", newBadgedIcon(statusIconActiveData, statusIconActiveTemplateData, fairColor))
+	statusIconPoor    = orGenerated("poor", newBadgedIcon(statusIconActiveData, statusIconActiveTemplateData, poorColor))
+)
+
+// statusIcons are all of the status icons by name so that they can be
+// checked by ValidateIcons.
+var statusIcons = map[string]*icon{
+	"active":    statusIconActive,
+	"inactive":  statusIconInactive,
+	"exit node": statusIconExitNode,
+	"warning":   statusIconWarning,
+	"setup":     statusIconSetup,
+	"serving":   statusIconServing,
+	"update":    statusIconUpdate,
+	"fair":      statusIconFair,
+	"poor":      statusIconPoor,
+}
+
+// ValidateIcons returns an error describing every status icon that is
+// unusable, such as because an embedded asset is corrupt. The icons
+// themselves are decoded when the package is initialized, so this
+// only has to fully decode their template variants, which are
+// otherwise only checked superficially.
+func ValidateIcons() error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(statusIcons)) {
+		ic := statusIcons[name]
+		if ic.err != nil {
+			errs = append(errs, fmt.Errorf("%v icon: %w", name, ic.err))
+			continue
+		}
+		if ic.img.Bounds().Empty() {
+			errs = append(errs, fmt.Errorf("%v icon is empty", name))
+		}
+		if ic.template == nil {
+			continue
+		}
+		if _, err := png.Decode(bytes.NewReader(ic.template)); err != nil {
+			errs = append(errs, fmt.Errorf("%v template icon: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// icon is a decoded status icon. If decoding failed, err is the
+// reason why and the icon should not be used.
+type icon struct {
+	data []byte
+	img  image.Image
+	err  error
+
+	// template is a PNG-encoded monochrome variant of the icon for
+	// platforms that use template icons. It may be nil.
+	template []byte
+
+	// fallback is a generated icon to use in place of this one if it
+	// couldn't be decoded. It may be nil.
+	fallback *icon
+
+	scaleOnce sync.Once
+	scaled    []image.Image
+}
+
+// pixmapSizes are the sizes, in pixels, that icons are scaled to for
+// tray hosts that pick whichever size suits their panel best.
+var pixmapSizes = []int{16, 22, 24, 32, 48, 64}
+
+// pixmaps returns the icon at each of pixmapSizes, followed by the
+// icon at its original size. They are only generated the first time
+// that they're needed.
+func (ic *icon) pixmaps() []image.Image {
+	ic.scaleOnce.Do(func() {
+		ic.scaled = iconPixmaps(ic.img)
+	})
+	return ic.scaled
+}
+
+// iconPixmaps returns img scaled to each of pixmapSizes that is
+// smaller than it, followed by img itself.
+func iconPixmaps(img image.Image) []image.Image {
+	b := img.Bounds()
+	images := make([]image.Image, 0, len(pixmapSizes)+1)
+	for _, size := range pixmapSizes {
+		if (size >= b.Dx()) || (size >= b.Dy()) {
+			break
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		images = append(images, dst)
+	}
+	return append(images, img)
+}
+
+func newIcon(data, template []byte) *icon {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return &icon{err: err}
+	}
+	if template != nil {
+		_, err := png.DecodeConfig(bytes.NewReader(template))
+		if err != nil {
+			return &icon{err: err}
+		}
+	}
+	return &icon{data: data, img: img, template: template}
+}
+
+// newBadgedIcon is like newIcon but adds a badge of color c to both
+// variants of the icon.
+func newBadgedIcon(data, template []byte, c color.Color) *icon {
+	data, err := badgePNG(data, c)
+	if err != nil {
+		return &icon{err: err}
+	}
+	template, err = badgePNG(template, c)
+	if err != nil {
+		return &icon{err: err}
+	}
+	return newIcon(data, template)
+}
+
+// generatedIconSize is the width and height, in pixels, of the icons
+// returned by defaultGeneratedIcon.
+const generatedIconSize = 64
+
+// generatedIconColors are the colors of the icons returned by
+// defaultGeneratedIcon for each state.
+var generatedIconColors = map[string]color.Color{
+	"active":    color.NRGBA{R: 0x26, G: 0xA2, B: 0x69, A: 0xFF},
+	"inactive":  color.NRGBA{R: 0x9A, G: 0x99, B: 0x96, A: 0xFF},
+	"exit node": color.NRGBA{R: 0x91, G: 0x41, B: 0xAC, A: 0xFF},
+	"warning":   warningColor,
+	"setup":     setupColor,
+	"serving":   servingColor,
+	"update":    updateColor,
+	"fair":      fairColor,
+	"poor":      poorColor,
+}
+
+// defaultGeneratedIcon returns a plain circle in the color of the
+// named state, as listed in statusIcons, for use when the embedded
+// asset for that state is missing or corrupt, such as because a
+// downstream build stripped it. Unknown states are drawn in the
+// inactive color.
+func defaultGeneratedIcon(state string) *icon {
+	c, ok := generatedIconColors[state]
+	if !ok {
+		c = generatedIconColors["inactive"]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, generatedIconSize, generatedIconSize))
+	draw.Draw(img, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	r := generatedIconSize/2 - 2
+	fillCircle(img, generatedIconSize/2, generatedIconSize/2, r, c)
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return &icon{err: err}
+	}
+	return &icon{data: buf.Bytes(), img: img}
+}
+
+// orGenerated sets the fallback of ic to an icon generated for state
+// if ic couldn't be decoded. It returns ic.
+func orGenerated(state string, ic *icon) *icon {
+	if ic.err != nil {
+		ic.fallback = defaultGeneratedIcon(state)
+	}
+	return ic
+}
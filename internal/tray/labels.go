@@ -0,0 +1,514 @@
+package tray
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// defaultMaxNameLength is the default maximum number of characters of
+// a node's name that are shown in a menu item before it is
+// ellipsized.
+const defaultMaxNameLength = 32
+
+// ellipsize shortens s to at most max characters, replacing the end
+// of it with an ellipsis if necessary. Characters are counted as
+// runes so that multi-byte characters are never cut in half. If max
+// is not positive, s is returned unchanged.
+func ellipsize(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+// selfTitle returns a description of the local node in the given
+// format, showing its addresses of the given family and ellipsizing
+// its name to maxName characters, and whether or not it is connected.
+func selfTitle(status *tsutil.IPNStatus, format SelfLabelFormat, family AddrFamily, maxName int) (string, bool) {
+	if !status.DaemonReachable() {
+		return "Tailscale daemon not running", false
+	}
+
+	addr := status.SelfAddr()
+	if !addr.IsValid() {
+		switch status.LoginState() {
+		case tsutil.NeverLoggedIn:
+			return "Not set up", false
+		case tsutil.LoggedOut:
+			return "Logged out", false
+		case tsutil.KeyExpired:
+			return "Key expired", false
+		default:
+			return "Not connected", false
+		}
+	}
+
+	name := ellipsize(status.NetMap.SelfNode.DisplayName(true), maxName)
+	switch format {
+	case SelfLabelAddr:
+		return selfAddrs(status, family), true
+	case SelfLabelName:
+		return name, true
+	default:
+		return fmt.Sprintf("%v (%v)", name, selfAddrs(status, family)), true
+	}
+}
+
+// loginText returns the label for the login item and whether or not
+// it should be shown at all. It finishes a login that is already in
+// progress if there is one. Otherwise, it starts one with a label
+// suited to why a login is needed, if it can.
+func loginText(status *tsutil.IPNStatus, canStart bool) (string, bool) {
+	state := status.LoginState()
+	if state == tsutil.LoggedIn {
+		return "", false
+	}
+	if _, ok := status.PendingAuthURL(); ok {
+		return "Finish login in browser", true
+	}
+	if !canStart {
+		return "", false
+	}
+
+	switch state {
+	case tsutil.LoggedOut:
+		return "Log in", true
+	case tsutil.KeyExpired:
+		return "Re-authenticate", true
+	default:
+		return "Set up Tailscale", true
+	}
+}
+
+// selfAddrText returns the local node's primary address, or an empty
+// string if it doesn't have one.
+func selfAddrText(status *tsutil.IPNStatus) string {
+	if addr := status.SelfAddr(); addr.IsValid() {
+		return addr.String()
+	}
+	return ""
+}
+
+// selfAddrs returns the local node's addresses of the given family,
+// falling back to its primary address if it has none of that family.
+func selfAddrs(status *tsutil.IPNStatus, family AddrFamily) string {
+	addr4, addr6 := status.SelfAddr4(), status.SelfAddr6()
+	switch {
+	case (family == IPv4) && addr4.IsValid():
+		return addr4.String()
+	case (family == IPv6) && addr6.IsValid():
+		return addr6.String()
+	case (family == BothFamilies) && addr4.IsValid() && addr6.IsValid():
+		return fmt.Sprintf("%v, %v", addr4, addr6)
+	}
+	return status.SelfAddr().String()
+}
+
+// selfInfoText returns the labels of the informational items in the
+// local node's submenu. Either is empty if it isn't known. Versions
+// are shortened to their release number, dropping any commit hashes.
+func selfInfoText(status *tsutil.IPNStatus) (os, version string) {
+	if v := status.SelfOS(); v != "" {
+		os = fmt.Sprintf("OS: %v", v)
+	}
+	if v, _, _ := strings.Cut(status.ClientVersion(), "-"); v != "" {
+		version = fmt.Sprintf("Version: %v", v)
+	}
+	return os, version
+}
+
+func connToggleText(online bool) string {
+	if online {
+		return "Disconnect"
+	}
+
+	return "Connect"
+}
+
+// showText returns the label for the item that shows or hides the
+// app's window.
+func showText(hide bool) string {
+	if hide {
+		return "Hide"
+	}
+	return "Show"
+}
+
+// serviceText returns the label for the item that starts and stops
+// the Tailscale daemon.
+func serviceText(running bool) string {
+	if running {
+		return "Stop Tailscale service"
+	}
+	return "Start Tailscale service"
+}
+
+// exitToggleText returns the label for the exit node toggle. If flag
+// is true, the flag of the current exit node's country is included if
+// it is known. If auto is the current exit node, the label notes that
+// it was selected automatically.
+func exitToggleText(status *tsutil.IPNStatus, flag bool, auto tailcfg.StableNodeID) string {
+	if !status.ExitNodeActive() {
+		return "Enable exit node"
+	}
+
+	// TODO: Show some actual information about the current exit node?
+	label := "Disable exit node"
+	if f := flagEmoji(status.ExitNodeLocation()); flag && (f != "") {
+		label += " " + f
+	}
+	if (auto != "") && (status.Prefs.ExitNodeID() == auto) {
+		label += " (auto)"
+	}
+	return label
+}
+
+// flagEmoji returns the emoji flag for an ISO 3166-1 alpha-2 country
+// code, or an empty string if code isn't one.
+func flagEmoji(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+
+	var flag strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if (c < 'A') || (c > 'Z') {
+			return ""
+		}
+		flag.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return flag.String()
+}
+
+// accessoryLabel returns the short text shown next to the tray icon
+// when the tray was created with [WithExitBadge]. It is empty unless
+// an exit node is in use.
+func accessoryLabel(status *tsutil.IPNStatus) string {
+	if status.Online() && status.ExitNodeActive() {
+		return "EXIT"
+	}
+	return ""
+}
+
+// tunnelText returns the label for the item describing how traffic is
+// routed through the exit node and whether or not it should be shown
+// at all.
+func tunnelText(status *tsutil.IPNStatus) (string, bool) {
+	if !status.ExitNodeActive() {
+		return "", false
+	}
+	if status.FullTunnel() {
+		return "All traffic via exit node", true
+	}
+	return "Local network bypasses exit node", true
+}
+
+// exitRoutingText returns the label for the item showing whether
+// traffic is actually flowing through the exit node, such as
+// "Exit node: active (routing)", and whether or not it should be shown
+// at all.
+func exitRoutingText(status *tsutil.IPNStatus) (string, bool) {
+	routing, ok := status.ExitNodeRouting()
+	switch {
+	case !ok:
+		return "", false
+	case routing:
+		return "Exit node: active (routing)", true
+	default:
+		return "Exit node: active (idle)", true
+	}
+}
+
+// incomingText returns the label for the item summarizing whether
+// other devices can connect to the local node, such as
+// "Incoming: blocked (shields up)", and whether or not it should be
+// shown at all.
+func incomingText(status *tsutil.IPNStatus) (string, bool) {
+	if !status.Online() {
+		return "", false
+	}
+	if allowed, reason := status.IncomingAllowed(); !allowed {
+		return fmt.Sprintf("Incoming: blocked (%v)", reason), true
+	}
+	return "Incoming: allowed", true
+}
+
+// identityText returns the label for the item showing what owns the
+// local node, such as "Tags: tag:server, tag:ci" for a tagged node or
+// "Owner: user@example.com" otherwise, and whether or not it should
+// be shown at all.
+func identityText(status *tsutil.IPNStatus) (string, bool) {
+	if tags := status.SelfTags(); len(tags) > 0 {
+		return "Tags: " + strings.Join(tags, ", "), true
+	}
+	if owner := status.SelfOwner(); owner != "" {
+		return "Owner: " + owner, true
+	}
+	return "", false
+}
+
+// routeConflictText returns the label and tooltip for the item warning
+// about subnets that are routed by more than one peer, such as
+// "Route conflict: 10.0.0.0/24 advertised by 2 nodes", and whether or
+// not it should be shown at all. Only the first conflict is named in
+// the label, but the tooltip lists them all along with the nodes
+// involved.
+func routeConflictText(status *tsutil.IPNStatus) (string, string, bool) {
+	conflicts := status.RouteConflicts()
+	if !status.Online() || (len(conflicts) == 0) {
+		return "", "", false
+	}
+
+	label := fmt.Sprintf("Route conflict: %v advertised by %v nodes", conflicts[0].Prefix, len(conflicts[0].Nodes))
+	if len(conflicts) > 1 {
+		label += fmt.Sprintf(" (+%v more)", len(conflicts)-1)
+	}
+
+	lines := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		names := make([]string, 0, len(c.Nodes))
+		for _, id := range c.Nodes {
+			names = append(names, status.Peers[id].DisplayName(true))
+		}
+		lines = append(lines, fmt.Sprintf("%v: %v", c.Prefix, strings.Join(names, ", ")))
+	}
+	return label, strings.Join(lines, "\n"), true
+}
+
+// advertisedRoutesText returns the label for the submenu of the local
+// node's advertised subnet routes, such as
+// "Advertised routes: 1 of 2 approved".
+func advertisedRoutesText(routes []tsutil.AdvertisedRoute) string {
+	var approved int
+	for _, route := range routes {
+		if route.Approved {
+			approved++
+		}
+	}
+	if approved == len(routes) {
+		return "Advertised routes: all approved"
+	}
+	return fmt.Sprintf("Advertised routes: %v of %v approved", approved, len(routes))
+}
+
+// advertisedRouteText returns the label for one of the local node's
+// advertised subnet routes, such as "10.0.0.0/24 (pending approval)".
+func advertisedRouteText(route tsutil.AdvertisedRoute) string {
+	if route.Approved {
+		return fmt.Sprintf("✓ %v (approved)", route.Prefix)
+	}
+	return fmt.Sprintf("%v (pending approval)", route.Prefix)
+}
+
+// daemonVersionText returns the tooltip for the item warning that the
+// local Tailscale daemon is older than min, such as
+// "Tailscale 1.70.0 is older than 1.80.0, the oldest supported
+// version", and whether or not it should be shown at all.
+func daemonVersionText(status *tsutil.IPNStatus, min string) (string, bool) {
+	if status.DaemonVersionSupported(min) {
+		return "", false
+	}
+	version, _, _ := strings.Cut(status.ClientVersion(), "-")
+	return fmt.Sprintf("Tailscale %v is older than %v, the oldest supported version", version, min), true
+}
+
+// connectivityText returns the label for the result of the most
+// recent connectivity check, such as "Last connectivity check: OK 5m
+// ago", and whether or not there has been one at all.
+func connectivityText(check connectivityResult, now time.Time, f formatter) (string, bool) {
+	if check.at.IsZero() {
+		return "", false
+	}
+	result := "OK"
+	if !check.ok {
+		result = "FAILED"
+	}
+	return fmt.Sprintf("Last connectivity check: %v %v", result, f.since(now.Sub(check.at))), true
+}
+
+// aboutTooltip returns the tooltip for the about item, such as
+// "Trayscale v0.18.0". The version is left out if it isn't known.
+func aboutTooltip(version string) string {
+	if version == "" {
+		return "Trayscale"
+	}
+	return "Trayscale " + version
+}
+
+// panicText returns the label for the panic item and whether or not
+// it should be enabled. Once the local node is disconnected with
+// shields up, the item says so instead, as there is nothing left for
+// it to do.
+func panicText(status *tsutil.IPNStatus) (string, bool) {
+	if !status.DaemonReachable() {
+		return "⛔ Panic: disconnect and block incoming", false
+	}
+	if !status.Online() && status.Prefs.Valid() && status.Prefs.ShieldsUp() {
+		return "Locked down (disconnected, shields up)", false
+	}
+	return "⛔ Panic: disconnect and block incoming", true
+}
+
+// tailnetLockText returns the label for the item showing the state of
+// tailnet lock and whether or not it should be shown at all. It is
+// only shown if tailnet lock is enabled.
+func tailnetLockText(status *tsutil.IPNStatus) (string, bool) {
+	enabled, signed := status.TailnetLock()
+	switch {
+	case !enabled:
+		return "", false
+	case signed:
+		return "Tailnet lock: enabled (node signed)", true
+	default:
+		return "Tailnet lock: node NOT signed — action needed", true
+	}
+}
+
+// lockSignText returns the label for a node that is waiting to be
+// signed with the local node's tailnet lock key, such as
+// "laptop.example.ts.net (100.64.0.2)".
+func lockSignText(peer *ipnstate.TKAPeer) string {
+	name := strings.TrimSuffix(peer.Name, ".")
+	if name == "" {
+		name = string(peer.StableID)
+	}
+	if len(peer.TailscaleIPs) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v (%v)", name, peer.TailscaleIPs[0])
+}
+
+// magicDNSText returns the label for the item showing whether
+// MagicDNS is active and whether or not it should be shown at all.
+func magicDNSText(status *tsutil.IPNStatus) (string, bool) {
+	if !status.Online() {
+		return "", false
+	}
+	if status.MagicDNSActive() {
+		return "MagicDNS: on", true
+	}
+	return "MagicDNS: off", true
+}
+
+// qualityBand returns a one-word description of a connection quality
+// score: "good" from 80, "fair" from 50, and "poor" below that.
+func qualityBand(score int) string {
+	switch {
+	case score >= 80:
+		return "good"
+	case score >= 50:
+		return "fair"
+	default:
+		return "poor"
+	}
+}
+
+// qualityText returns the label for the item showing the connection
+// quality score, such as "Connection quality: 85 (good)", and whether
+// or not it should be shown at all.
+func qualityText(status *tsutil.IPNStatus) (string, bool) {
+	score, ok := status.ConnectionQuality()
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("Connection quality: %v (%v)", score, qualityBand(score)), true
+}
+
+// ipFamiliesText returns the label for the item showing which IP
+// families the local node has connectivity over, such as
+// "Connectivity: IPv4 and IPv6", and whether or not it should be shown
+// at all.
+func ipFamiliesText(status *tsutil.IPNStatus) (string, bool) {
+	if !status.Online() {
+		return "", false
+	}
+	v4, v6, ok := status.IPFamilies()
+	switch {
+	case !ok:
+		return "", false
+	case v4 && v6:
+		return "Connectivity: IPv4 and IPv6", true
+	case v4:
+		return "Connectivity: IPv4 only", true
+	case v6:
+		return "Connectivity: IPv6 only", true
+	default:
+		return "Connectivity: none detected", true
+	}
+}
+
+// maxDERPRegions is the number of DERP regions listed in the DERP
+// latency submenu.
+const maxDERPRegions = 5
+
+// derpLatencyLabels returns the labels for the items in the DERP
+// latency submenu, such as "Frankfurt: 20ms (preferred)", for at most
+// max of the nearest regions. Latencies are rounded to the nearest
+// 5ms once they're at least 10ms so that the labels don't change every
+// time that they're measured.
+func derpLatencyLabels(status *tsutil.IPNStatus, max int, f formatter) []string {
+	if !status.Online() {
+		return nil
+	}
+
+	latencies := status.DERPLatencies()
+	labels := make([]string, 0, min(max, len(latencies)))
+	for _, l := range latencies[:min(max, len(latencies))] {
+		d := l.Latency
+		if d >= 10*time.Millisecond {
+			d = d.Round(5 * time.Millisecond)
+		}
+		label := fmt.Sprintf("%v: %v", l.Name, f.latency(d))
+		if l.Preferred {
+			label += " (preferred)"
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// throughputText returns the label for the item showing how fast
+// data is moving over the tailnet, such as "↓ 1.2 MB/s ↑ 0.3 MB/s",
+// and whether or not it should be shown at all. Rates are rounded so
+// that the label doesn't change on every update.
+func throughputText(status *tsutil.IPNStatus, f formatter) (string, bool) {
+	if !status.Online() {
+		return "", false
+	}
+	rx, tx, ok := status.Throughput()
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("↓ %v ↑ %v", f.rate(rx), f.rate(tx)), true
+}
+
+// suggestedExitText returns the label for the suggested exit node
+// item, ellipsizing the node's name to maxName characters, and
+// whether or not there is a suggestion available.
+func suggestedExitText(status *tsutil.IPNStatus, maxName int) (string, bool) {
+	node := status.SuggestedExitNode()
+	if !node.Valid() {
+		return "Use suggested exit node", false
+	}
+
+	return fmt.Sprintf("Use suggested exit node: %v", ellipsize(node.DisplayName(true), maxName)), true
+}
+
+// clearTaildropText returns the label for the item that deletes
+// received Taildrop files, including how many there are if any.
+func clearTaildropText(files int) string {
+	if files == 0 {
+		return "Clear received files"
+	}
+	return fmt.Sprintf("Clear received files (%v)", files)
+}
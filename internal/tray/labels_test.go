@@ -0,0 +1,389 @@
+package tray
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/set"
+)
+
+func TestEllipsize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		out  string
+	}{
+		{name: "Short", in: "laptop", max: 10, out: "laptop"},
+		{name: "Exact", in: "laptop", max: 6, out: "laptop"},
+		{name: "Long", in: "my-very-long-laptop", max: 8, out: "my-very…"},
+		{name: "Disabled", in: "my-very-long-laptop", max: 0, out: "my-very-long-laptop"},
+		{name: "MultiByte", in: "ノートパソコン", max: 4, out: "ノート…"},
+		{name: "Emoji", in: "🐧🐧🐧🐧🐧", max: 3, out: "🐧🐧…"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := ellipsize(test.in, test.max)
+			require.Equal(t, test.out, out)
+			require.True(t, utf8.ValidString(out))
+		})
+	}
+}
+
+func TestSelfTitleAddrFamily(t *testing.T) {
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			ComputedNameWithHost: "self",
+			Addresses: []netip.Prefix{
+				netip.MustParsePrefix("fd7a:115c:a1e0::1/128"),
+				netip.MustParsePrefix("100.64.0.1/32"),
+			},
+		}).View(),
+	}}
+
+	title, connected := selfTitle(status, SelfLabelNameAndAddr, IPv4, 0)
+	require.True(t, connected)
+	require.Equal(t, "self (100.64.0.1)", title)
+
+	title, connected = selfTitle(status, SelfLabelAddr, BothFamilies, 0)
+	require.True(t, connected)
+	require.Equal(t, "100.64.0.1, fd7a:115c:a1e0::1", title)
+
+	title, _ = selfTitle(status, SelfLabelName, IPv4, 0)
+	require.Equal(t, "self", title)
+
+	title, _ = selfTitle(status, SelfLabelNameAndAddr, IPv6, 0)
+	require.Equal(t, "self (fd7a:115c:a1e0::1)", title)
+
+	title, _ = selfTitle(status, SelfLabelNameAndAddr, BothFamilies, 0)
+	require.Equal(t, "self (100.64.0.1, fd7a:115c:a1e0::1)", title)
+
+	title, connected = selfTitle(&tsutil.IPNStatus{}, SelfLabelNameAndAddr, IPv6, 0)
+	require.False(t, connected)
+	require.Equal(t, "Not connected", title)
+
+	// The netmap can briefly be missing the local node while it's
+	// being replaced.
+	title, connected = selfTitle(&tsutil.IPNStatus{NetMap: &netmap.NetworkMap{}}, SelfLabelNameAndAddr, IPv4, 0)
+	require.False(t, connected)
+	require.Equal(t, "Not connected", title)
+
+	title, connected = selfTitle(&tsutil.IPNStatus{DaemonUnreachable: true}, SelfLabelNameAndAddr, IPv4, 0)
+	require.False(t, connected)
+	require.Equal(t, "Tailscale daemon not running", title)
+}
+
+func TestSelfInfoText(t *testing.T) {
+	os, version := selfInfoText(&tsutil.IPNStatus{})
+	require.Empty(t, os)
+	require.Empty(t, version)
+
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			Hostinfo: (&tailcfg.Hostinfo{OS: "linux", IPNVersion: "1.76.1-t77ae916c7-g6a1b2c3d4"}).View(),
+		}).View(),
+	}}
+	os, version = selfInfoText(status)
+	require.Equal(t, "OS: linux", os)
+	require.Equal(t, "Version: 1.76.1", version)
+}
+
+func TestSuggestedExitText(t *testing.T) {
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"exit": (&tailcfg.Node{StableID: "exit", ComputedNameWithHost: "exit-node"}).View(),
+	}}
+
+	label, ok := suggestedExitText(status, 0)
+	require.False(t, ok)
+	require.Equal(t, "Use suggested exit node", label)
+
+	status.SuggestedExitNodeID = "exit"
+	label, ok = suggestedExitText(status, 0)
+	require.True(t, ok)
+	require.Equal(t, "Use suggested exit node: exit-node", label)
+
+	status.SuggestedExitNodeID = "missing"
+	_, ok = suggestedExitText(status, 0)
+	require.False(t, ok)
+}
+
+func TestPeerTooltip(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	lastSeen := now.Add(-2*time.Hour - 10*time.Minute)
+	online := true
+
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"on":    (&tailcfg.Node{StableID: "on", ComputedNameWithHost: "laptop", Online: &online}).View(),
+		"off":   (&tailcfg.Node{StableID: "off", ComputedNameWithHost: "nas", LastSeen: &lastSeen}).View(),
+		"never": (&tailcfg.Node{StableID: "never", ComputedNameWithHost: "phone"}).View(),
+	}}
+
+	require.Equal(t, "laptop (online)", peerTooltip(status, status.Peers["on"], 0, now, formatter{}))
+	require.Equal(t, "nas (last seen 2h ago)", peerTooltip(status, status.Peers["off"], 0, now, formatter{}))
+	require.Equal(t, "phone (offline)", peerTooltip(status, status.Peers["never"], 0, now, formatter{}))
+
+}
+
+func TestMenuPeersPinned(t *testing.T) {
+	status := &tsutil.IPNStatus{State: ipn.Running, Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"a": (&tailcfg.Node{StableID: "a", Hostinfo: (&tailcfg.Hostinfo{Hostname: "alpha"}).View()}).View(),
+		"b": (&tailcfg.Node{StableID: "b", Hostinfo: (&tailcfg.Hostinfo{Hostname: "bravo"}).View()}).View(),
+		"c": (&tailcfg.Node{StableID: "c", Hostinfo: (&tailcfg.Hostinfo{Hostname: "charlie"}).View()}).View(),
+	}}
+
+	require.Equal(t, []any{tailcfg.StableNodeID("a"), tailcfg.StableNodeID("b"), tailcfg.StableNodeID("c")}, peerIDs(menuPeers(status, nil)))
+
+	pinned := set.Of[tailcfg.StableNodeID]("c")
+	require.Equal(t, []any{tailcfg.StableNodeID("c"), tailcfg.StableNodeID("a"), tailcfg.StableNodeID("b")}, peerIDs(menuPeers(status, pinned)))
+}
+
+func TestTunnelText(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	_, ok := tunnelText(&tsutil.IPNStatus{Prefs: prefs.View()})
+	require.False(t, ok)
+
+	prefs.ExitNodeID = "exit"
+	label, ok := tunnelText(&tsutil.IPNStatus{Prefs: prefs.View()})
+	require.True(t, ok)
+	require.Equal(t, "All traffic via exit node", label)
+
+	prefs.ExitNodeAllowLANAccess = true
+	label, _ = tunnelText(&tsutil.IPNStatus{Prefs: prefs.View()})
+	require.Equal(t, "Local network bypasses exit node", label)
+}
+
+func TestExitRoutingText(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+	status := &tsutil.IPNStatus{
+		Prefs:  prefs.View(),
+		Peers:  map[tailcfg.StableNodeID]tailcfg.NodeView{"exit": (&tailcfg.Node{StableID: "exit"}).View()},
+		Engine: &ipn.EngineStatus{},
+	}
+	_, ok := exitRoutingText(status)
+	require.False(t, ok)
+
+	status.PrevEngine = &ipn.EngineStatus{}
+	label, ok := exitRoutingText(status)
+	require.True(t, ok)
+	require.Equal(t, "Exit node: active (idle)", label)
+
+	status.Engine = &ipn.EngineStatus{LivePeers: map[key.NodePublic]ipnstate.PeerStatusLite{{}: {TxBytes: 10}}}
+	label, _ = exitRoutingText(status)
+	require.Equal(t, "Exit node: active (routing)", label)
+}
+
+func TestExitToggleFlag(t *testing.T) {
+	require.Equal(t, "🇨🇦", flagEmoji("CA"))
+	require.Equal(t, "🇨🇦", flagEmoji("ca"))
+	require.Empty(t, flagEmoji(""))
+	require.Empty(t, flagEmoji("C1"))
+
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+	status := &tsutil.IPNStatus{
+		Prefs: prefs.View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"exit": (&tailcfg.Node{
+				StableID: "exit",
+				Hostinfo: (&tailcfg.Hostinfo{Location: &tailcfg.Location{CountryCode: "CA"}}).View(),
+			}).View(),
+		},
+	}
+	require.Equal(t, "Disable exit node", exitToggleText(status, false, ""))
+	require.Equal(t, "Disable exit node 🇨🇦", exitToggleText(status, true, ""))
+	require.Equal(t, "Disable exit node 🇨🇦 (auto)", exitToggleText(status, true, "exit"))
+	require.Equal(t, "Disable exit node", exitToggleText(status, false, "other"))
+
+	status.Peers = nil
+	require.Equal(t, "Disable exit node", exitToggleText(status, true, ""))
+}
+
+func TestExitNodeText(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+	status := &tsutil.IPNStatus{Prefs: prefs.View()}
+	exit := (&tailcfg.Node{StableID: "exit", ComputedNameWithHost: "us-nyc-1"}).View()
+	other := (&tailcfg.Node{StableID: "other", ComputedNameWithHost: "de-fra-1"}).View()
+
+	require.Equal(t, "us-nyc-1 (in use)", exitNodeText(status, exit, 0, ""))
+	require.Equal(t, "us-nyc-1 (in use, auto)", exitNodeText(status, exit, 0, "exit"))
+	require.Equal(t, "de-fra-1", exitNodeText(status, other, 0, "other"))
+
+	prefs.ExitNodeAllowLANAccess = true
+	status.Prefs = prefs.View()
+	require.Equal(t, "us-nyc-1 (in use, LAN on, auto)", exitNodeText(status, exit, 0, "exit"))
+
+	ranked := &tailcfg.Node{
+		StableID:             "other",
+		ComputedNameWithHost: "de-fra-1",
+		Hostinfo:             (&tailcfg.Hostinfo{Location: &tailcfg.Location{Priority: 50}}).View(),
+	}
+	status.Peers = map[tailcfg.StableNodeID]tailcfg.NodeView{"other": ranked.View()}
+	require.Equal(t, "de-fra-1 (priority 50)", exitNodeText(status, ranked.View(), 0, ""))
+
+	prefs.ExitNodeID = "other"
+	status.Prefs = prefs.View()
+	require.Equal(t, "de-fra-1 (in use, LAN on, priority 50)", exitNodeText(status, ranked.View(), 0, ""))
+}
+
+func TestIncomingText(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	_, ok := incomingText(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs.View()})
+	require.False(t, ok)
+
+	label, ok := incomingText(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()})
+	require.True(t, ok)
+	require.Equal(t, "Incoming: allowed", label)
+
+	label, _ = incomingText(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View(), NetMap: &netmap.NetworkMap{}})
+	require.Equal(t, "Incoming: blocked (no access rules)", label)
+
+	prefs.ShieldsUp = true
+	label, _ = incomingText(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()})
+	require.Equal(t, "Incoming: blocked (shields up)", label)
+}
+
+func TestIdentityText(t *testing.T) {
+	_, ok := identityText(&tsutil.IPNStatus{})
+	require.False(t, ok)
+
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{User: 1}).View(),
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfileView{
+			1: (&tailcfg.UserProfile{LoginName: "user@example.com"}).View(),
+		},
+	}
+	label, ok := identityText(&tsutil.IPNStatus{NetMap: nm})
+	require.True(t, ok)
+	require.Equal(t, "Owner: user@example.com", label)
+
+	nm.SelfNode = (&tailcfg.Node{User: 1, Tags: []string{"tag:server", "tag:ci"}}).View()
+	label, ok = identityText(&tsutil.IPNStatus{NetMap: nm})
+	require.True(t, ok)
+	require.Equal(t, "Tags: tag:server, tag:ci", label)
+}
+
+func TestThroughputText(t *testing.T) {
+	now := time.Now()
+	status := &tsutil.IPNStatus{
+		State:          ipn.Running,
+		Prefs:          ipn.NewPrefs().View(),
+		Engine:         &ipn.EngineStatus{RBytes: 1_200_000, WBytes: 300_000},
+		EngineTime:     now.Add(time.Second),
+		PrevEngine:     &ipn.EngineStatus{},
+		PrevEngineTime: now,
+	}
+	label, ok := throughputText(status, formatter{})
+	require.True(t, ok)
+	require.Equal(t, "↓ 1.2 MB/s ↑ 300 KB/s", label)
+}
+
+func TestMagicDNSText(t *testing.T) {
+	_, ok := magicDNSText(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()})
+	require.False(t, ok)
+
+	status := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+	label, ok := magicDNSText(status)
+	require.True(t, ok)
+	require.Equal(t, "MagicDNS: off", label)
+
+	status.NetMap = &netmap.NetworkMap{Name: "self.tail1234.ts.net.", DNS: tailcfg.DNSConfig{Proxied: true}}
+	label, _ = magicDNSText(status)
+	require.Equal(t, "MagicDNS: on", label)
+}
+
+func TestQualityText(t *testing.T) {
+	_, ok := qualityText(&tsutil.IPNStatus{State: ipn.Stopped})
+	require.False(t, ok)
+
+	label, ok := qualityText(&tsutil.IPNStatus{State: ipn.Running})
+	require.True(t, ok)
+	require.Equal(t, "Connection quality: 100 (good)", label)
+
+	require.Equal(t, "good", qualityBand(80))
+	require.Equal(t, "fair", qualityBand(79))
+	require.Equal(t, "fair", qualityBand(50))
+	require.Equal(t, "poor", qualityBand(49))
+}
+
+func TestIPFamiliesText(t *testing.T) {
+	status := func(state ipn.State, info *tailcfg.NetInfo) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{
+			State: state,
+			NetMap: &netmap.NetworkMap{
+				SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: info}).View()}).View(),
+			},
+		}
+	}
+
+	_, ok := ipFamiliesText(status(ipn.Stopped, &tailcfg.NetInfo{}))
+	require.False(t, ok)
+	_, ok = ipFamiliesText(status(ipn.Running, nil))
+	require.False(t, ok)
+
+	tests := []struct {
+		latency map[string]float64
+		label   string
+	}{
+		{map[string]float64{"1-v4": 0.01, "1-v6": 0.01}, "Connectivity: IPv4 and IPv6"},
+		{map[string]float64{"1-v4": 0.01}, "Connectivity: IPv4 only"},
+		{map[string]float64{"1-v6": 0.01}, "Connectivity: IPv6 only"},
+		{nil, "Connectivity: none detected"},
+	}
+	for _, test := range tests {
+		label, ok := ipFamiliesText(status(ipn.Running, &tailcfg.NetInfo{DERPLatency: test.latency}))
+		require.True(t, ok)
+		require.Equal(t, test.label, label)
+	}
+}
+
+func TestConnectivityText(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	_, ok := connectivityText(connectivityResult{}, now, formatter{})
+	require.False(t, ok)
+
+	label, ok := connectivityText(connectivityResult{at: now.Add(-5 * time.Second), ok: true}, now, formatter{})
+	require.True(t, ok)
+	require.Equal(t, "Last connectivity check: OK just now", label)
+
+	label, _ = connectivityText(connectivityResult{at: now.Add(-3 * time.Minute)}, now, formatter{})
+	require.Equal(t, "Last connectivity check: FAILED 3m ago", label)
+}
+
+func TestDERPLatencyLabels(t *testing.T) {
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+				PreferredDERP: 2,
+				DERPLatency:   map[string]float64{"1-v4": 0.0072, "2-v4": 0.0213, "3-v4": 0.0438},
+			}}).View()}).View(),
+			DERPMap: &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+				1: {RegionName: "Frankfurt"},
+				2: {RegionName: "Amsterdam"},
+				3: {RegionName: "London"},
+			}},
+		},
+	}
+
+	require.Equal(t, []string{
+		"Frankfurt: 7ms",
+		"Amsterdam: 20ms (preferred)",
+	}, derpLatencyLabels(status, 2, formatter{}))
+
+	status.State = ipn.Stopped
+	require.Empty(t, derpLatencyLabels(status, 2, formatter{}))
+}
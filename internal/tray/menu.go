@@ -0,0 +1,1795 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unique"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/set"
+)
+
+var (
+	selfHandle       = unique.Make("self")
+	selfInfoHandle   = unique.Make("selfInfo")
+	showHandle       = unique.Make("show")
+	connToggleHandle = unique.Make("connToggle")
+	serviceHandle    = unique.Make("service")
+	operatorHandle   = unique.Make("operator")
+	exitToggleHandle = unique.Make("exitToggle")
+	suggestedHandle  = unique.Make("suggestedExit")
+	tunnelHandle     = unique.Make("tunnel")
+	exitRouteHandle  = unique.Make("exitRouting")
+	statusIconHandle = unique.Make("statusIcon")
+	accessoryHandle  = unique.Make("accessoryLabel")
+	adminHandle      = unique.Make("adminConsole")
+	dnsSuffixHandle  = unique.Make("dnsSuffix")
+	servingHandle    = unique.Make("servingExitNode")
+	routesHandle     = unique.Make("advertisedRoutes")
+	incomingHandle   = unique.Make("incoming")
+	panicHandle      = unique.Make("panic")
+	lockHandle       = unique.Make("tailnetLock")
+	magicDNSHandle   = unique.Make("magicDNS")
+	derpHandle       = unique.Make("derpLatency")
+	qualityHandle    = unique.Make("quality")
+	connCheckHandle  = unique.Make("connectivityCheck")
+	familiesHandle   = unique.Make("ipFamilies")
+	throughputHandle = unique.Make("throughput")
+	loginHandle      = unique.Make("pendingLogin")
+	clockSkewHandle  = unique.Make("clockSkew")
+	conflictHandle   = unique.Make("routeConflict")
+	daemonVerHandle  = unique.Make("daemonVersion")
+	captiveHandle    = unique.Make("captivePortal")
+	updateHandle     = unique.Make("updatePending")
+	selfQRHandle     = unique.Make("selfQR")
+	selfAddrsHandle  = unique.Make("selfAddrs")
+	renameHandle     = unique.Make("rename")
+	taildropHandle   = unique.Make("taildrop")
+	lockSignHandle   = unique.Make("lockSign")
+	identityHandle   = unique.Make("identity")
+)
+
+type trayImpl struct {
+	Callbacks
+	options
+	platform
+
+	m       sync.Mutex
+	host    menuHost
+	prev    map[unique.Handle[string]][]any
+	status  *tsutil.IPNStatus
+	profile ipn.ProfileID
+	icon    *icon
+	iconPNG []byte
+	shown   bool
+	closed  bool
+	events  eventQueue
+	pinned  set.Set[tailcfg.StableNodeID]
+	metrics Metrics
+	history history
+
+	// lastUpdate is when Update was last called, and closing
+	// watchdogDone stops the polling started by startWatchdog.
+	lastUpdate   time.Time
+	watchdogDone chan struct{}
+
+	// lastCheck is the result of the most recent connectivity check,
+	// and closing connCheckDone stops the checks started by
+	// startConnectivityCheck.
+	lastCheck     connectivityResult
+	connCheckDone chan struct{}
+
+	// health is the server started by startHealthServer, if any.
+	health *healthServer
+
+	// lastExitNode is the exit node that was most recently used. If
+	// lastExitNodeChanged is true, it has yet to be passed to
+	// OnLastExitNodeChanged.
+	lastExitNode        tailcfg.StableNodeID
+	lastExitNodeChanged bool
+
+	// seed is the state saved by the previous session, which is used
+	// in place of the real status until it settles. saved is what the
+	// state file currently contains. See WithStateFile.
+	seed        *savedState
+	saved       savedState
+	stateLoaded bool
+
+	// autoExitNode is the exit node that was selected automatically.
+	// See SetAutoExitNode.
+	autoExitNode tailcfg.StableNodeID
+
+	// quitWait, if non-nil, is closed by Close after the quit item
+	// has called OnQuit, and quitting tracks the goroutines waiting for
+	// that to happen. See quit.
+	quitWait chan struct{}
+	quitting sync.WaitGroup
+
+	// notOperator is whether the most recent status said that the
+	// current user isn't allowed to change Tailscale's settings. It is
+	// read by click handlers, which don't hold m. See isReadOnly.
+	notOperator atomic.Bool
+
+	// windowVisible is whether the app's window is open. See
+	// SetWindowVisible.
+	windowVisible bool
+
+	// defaultLANAccess is whether exit nodes selected from the exit
+	// nodes submenu allow local network access.
+	defaultLANAccess bool
+
+	// lockPending are the nodes waiting to be signed by the local
+	// node's tailnet lock key, from the most recent TailnetLockStatus.
+	lockPending []*ipnstate.TKAPeer
+
+	// activePaths are the peers with active connections as of the most
+	// recent PeerPathStatus. See updatePeerPaths.
+	activePaths set.Set[tailcfg.StableNodeID]
+
+	// waitingFiles is the number of received Taildrop files that have
+	// yet to be saved, as of the most recent FileStatus.
+	waitingFiles int
+
+	// firstUpdate is true during the first update after the menu is
+	// built so that every item is set explicitly, no matter what
+	// state it was created in.
+	firstUpdate bool
+
+	showItem       menuItem
+	connToggleItem menuItem
+	connectItem    menuItem
+	disconnectItem menuItem
+	panicItem      menuItem
+	operatorItem   menuItem
+	serviceItem    menuItem
+	loginItem      menuItem
+	clockSkewItem  menuItem
+	conflictItem   menuItem
+	daemonVerItem  menuItem
+	captiveItem    menuItem
+	exitToggleItem menuItem
+	tunnelItem     menuItem
+	exitRouteItem  menuItem
+	servingItem    menuItem
+	routesItem     menuItem
+	routeItems     []menuItem
+	incomingItem   menuItem
+	lockItem       menuItem
+	lockSignItem   menuItem
+	lockSignItems  []menuItem
+	identityItem   menuItem
+	magicDNSItem   menuItem
+	derpItem       menuItem
+	derpItems      []menuItem
+	qualityItem    menuItem
+	connCheckItem  menuItem
+	familiesItem   menuItem
+	throughputItem menuItem
+	updateItem     menuItem
+	suggestedItem  menuItem
+	selfNodeItem   menuItem
+	selfShowItem   menuItem
+	renameItem     menuItem
+	selfOSItem     menuItem
+	selfVerItem    menuItem
+	selfQRAddrItem menuItem
+	selfQRNameItem menuItem
+	selfAddrsItem  menuItem
+	selfAddrItems  []menuItem
+	peersItem      menuItem
+	peerItems      map[tailcfg.StableNodeID]peerMenu
+	taildropItem   menuItem
+	taildropOpen   menuItem
+	taildropClear  menuItem
+	exitNodesItem  menuItem
+	exitLANItem    menuItem
+	exitNodeItems  map[tailcfg.StableNodeID]menuItem
+	exitCountries  []menuItem
+	reportItem     menuItem
+	dnsSuffixItem  menuItem
+	adminItem      menuItem
+	aboutMenuItem  menuItem
+	quitItem       menuItem
+}
+
+// peerMenu is the submenu for a single peer.
+type peerMenu struct {
+	item menuItem
+	pin  menuItem
+
+	// routes is nil if accepting a single peer's routes isn't
+	// supported.
+	routes menuItem
+
+	// copyName is nil if there is nowhere to copy the peer's name to.
+	copyName menuItem
+
+	// copySSH is nil if copying an SSH command isn't supported.
+	copySSH menuItem
+
+	// services is nil if opening a peer's services isn't supported.
+	// serviceItems are its children, one per URL.
+	services     menuItem
+	serviceItems []menuItem
+}
+
+// New creates a new tray for the current platform
+func New(cb Callbacks, opts ...Option) Tray {
+	return &trayImpl{Callbacks: cb, options: newOptions(opts), platform: newPlatform()}
+}
+
+// build adds the tray's menu to host and brings it up to date with
+// status. It must be called with t.m held.
+func (t *trayImpl) build(host menuHost, status *tsutil.IPNStatus) {
+	actions := t.actions()
+
+	t.metrics.MenuRebuilds++
+	t.host = host
+	t.prev = make(map[unique.Handle[string]][]any)
+	t.peerItems = make(map[tailcfg.StableNodeID]peerMenu)
+	t.exitNodeItems = make(map[tailcfg.StableNodeID]menuItem)
+	t.firstUpdate = true
+	t.loadState()
+	if status != nil {
+		t.notOperator.Store(!operatorIsCurrent(status))
+	}
+
+	host.Batch(func() {
+		if t.OnShowWithHint != nil {
+			t.showItem = host.AddMenuItem("Show", "Show Trayscale")
+			t.showItem.OnClick(actions[actionShow])
+			host.AddSeparator()
+		}
+		builders := t.itemBuilders(host, status, actions)
+		for _, id := range t.itemOrder {
+			if t.compactMode && !compactItems.Contains(id) {
+				continue
+			}
+			builders[id]()
+		}
+		host.AddSeparator()
+		if t.aboutItem && (t.OnAbout != nil) {
+			t.aboutMenuItem = host.AddMenuItem("About Trayscale", aboutTooltip(t.version))
+			t.aboutMenuItem.OnClick(actions[actionAbout])
+		}
+		t.quitItem = host.AddMenuItem("Quit", "Quit Trayscale (tailscale will remain running)")
+		t.quitItem.OnClick(actions[actionQuit])
+
+		t.update(status)
+	})
+	t.startWatchdog()
+	t.startConnectivityCheck()
+	t.startHealthServer()
+	t.autoShowOnce()
+}
+
+// itemBuilders returns functions that add each of the orderable items
+// to host. They must be called with t.m held.
+func (t *trayImpl) itemBuilders(host menuHost, status *tsutil.IPNStatus, actions map[menuAction]func()) map[MenuItemID]func() {
+	return map[MenuItemID]func(){
+		ItemConnection: func() {
+			if t.separateConnectItems {
+				t.connectItem = host.AddMenuItem("Connect", "Connect to tailscale")
+				t.connectItem.OnClick(actions[actionConnect])
+				t.disconnectItem = host.AddMenuItem("Disconnect", "Disconnect from tailscale")
+				t.disconnectItem.OnClick(actions[actionDisconnect])
+				return
+			}
+			t.connToggleItem = host.AddMenuItemCheckbox("Connected", "Connect to tailscale", status.Online())
+			t.connToggleItem.OnClick(actions[actionConnToggle])
+		},
+		ItemService: func() {
+			if t.OnServiceStartStop == nil {
+				return
+			}
+			t.serviceItem = host.AddMenuItem("", "Start or stop the Tailscale daemon")
+			t.serviceItem.OnClick(actions[actionServiceToggle])
+		},
+		ItemOperator: func() {
+			t.operatorItem = host.AddMenuItem("Run 'tailscale set --operator=$USER' to manage from here", "This user isn't allowed to change Tailscale's settings, so the menu is read-only")
+			t.operatorItem.Disable()
+			t.operatorItem.Hide()
+		},
+		ItemPanic: func() {
+			if !t.panicButton || (t.OnPanic == nil) {
+				return
+			}
+			t.panicItem = host.AddMenuItem("", "Immediately disconnect and block all incoming connections")
+			t.panicItem.OnClick(actions[actionPanic])
+		},
+		ItemLogin: func() {
+			t.loginItem = host.AddMenuItem("Finish login in browser", "Open the page for the login in progress")
+			t.loginItem.OnClick(actions[actionFinishLogin])
+			t.loginItem.Hide()
+		},
+		ItemClockSkew: func() {
+			t.clockSkewItem = host.AddMenuItem("System clock is wrong — fix time to connect", "Tailscale can't connect securely while the system clock is wrong")
+			t.clockSkewItem.Disable()
+			t.clockSkewItem.Hide()
+		},
+		ItemRouteConflict: func() {
+			t.conflictItem = host.AddMenuItem("", "")
+			t.conflictItem.Disable()
+			t.conflictItem.Hide()
+		},
+		ItemDaemonVersion: func() {
+			t.daemonVerItem = host.AddMenuItem("Unsupported tailscaled version — please upgrade", "")
+			t.daemonVerItem.OnClick(actions[actionUpgradeDaemon])
+			setEnabled(t.daemonVerItem, t.OnOpenURL != nil)
+			t.daemonVerItem.Hide()
+		},
+		ItemCaptive: func() {
+			t.captiveItem = host.AddMenuItem("Captive portal detected — sign in to Wi-Fi", "The network seems to require signing in before Tailscale can connect")
+			t.captiveItem.OnClick(actions[actionCaptivePortal])
+			setEnabled(t.captiveItem, (t.OnCaptivePortal != nil) || (t.OnOpenURL != nil))
+			t.captiveItem.Hide()
+		},
+		ItemExitNode: func() {
+			t.exitToggleItem = host.AddMenuItemCheckbox("Exit Node Enabled", "Allow use of this device as an exit node", status.ExitNodeActive())
+			t.exitToggleItem.OnClick(actions[actionExitToggle])
+		},
+		ItemTunnel: func() {
+			t.tunnelItem = host.AddMenuItem("", "How traffic is routed through the exit node")
+			t.tunnelItem.Disable()
+			t.tunnelItem.Hide()
+		},
+		ItemExitRouting: func() {
+			t.exitRouteItem = host.AddMenuItem("", "Whether traffic is currently flowing through the exit node")
+			t.exitRouteItem.Disable()
+			t.exitRouteItem.Hide()
+		},
+		ItemServing: func() {
+			t.servingItem = host.AddMenuItem("Serving as exit node", "Other devices can route their traffic through this machine")
+			t.servingItem.Disable()
+			t.servingItem.Hide()
+		},
+		ItemRoutes: func() {
+			t.routesItem = host.AddMenuItem("", "The subnet routes that this machine advertises and whether they have been approved")
+			t.routesItem.Hide()
+		},
+		ItemIncoming: func() {
+			t.incomingItem = host.AddMenuItem("", "Whether other devices on the tailnet can connect to this machine")
+			t.incomingItem.Disable()
+			t.incomingItem.Hide()
+		},
+		ItemTailnetLock: func() {
+			t.lockItem = host.AddMenuItem("", "Whether this machine's key has been signed by a trusted tailnet lock key")
+			t.lockItem.Disable()
+			t.lockItem.Hide()
+		},
+		ItemLockSign: func() {
+			if t.OnSignNode == nil {
+				return
+			}
+			t.lockSignItem = host.AddMenuItem("Sign pending nodes", "Allow nodes onto the tailnet by signing them with this machine's tailnet lock key")
+			t.lockSignItem.Hide()
+		},
+		ItemIdentity: func() {
+			t.identityItem = host.AddMenuItem("", "Whether this machine is owned by ACL tags or by a user")
+			t.identityItem.Disable()
+			t.identityItem.Hide()
+		},
+		ItemMagicDNS: func() {
+			t.magicDNSItem = host.AddMenuItem("", "Whether names of devices on the tailnet can be resolved")
+			t.magicDNSItem.Disable()
+			t.magicDNSItem.Hide()
+		},
+		ItemQuality: func() {
+			t.qualityItem = host.AddMenuItem("", "A score from 0 to 100 based on latency, direct connectivity, and health")
+			t.qualityItem.Disable()
+			t.qualityItem.Hide()
+		},
+		ItemConnCheck: func() {
+			if t.connCheckTarget == "" {
+				return
+			}
+			t.connCheckItem = host.AddMenuItem("", fmt.Sprintf("Whether %v could be reached when last checked", t.connCheckTarget))
+			t.connCheckItem.Disable()
+			t.connCheckItem.Hide()
+		},
+		ItemIPFamilies: func() {
+			t.familiesItem = host.AddMenuItem("", "Which IP versions this machine reaches the internet over. Tailscale uses whichever work")
+			t.familiesItem.Disable()
+			t.familiesItem.Hide()
+		},
+		ItemDERP: func() {
+			t.derpItem = host.AddMenuItem("DERP latency", "Latency to the nearest relay regions")
+			t.derpItem.Hide()
+			t.derpItems = make([]menuItem, maxDERPRegions)
+			for i := range t.derpItems {
+				t.derpItems[i] = t.derpItem.AddSubMenuItem("", "")
+				t.derpItems[i].Disable()
+			}
+		},
+		ItemThroughput: func() {
+			t.throughputItem = host.AddMenuItem("", "How fast data is being received and sent over the tailnet")
+			t.throughputItem.Disable()
+			t.throughputItem.Hide()
+		},
+		ItemUpdate: func() {
+			t.updateItem = host.AddMenuItem("Update downloaded — restart to apply", "Restart Tailscale to finish installing an update")
+			t.updateItem.OnClick(actions[actionApplyUpdate])
+			if t.isReadOnly() || (t.OnApplyUpdate == nil) {
+				t.updateItem.Disable()
+			}
+			t.updateItem.Hide()
+		},
+		ItemSuggestedExit: func() {
+			t.suggestedItem = host.AddMenuItem("Use suggested exit node", "Use the exit node suggested by Tailscale")
+			t.suggestedItem.OnClick(actions[actionSuggestedExit])
+		},
+		ItemExitNodes: func() {
+			if t.OnExitNodeSelect == nil {
+				return
+			}
+			t.exitNodesItem = host.AddMenuItem("Exit nodes", "Choose an exit node to use")
+			t.exitNodesItem.Hide()
+			if !t.exitNodeLANChoice && (t.OnDefaultLANAccessToggle != nil) {
+				t.exitLANItem = t.exitNodesItem.AddSubMenuItem("", "Whether exit nodes chosen here allow access to the local network")
+				t.exitLANItem.OnClick(t.toggleDefaultLANAccess)
+			}
+		},
+		ItemSelf: func() {
+			t.selfNodeItem = host.AddMenuItem(status.SelfAddr().String(), "Current Node IP")
+			t.selfNodeItem.OnClick(actions[actionSelfItem])
+			t.selfShowItem = t.selfNodeItem.AddSubMenuItem("Show details", "Show this machine in Trayscale")
+			t.selfShowItem.OnClick(actions[actionSelfNode])
+			if t.OnRename != nil {
+				t.renameItem = t.selfNodeItem.AddSubMenuItem("Rename this machine…", "Change this machine's name in the tailnet")
+				t.renameItem.OnClick(actions[actionRename])
+			}
+			t.selfOSItem = t.selfNodeItem.AddSubMenuItem("", "Operating system of this machine")
+			t.selfOSItem.Disable()
+			t.selfVerItem = t.selfNodeItem.AddSubMenuItem("", "Version of Tailscale running on this machine")
+			t.selfVerItem.Disable()
+			if t.OnCopy != nil {
+				t.selfAddrsItem = t.selfNodeItem.AddSubMenuItem("Addresses", "All of this machine's Tailscale addresses")
+				t.selfAddrsItem.Hide()
+			}
+			if t.qrItems && (t.OnShowQR != nil) {
+				t.selfQRAddrItem = t.selfNodeItem.AddSubMenuItem("Show address as QR code", "Show this machine's Tailscale address as a QR code")
+				t.selfQRAddrItem.OnClick(func() { t.showSelfQR(selfAddrText) })
+				t.selfQRNameItem = t.selfNodeItem.AddSubMenuItem("Show name as QR code", "Show this machine's MagicDNS name as a QR code")
+				t.selfQRNameItem.OnClick(func() { t.showSelfQR((*tsutil.IPNStatus).SelfDNSName) })
+			}
+		},
+		ItemPeers: func() {
+			t.peersItem = host.AddMenuItem("Peers", "Peers in the tailnet")
+			t.peersItem.Hide()
+		},
+		ItemTaildrop: func() {
+			if (t.OnOpenTaildropDir == nil) && (t.OnClearTaildrop == nil) {
+				return
+			}
+			t.taildropItem = host.AddMenuItem("Taildrop", "Manage files received via Taildrop")
+			t.taildropOpen = t.taildropItem.AddSubMenuItem("Open Taildrop folder", "Open the folder that received files are saved to")
+			t.taildropOpen.OnClick(actions[actionOpenTaildropDir])
+			if t.OnOpenTaildropDir == nil {
+				t.taildropOpen.Disable()
+			}
+			t.taildropClear = t.taildropItem.AddSubMenuItem("Clear received files", "Delete received files that haven't been saved")
+			t.taildropClear.OnClick(actions[actionClearTaildrop])
+		},
+		ItemStatusReport: func() {
+			t.reportItem = host.AddMenuItem("Copy status report", "Copy a summary of the current status to the clipboard")
+			t.reportItem.OnClick(actions[actionCopyReport])
+		},
+		ItemDNSSuffix: func() {
+			t.dnsSuffixItem = host.AddMenuItem("", "Copy the tailnet's MagicDNS suffix to the clipboard")
+			t.dnsSuffixItem.OnClick(actions[actionCopyDNSSuffix])
+			t.dnsSuffixItem.Hide()
+		},
+		ItemAdminConsole: func() {
+			t.adminItem = host.AddMenuItem("Open admin console", "Open the tailnet's admin console in a browser")
+			t.adminItem.OnClick(actions[actionAdminConsole])
+		},
+	}
+}
+
+// reset forgets the menu after the host has gone away. It must be
+// called with t.m held.
+func (t *trayImpl) reset() {
+	t.stopWatchdog()
+	t.stopConnectivityCheck()
+	t.stopHealthServer()
+	t.closed = true
+	t.host = nil
+	t.prev = nil
+	t.icon = nil
+	t.iconPNG = nil
+	t.peerItems = nil
+	t.exitNodeItems = nil
+	t.exitCountries = nil
+	t.lockSignItems = nil
+	t.routeItems = nil
+	t.selfAddrItems = nil
+}
+
+// autoShowOnce calls OnShowWithHint if the tray was configured to do
+// so and hasn't already.
+func (t *trayImpl) autoShowOnce() {
+	if !t.autoShow || t.shown {
+		return
+	}
+	t.shown = true
+
+	go t.show()
+}
+
+// toggleWindow asks for the window to be hidden if it is open and
+// OnHide is set. Otherwise, it asks for it to be shown.
+func (t *trayImpl) toggleWindow() {
+	t.m.Lock()
+	visible := t.windowVisible
+	t.m.Unlock()
+
+	if visible && (t.OnHide != nil) {
+		t.OnHide()
+		return
+	}
+	t.show()
+}
+
+// show asks for the window to be shown. It does nothing if there is
+// no window to show.
+func (t *trayImpl) show() {
+	optional("OnShowWithHint", bind(t.OnShowWithHint, t.showHint()))()
+}
+
+// connect calls OnConnect, falling back to OnConnToggle if it isn't
+// set.
+func (t *trayImpl) connect() {
+	if t.OnConnect != nil {
+		t.OnConnect()
+		return
+	}
+	call(t.OnConnToggle)
+}
+
+// quitTimeout is how long quit waits for the app to call Close after
+// OnQuit has returned. It is a variable so that tests can shorten it.
+var quitTimeout = 5 * time.Second
+
+// quit calls OnQuit, which is expected to shut the app down and to call
+// Close as part of doing so. If Close hasn't been called within
+// quitTimeout, the tray closes itself so that its icon doesn't linger
+// after the app has otherwise gone away, including when OnQuit isn't
+// set.
+func (t *trayImpl) quit() {
+	done := make(chan struct{})
+	t.m.Lock()
+	t.quitWait = done
+	t.m.Unlock()
+
+	optional("OnQuit", t.OnQuit)()
+
+	timeout := time.NewTimer(quitTimeout)
+	t.quitting.Go(func() {
+		defer timeout.Stop()
+		select {
+		case <-done:
+		case <-timeout.C:
+			slog.Warn("app didn't close the tray after quitting")
+			t.Close()
+		}
+	})
+}
+
+// quitDone tells quit that Close has been called. It must be called
+// with t.m held.
+func (t *trayImpl) quitDone() {
+	if t.quitWait != nil {
+		close(t.quitWait)
+		t.quitWait = nil
+	}
+}
+
+// disconnect calls OnDisconnect, falling back to OnConnToggle if it
+// isn't set, after confirming it if necessary.
+func (t *trayImpl) disconnect() {
+	t.confirmDisconnect(func() {
+		if t.OnDisconnect != nil {
+			t.OnDisconnect()
+			return
+		}
+		call(t.OnConnToggle)
+	})
+}
+
+// toggleConnection calls OnConnToggle, confirming it first if the
+// most recently received status is online.
+func (t *trayImpl) toggleConnection() {
+	t.m.Lock()
+	online := (t.status != nil) && t.status.Online()
+	t.m.Unlock()
+
+	toggle := optional("OnConnToggle", t.OnConnToggle)
+	if online {
+		t.confirmDisconnect(toggle)
+		return
+	}
+	toggle()
+}
+
+// confirmDisconnect passes disconnect to OnConfirmDisconnect if the
+// tray was created with [WithConfirmDisconnect]. Otherwise, it calls
+// disconnect immediately.
+func (t *trayImpl) confirmDisconnect(disconnect func()) {
+	if t.confirmDisconnects && (t.OnConfirmDisconnect != nil) {
+		t.OnConfirmDisconnect(disconnect)
+		return
+	}
+	disconnect()
+}
+
+// useSuggestedExit calls OnUseSuggestedExit if it is set.
+func (t *trayImpl) useSuggestedExit() {
+	optional("OnUseSuggestedExit", t.OnUseSuggestedExit)()
+}
+
+// togglePin calls OnPeerPinToggle for id if it is set.
+func (t *trayImpl) togglePin(id tailcfg.StableNodeID) {
+	optional("OnPeerPinToggle", bind(t.OnPeerPinToggle, id))()
+}
+
+// toggleService asks OnServiceStartStop to stop the Tailscale daemon
+// if it was reachable as of the most recently received status and to
+// start it otherwise.
+func (t *trayImpl) toggleService() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	optional("OnServiceStartStop", bind(t.OnServiceStartStop, !status.DaemonReachable()))()
+}
+
+// captivePortalURL is a plain HTTP page that exists to be intercepted
+// by captive portals, so opening it brings up the portal's sign-in
+// page.
+const captivePortalURL = "http://detectportal.firefox.com/canonical.html"
+
+// openURL passes u to OnOpenURL, which every item that opens a page
+// in the browser goes through.
+func (t *trayImpl) openURL(u string) {
+	optional("OnOpenURL", bind(t.OnOpenURL, u))()
+}
+
+// daemonUpgradeURL is the page that explains how to update Tailscale
+// on each platform.
+const daemonUpgradeURL = "https://tailscale.com/kb/1067/update"
+
+// openDaemonUpgrade opens daemonUpgradeURL.
+func (t *trayImpl) openDaemonUpgrade() {
+	t.openURL(daemonUpgradeURL)
+}
+
+// openAdminConsole opens the admin console URL for the most recently
+// received status.
+func (t *trayImpl) openAdminConsole() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if u, ok := status.AdminURL(); ok {
+		t.openURL(u)
+	}
+}
+
+// openCaptivePortal calls OnCaptivePortal if it is set and otherwise
+// opens captivePortalURL.
+func (t *trayImpl) openCaptivePortal() {
+	if t.OnCaptivePortal != nil {
+		t.OnCaptivePortal()
+		return
+	}
+	t.openURL(captivePortalURL)
+}
+
+// openPeerService opens the URL of a web service offered by a peer
+// with OnOpenPeerService if it is set and otherwise with OnOpenURL.
+// The URL is built from what the peer claims to offer, so anything but
+// an absolute HTTP or HTTPS URL is refused.
+func (t *trayImpl) openPeerService(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if (err != nil) || ((u.Scheme != "http") && (u.Scheme != "https")) || (u.Host == "") {
+		slog.Error("refusing to open peer service", "url", rawURL, "err", err)
+		return
+	}
+	if t.OnOpenPeerService != nil {
+		t.OnOpenPeerService(u.String())
+		return
+	}
+	t.openURL(u.String())
+}
+
+// finishLogin opens the URL for the login in progress in the most
+// recently received status. If there is none but a login
+// is needed, it calls OnLogin to start one.
+func (t *trayImpl) finishLogin() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if u, ok := status.PendingAuthURL(); ok {
+		t.openURL(u)
+		return
+	}
+	if status.LoginState() != tsutil.LoggedIn {
+		optional("OnLogin", t.OnLogin)()
+	}
+}
+
+// copyStatusReport passes a report of the most recently received
+// status to OnCopy.
+func (t *trayImpl) copyStatusReport() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	optional("OnCopy", bind(t.OnCopy, status.StatusReport()))()
+}
+
+// copySelfAddr passes the local node's primary address in the most
+// recently received status to OnCopy.
+func (t *trayImpl) copySelfAddr() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if addr := selfAddrText(status); addr != "" {
+		optional("OnCopy", bind(t.OnCopy, addr))()
+	}
+}
+
+// copyPeerName passes the MagicDNS name of the peer with the given ID
+// in the most recently received status to OnCopy.
+func (t *trayImpl) copyPeerName(id tailcfg.StableNodeID) {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if name := status.PeerMagicDNSName(id); name != "" {
+		optional("OnCopy", bind(t.OnCopy, name))()
+	}
+}
+
+// showSelfQR passes the text returned by text for the most recently
+// received status to OnShowQR, unless it is empty.
+func (t *trayImpl) showSelfQR(text func(*tsutil.IPNStatus) string) {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if v := text(status); v != "" {
+		optional("OnShowQR", bind(t.OnShowQR, v))()
+	}
+}
+
+// toggleExitNode stops using the current exit node if there is one.
+// Otherwise, it switches back to the last exit node used if it's still
+// available. Menus can't be opened programmatically, so if it isn't,
+// OnExitToggle is left to choose one instead.
+func (t *trayImpl) toggleExitNode() {
+	t.m.Lock()
+	status, last := t.status, t.lastExitNode
+	t.m.Unlock()
+
+	if (status != nil) && !status.ExitNodeActive() && (last != "") && (t.OnExitNodeSelect != nil) {
+		if status.PeerCaps(last).Has(tsutil.PeerExitNode) {
+			t.selectExitNode(last)
+			return
+		}
+	}
+	optional("OnExitToggle", t.OnExitToggle)()
+}
+
+// selectExitNode passes id to OnExitNodeSelect along with the default
+// for local network access. See SetDefaultLANAccess.
+func (t *trayImpl) selectExitNode(id tailcfg.StableNodeID) {
+	t.m.Lock()
+	allowLAN := t.defaultLANAccess
+	t.m.Unlock()
+
+	optional("OnExitNodeSelect", bind2(t.OnExitNodeSelect, id, allowLAN))()
+}
+
+// toggleDefaultLANAccess passes the opposite of the current default
+// for local network access to OnDefaultLANAccessToggle.
+func (t *trayImpl) toggleDefaultLANAccess() {
+	t.m.Lock()
+	allowLAN := t.defaultLANAccess
+	t.m.Unlock()
+
+	optional("OnDefaultLANAccessToggle", bind(t.OnDefaultLANAccessToggle, !allowLAN))()
+}
+
+// copyDNSSuffix passes the tailnet's MagicDNS suffix to OnCopy.
+func (t *trayImpl) copyDNSSuffix() {
+	t.m.Lock()
+	status := t.status
+	t.m.Unlock()
+
+	if status == nil {
+		return
+	}
+	if suffix := status.DNSSuffix(); suffix != "" {
+		optional("OnCopy", bind(t.OnCopy, suffix))()
+	}
+}
+
+func (t *trayImpl) Update(s tsutil.Status) {
+	if t == nil {
+		return
+	}
+
+	t.m.Lock()
+	defer t.notify()
+	defer t.m.Unlock()
+
+	t.metrics.Updates++
+	t.lastUpdate = t.currentTime()
+	if t.closed {
+		return
+	}
+
+	switch s := s.(type) {
+	case *tsutil.IPNStatus:
+		t.history.record(statusEvent(t.currentTime(), s))
+		t.update(s)
+
+	case *tsutil.ProfileStatus:
+		if t.profileChanged(s.Profile.ID) && (t.status != nil) {
+			clear(t.prev)
+			t.update(t.status)
+		}
+		if (t.seed != nil) && (t.seed.Profile != s.Profile.ID) && (t.status != nil) {
+			// The previous session was for a different profile.
+			t.update(t.status)
+		}
+
+	case *tsutil.FileStatus:
+		t.waitingFiles = len(s.Files)
+		if t.host != nil {
+			t.host.Batch(t.updateTaildrop)
+		}
+
+	case *tsutil.PeerPathStatus:
+		t.updatePeerPaths(s)
+
+	case *tsutil.TailnetLockStatus:
+		t.lockPending = s.PendingNodes()
+		if t.host != nil {
+			t.host.Batch(t.updateLockSign)
+		}
+
+	default:
+		slog.Debug("ignoring unsupported status", "type", fmt.Sprintf("%T", s))
+	}
+}
+
+// UpdateIPN implements [Tray].
+func (t *trayImpl) UpdateIPN(s *tsutil.IPNStatus) {
+	if s == nil {
+		slog.Debug("ignoring nil status")
+		return
+	}
+	t.Update(s)
+}
+
+// SetNotificationPrefs implements [Tray].
+func (t *trayImpl) SetNotificationPrefs(prefs map[string]bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.events.prefs = prefs
+}
+
+// CurrentStatus implements [Tray].
+func (t *trayImpl) CurrentStatus() *tsutil.IPNStatus {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.status
+}
+
+// SetLastExitNode implements [Tray].
+func (t *trayImpl) SetLastExitNode(id tailcfg.StableNodeID) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.lastExitNode = id
+}
+
+// SetAutoExitNode implements [Tray].
+func (t *trayImpl) SetAutoExitNode(id tailcfg.StableNodeID) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.autoExitNode = id
+	if !t.closed && (t.status != nil) {
+		t.update(t.status)
+	}
+}
+
+// SetDefaultLANAccess implements [Tray].
+func (t *trayImpl) SetDefaultLANAccess(allow bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.defaultLANAccess = allow
+	if !t.closed && (t.status != nil) {
+		t.update(t.status)
+	}
+}
+
+// SetWindowVisible implements [Tray].
+func (t *trayImpl) SetWindowVisible(visible bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.windowVisible = visible
+	if !t.closed && (t.status != nil) {
+		t.update(t.status)
+	}
+}
+
+// SetPinnedPeers implements [Tray].
+func (t *trayImpl) SetPinnedPeers(ids []tailcfg.StableNodeID) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.pinned = set.SetOf(ids)
+	if !t.closed && (t.status != nil) {
+		t.update(t.status)
+	}
+}
+
+// resumed redraws the whole menu from the most recent status after
+// the system wakes from sleep and then calls OnResume. The status is
+// likely stale at that point, but redrawing it fixes any state the
+// tray host lost while asleep until the fresh status arrives.
+func (t *trayImpl) resumed() {
+	t.m.Lock()
+	t.refresh()
+	t.m.Unlock()
+
+	call(t.OnResume)
+}
+
+// Refresh implements [Tray].
+func (t *trayImpl) Refresh() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.refresh()
+}
+
+// refresh forgets what has been applied to the menu and then applies
+// the most recent status again. It does nothing unless the menu is
+// shown and a status has been received. It must be called with t.m
+// held.
+func (t *trayImpl) refresh() {
+	if t.closed || (t.host == nil) || (t.status == nil) {
+		return
+	}
+	clear(t.prev)
+	t.update(t.status)
+}
+
+// SetCompactMode implements [Tray].
+func (t *trayImpl) SetCompactMode(compact bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if compact == t.compactMode {
+		return
+	}
+	t.compactMode = compact
+
+	if t.closed || (t.host == nil) {
+		return
+	}
+	host := t.host
+	host.Batch(func() {
+		host.ResetMenu()
+		t.build(host, t.status)
+	})
+}
+
+// IconPNG implements [Tray].
+func (t *trayImpl) IconPNG() []byte {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return slices.Clone(t.iconPNG)
+}
+
+// History implements [Tray].
+func (t *trayImpl) History() []StatusEvent {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.history.all()
+}
+
+// Metrics implements [Tray].
+func (t *trayImpl) Metrics() Metrics {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.metrics
+}
+
+// notify passes any queued connection state changes to OnConnected
+// and OnDisconnected and any queued events to OnNotify. It must not be
+// called with t.m held.
+func (t *trayImpl) notify() {
+	t.m.Lock()
+	events, transitions, addrChanges, pathChanges := t.events.take()
+	last, lastChanged := t.lastExitNode, t.lastExitNodeChanged
+	t.lastExitNodeChanged = false
+	t.m.Unlock()
+
+	if lastChanged {
+		call(bind(t.OnLastExitNodeChanged, last))
+	}
+
+	for _, c := range addrChanges {
+		call(bind2(t.OnSelfAddrChanged, c.old, c.new))
+	}
+
+	for _, c := range pathChanges {
+		call(bind2(t.OnPeerPathChanged, c.id, c.direct))
+	}
+
+	for _, online := range transitions {
+		if online {
+			call(t.OnConnected)
+			continue
+		}
+		call(t.OnDisconnected)
+	}
+
+	for _, event := range events {
+		call(bind(t.OnNotify, event))
+	}
+}
+
+// profileChanged records id as the currently active profile and
+// returns true if a different profile was previously active.
+func (t *trayImpl) profileChanged(id ipn.ProfileID) bool {
+	prev := t.profile
+	t.profile = id
+	return (prev != "") && (prev != id)
+}
+
+// transitioned is like dirty but only returns true if key had a
+// previous value, i.e. if vals is a change rather than the initial
+// state.
+func (t *trayImpl) transitioned(key unique.Handle[string], vals ...any) bool {
+	_, ok := t.prev[key]
+	return t.dirty(key, vals...) && ok
+}
+
+func (t *trayImpl) dirty(key unique.Handle[string], vals ...any) bool {
+	prev := t.prev[key]
+	if !t.firstUpdate && slices.Equal(vals, prev) {
+		return false
+	}
+
+	t.prev[key] = vals
+	return true
+}
+
+func (t *trayImpl) update(status *tsutil.IPNStatus) {
+	t.status = status
+	if t.host == nil {
+		return
+	}
+	if notOperator := !operatorIsCurrent(status); notOperator != t.notOperator.Load() {
+		// Some items are only enabled or disabled as they are built, so
+		// the whole menu is rebuilt when whether it is read-only changes.
+		host := t.host
+		host.Batch(func() {
+			host.ResetMenu()
+			t.build(host, status)
+		})
+		return
+	}
+	t.host.Batch(func() { t.applyUpdate(status) })
+}
+
+// applyUpdate brings the menu up to date with status. It should only
+// be called by update, which groups the changes into a single batch.
+func (t *trayImpl) applyUpdate(status *tsutil.IPNStatus) {
+	t.metrics.AppliedUpdates++
+
+	_, connected := selfTitle(status, t.selfLabel, t.selfAddrFamily, t.maxNameLength)
+	connToggleLabel := connToggleText(status.Online())
+	exitToggleLabel := exitToggleText(status, t.exitNodeFlag, t.autoExitNode)
+
+	t.updateStatusIcon(status)
+	if hide := t.windowVisible && (t.OnHide != nil); (t.showItem != nil) && t.dirty(showHandle, hide) {
+		t.showItem.SetTitle(showText(hide))
+	}
+	if t.exitBadge && t.usesAccessoryLabel {
+		if label := accessoryLabel(status); t.dirty(accessoryHandle, label) {
+			t.host.SetTitle(label)
+		}
+	}
+
+	// On a machine that has never been set up, connecting can't do
+	// anything useful, so the login item takes the connection items'
+	// place. It isn't built in compact mode, so they stay then.
+	_, canLogin := loginText(status, t.OnLogin != nil)
+	setup := !t.compactMode && canLogin && (status.LoginState() == tsutil.NeverLoggedIn)
+	if t.dirty(connToggleHandle, connToggleLabel, status.Online(), status.DaemonReachable(), setup) {
+		if t.separateConnectItems {
+			setEnabled(t.connectItem, !t.isReadOnly() && status.DaemonReachable() && !status.Online())
+			setEnabled(t.disconnectItem, !t.isReadOnly() && status.DaemonReachable() && status.Online())
+			setVisible(t.connectItem, !setup)
+			setVisible(t.disconnectItem, !setup)
+		} else {
+			t.connToggleItem.SetTitle(connToggleLabel)
+			setEnabled(t.connToggleItem, !t.isReadOnly() && status.DaemonReachable())
+			setChecked(t.connToggleItem, status.Online())
+			setVisible(t.connToggleItem, !setup)
+		}
+	}
+
+	if (t.serviceItem != nil) && t.dirty(serviceHandle, status.DaemonReachable()) {
+		t.serviceItem.SetTitle(serviceText(status.DaemonReachable()))
+		setEnabled(t.serviceItem, !t.isReadOnly())
+	}
+
+	if t.dirty(exitToggleHandle, exitToggleLabel, connected, status.ExitNodeActive()) {
+		t.exitToggleItem.SetTitle(exitToggleLabel)
+		setEnabled(t.exitToggleItem, !t.isReadOnly() && connected)
+		setChecked(t.exitToggleItem, status.ExitNodeActive())
+	}
+
+	if !t.compactMode {
+		t.updateExtras(status)
+	}
+	t.updateEvents(status)
+	t.firstUpdate = false
+}
+
+// updateExtras brings the items added by buildExtras up to date with
+// status.
+func (t *trayImpl) updateExtras(status *tsutil.IPNStatus) {
+	selfTooltip, _ := selfTitle(status, SelfLabelNameAndAddr, t.selfAddrFamily, 0)
+	selfTitle, connected := selfTitle(status, t.selfLabel, t.selfAddrFamily, t.maxNameLength)
+	selfOSLabel, selfVerLabel := selfInfoText(status)
+	suggestedLabel, suggested := suggestedExitText(status, t.maxNameLength)
+	tunnelLabel, tunnel := tunnelText(status)
+
+	if t.dirty(selfHandle, selfTitle, selfTooltip, connected) {
+		t.selfNodeItem.SetTitle(fmt.Sprintf("This machine: %v", selfTitle))
+		t.selfNodeItem.SetTooltip(selfTooltip)
+		setEnabled(t.selfNodeItem, connected)
+	}
+
+	if t.dirty(selfInfoHandle, selfOSLabel, selfVerLabel) {
+		t.selfOSItem.SetTitle(selfOSLabel)
+		setVisible(t.selfOSItem, selfOSLabel != "")
+		t.selfVerItem.SetTitle(selfVerLabel)
+		setVisible(t.selfVerItem, selfVerLabel != "")
+	}
+
+	if t.selfQRAddrItem != nil {
+		addr := status.SelfAddr().IsValid()
+		name := status.MagicDNSActive() && (status.SelfDNSName() != "")
+		if t.dirty(selfQRHandle, addr, name) {
+			setVisible(t.selfQRAddrItem, addr)
+			setVisible(t.selfQRNameItem, name)
+		}
+	}
+
+	t.updateSelfAddrs(status)
+
+	if (t.renameItem != nil) && t.dirty(renameHandle, status.Online()) {
+		setEnabled(t.renameItem, !t.isReadOnly() && status.Online())
+	}
+
+	if exitRouteLabel, ok := exitRoutingText(status); t.dirty(exitRouteHandle, exitRouteLabel, ok) {
+		t.exitRouteItem.SetTitle(exitRouteLabel)
+		setVisible(t.exitRouteItem, ok)
+	}
+
+	if t.dirty(tunnelHandle, tunnelLabel, tunnel) {
+		t.tunnelItem.SetTitle(tunnelLabel)
+		setVisible(t.tunnelItem, tunnel)
+	}
+
+	if loginLabel, ok := loginText(status, t.OnLogin != nil); t.dirty(loginHandle, loginLabel, ok) {
+		t.loginItem.SetTitle(loginLabel)
+		setVisible(t.loginItem, ok)
+	}
+
+	if skew := status.ClockSkew(); t.dirty(clockSkewHandle, skew) {
+		setVisible(t.clockSkewItem, skew)
+	}
+
+	if conflictLabel, conflictTooltip, ok := routeConflictText(status); t.dirty(conflictHandle, conflictLabel, conflictTooltip, ok) {
+		t.conflictItem.SetTitle(conflictLabel)
+		t.conflictItem.SetTooltip(conflictTooltip)
+		setVisible(t.conflictItem, ok)
+	}
+
+	if version, ok := daemonVersionText(status, t.minDaemonVersion); t.dirty(daemonVerHandle, version, ok) {
+		t.daemonVerItem.SetTooltip(version)
+		setVisible(t.daemonVerItem, ok)
+	}
+
+	if captive := status.CaptivePortal(); t.dirty(captiveHandle, captive) {
+		setVisible(t.captiveItem, captive)
+	}
+
+	if serving := status.ServingAsExitNode(); t.dirty(servingHandle, serving) {
+		setVisible(t.servingItem, serving)
+	}
+
+	if incomingLabel, ok := incomingText(status); t.dirty(incomingHandle, incomingLabel, ok) {
+		t.incomingItem.SetTitle(incomingLabel)
+		setVisible(t.incomingItem, ok)
+	}
+
+	if identityLabel, ok := identityText(status); t.dirty(identityHandle, identityLabel, ok) {
+		t.identityItem.SetTitle(identityLabel)
+		setVisible(t.identityItem, ok)
+	}
+
+	if notOperator := t.notOperator.Load(); t.dirty(operatorHandle, notOperator) {
+		setVisible(t.operatorItem, notOperator)
+	}
+
+	if panicLabel, ok := panicText(status); (t.panicItem != nil) && t.dirty(panicHandle, panicLabel, ok) {
+		t.panicItem.SetTitle(panicLabel)
+		setEnabled(t.panicItem, !t.isReadOnly() && ok)
+	}
+
+	if lockLabel, ok := tailnetLockText(status); t.dirty(lockHandle, lockLabel, ok) {
+		t.lockItem.SetTitle(lockLabel)
+		setVisible(t.lockItem, ok)
+	}
+
+	if magicDNSLabel, ok := magicDNSText(status); t.dirty(magicDNSHandle, magicDNSLabel, ok) {
+		t.magicDNSItem.SetTitle(magicDNSLabel)
+		setVisible(t.magicDNSItem, ok)
+	}
+
+	if qualityLabel, ok := qualityText(status); t.dirty(qualityHandle, qualityLabel, ok) {
+		t.qualityItem.SetTitle(qualityLabel)
+		setVisible(t.qualityItem, ok)
+	}
+
+	if familiesLabel, ok := ipFamiliesText(status); t.dirty(familiesHandle, familiesLabel, ok) {
+		t.familiesItem.SetTitle(familiesLabel)
+		setVisible(t.familiesItem, ok)
+	}
+
+	if throughputLabel, ok := throughputText(status, t.formatter); t.dirty(throughputHandle, throughputLabel, ok) {
+		t.throughputItem.SetTitle(throughputLabel)
+		setVisible(t.throughputItem, ok)
+	}
+
+	if version, pending := status.UpdatePending(); t.dirty(updateHandle, version, pending) {
+		t.updateItem.SetTooltip(fmt.Sprintf("Restart Tailscale to finish updating to %v", version))
+		setVisible(t.updateItem, pending)
+	}
+
+	if t.dirty(suggestedHandle, suggestedLabel, suggested && connected) {
+		t.suggestedItem.SetTitle(suggestedLabel)
+		setEnabled(t.suggestedItem, !t.isReadOnly() && suggested && connected)
+	}
+
+	if _, ok := status.AdminURL(); t.dirty(adminHandle, ok) {
+		setEnabled(t.adminItem, ok)
+	}
+
+	if suffix, magicDNS := status.DNSSuffix(), status.MagicDNSActive(); t.dirty(dnsSuffixHandle, suffix, magicDNS) {
+		t.dnsSuffixItem.SetTitle(fmt.Sprintf("Copy DNS suffix (%v)", suffix))
+		setVisible(t.dnsSuffixItem, magicDNS)
+	}
+
+	t.updatePeers(status)
+	t.updateTaildrop()
+	t.updateConnCheck()
+	t.updateLockSign()
+	t.updateRoutes(status)
+	t.updateDERP(status)
+	if t.exitNodesItem != nil {
+		t.updateExitNodes(status)
+	}
+}
+
+// updateDERP brings the DERP latency submenu up to date with the
+// nearest few regions.
+func (t *trayImpl) updateDERP(status *tsutil.IPNStatus) {
+	labels := derpLatencyLabels(status, maxDERPRegions, t.formatter)
+	if !t.dirty(derpHandle, strings.Join(labels, "\n")) {
+		return
+	}
+
+	setVisible(t.derpItem, len(labels) > 0)
+	for i, item := range t.derpItems {
+		if i < len(labels) {
+			item.SetTitle(labels[i])
+		}
+		setVisible(item, i < len(labels))
+	}
+}
+
+// updateLockSign rebuilds the submenu of nodes waiting to be signed
+// with the local node's tailnet lock key. Those come from
+// TailnetLockStatus rather than IPNStatus, so it is also called
+// directly by Update.
+func (t *trayImpl) updateLockSign() {
+	if t.lockSignItem == nil {
+		return
+	}
+
+	keys := make([]any, 0, 2*len(t.lockPending))
+	for _, peer := range t.lockPending {
+		keys = append(keys, peer.NodeKey, peer.Name)
+	}
+	if !t.dirty(lockSignHandle, keys...) {
+		return
+	}
+
+	for _, item := range t.lockSignItems {
+		item.Remove()
+	}
+	t.lockSignItems = t.lockSignItems[:0]
+	for _, peer := range t.lockPending {
+		nodeKey := peer.NodeKey
+		item := t.lockSignItem.AddSubMenuItem(lockSignText(peer), "Sign this node's key so that it can join the tailnet")
+		item.OnClick(t.unlessReadOnly(optional("OnSignNode", bind(t.OnSignNode, nodeKey))))
+		if t.isReadOnly() {
+			item.Disable()
+		}
+		t.lockSignItems = append(t.lockSignItems, item)
+	}
+	setVisible(t.lockSignItem, len(t.lockPending) > 0)
+}
+
+// updateSelfAddrs rebuilds the submenu listing all of the local node's
+// addresses, each of which is copied when clicked.
+func (t *trayImpl) updateSelfAddrs(status *tsutil.IPNStatus) {
+	if t.selfAddrsItem == nil {
+		return
+	}
+
+	addrs := status.SelfAddrs()
+	keys := make([]any, 0, len(addrs))
+	for _, addr := range addrs {
+		keys = append(keys, addr)
+	}
+	if !t.dirty(selfAddrsHandle, keys...) {
+		return
+	}
+
+	for _, item := range t.selfAddrItems {
+		item.Remove()
+	}
+	t.selfAddrItems = t.selfAddrItems[:0]
+	for _, addr := range addrs {
+		text := addr.String()
+		item := t.selfAddrsItem.AddSubMenuItem(text, "Copy this address")
+		item.OnClick(optional("OnCopy", bind(t.OnCopy, text)))
+		t.selfAddrItems = append(t.selfAddrItems, item)
+	}
+	setVisible(t.selfAddrsItem, len(addrs) > 0)
+}
+
+// updateRoutes rebuilds the submenu of the local node's advertised
+// subnet routes. Approval happens on the control server, so clicking a
+// route just opens the local node's page in the admin console, if
+// there is one.
+func (t *trayImpl) updateRoutes(status *tsutil.IPNStatus) {
+	if t.routesItem == nil {
+		return
+	}
+
+	routes := status.SelfAdvertisedRoutes()
+	adminURL, admin := status.SelfAdminURL()
+	keys := make([]any, 0, len(routes)+2)
+	keys = append(keys, adminURL, admin)
+	for _, route := range routes {
+		keys = append(keys, route)
+	}
+	if !t.dirty(routesHandle, keys...) {
+		return
+	}
+
+	for _, item := range t.routeItems {
+		item.Remove()
+	}
+	t.routeItems = t.routeItems[:0]
+	for _, route := range routes {
+		item := t.routesItem.AddSubMenuItem(advertisedRouteText(route), "Open this machine in the admin console")
+		item.OnClick(func() { t.openURL(adminURL) })
+		setEnabled(item, admin && (t.OnOpenURL != nil))
+		t.routeItems = append(t.routeItems, item)
+	}
+	if len(routes) > 0 {
+		hint := t.routesItem.AddSubMenuItem("Routes are approved in the admin console", "")
+		hint.Disable()
+		t.routeItems = append(t.routeItems, hint)
+	}
+
+	t.routesItem.SetTitle(advertisedRoutesText(routes))
+	setVisible(t.routesItem, len(routes) > 0)
+}
+
+// updateTaildrop brings the Taildrop items up to date with the number
+// of waiting files. Those come from FileStatus rather than IPNStatus,
+// so it is also called directly by Update.
+func (t *trayImpl) updateTaildrop() {
+	if t.taildropItem == nil {
+		return
+	}
+	if t.dirty(taildropHandle, t.waitingFiles) {
+		t.taildropClear.SetTitle(clearTaildropText(t.waitingFiles))
+		setEnabled(t.taildropClear, !t.isReadOnly() && (t.OnClearTaildrop != nil) && (t.waitingFiles > 0))
+	}
+}
+
+// updateEvents queues events for any state transitions between the
+// previous status and status, coalescing connection changes into a
+// single EventConnectionUnstable if the history shows that they're
+// happening repeatedly. It also records the exit node in use, if any,
+// as the last one used.
+func (t *trayImpl) updateEvents(status *tsutil.IPNStatus) {
+	if t.transitioned(onlineHandle, status.Online()) {
+		t.events.pushTransition(status.Online())
+		switch flaps := ConnectionFlaps(t.history.all(), flapWindow); {
+		case flaps == flapThreshold:
+			t.events.push(EventConnectionUnstable)
+		case flaps > flapThreshold:
+			// Already reported as unstable.
+		case status.Online():
+			t.events.push(EventConnected)
+		default:
+			t.events.push(EventDisconnected)
+		}
+	}
+
+	// Prefs are missing while the daemon is unreachable, so the exit
+	// node is only compared once it is reachable again.
+	if status.Prefs.Valid() && t.transitioned(exitNodeHandle, status.Prefs.ExitNodeID(), status.Prefs.ExitNodeIP()) {
+		t.events.push(EventExitNodeChanged)
+	}
+
+	if addr := status.SelfAddr(); addr.IsValid() {
+		// Only valid addresses are recorded so that going offline and
+		// coming back with the same address isn't reported.
+		prev, ok := t.prev[selfAddrHandle]
+		if t.dirty(selfAddrHandle, addr) && ok && (prev[0] != addr) {
+			t.events.pushAddrChange(prev[0].(netip.Addr), addr)
+		}
+	}
+
+	if !status.Prefs.Valid() {
+		return
+	}
+	if id := status.Prefs.ExitNodeID(); (id != "") && (id != t.lastExitNode) {
+		t.lastExitNode = id
+		t.lastExitNodeChanged = true
+	}
+}
+
+func (t *trayImpl) updatePeers(status *tsutil.IPNStatus) {
+	peers := menuPeers(status, t.pinned)
+	if t.dirty(peersHandle, peerIDs(peers)...) {
+		if !t.firstUpdate {
+			// The initial list is counted as part of building the
+			// menu.
+			t.metrics.MenuRebuilds++
+		}
+		for id, p := range t.peerItems {
+			p.item.Remove()
+			delete(t.peerItems, id)
+			delete(t.prev, peerHandle(id))
+		}
+		for _, peer := range peers {
+			id := peer.StableID()
+			item := t.peersItem.AddSubMenuItem("", "")
+			pin := item.AddSubMenuItem("", "")
+			pin.OnClick(func() { t.togglePin(id) })
+			p := peerMenu{item: item, pin: pin}
+			if t.OnCopy != nil {
+				p.copyName = item.AddSubMenuItem("Copy name", "Copy this peer's MagicDNS name")
+				p.copyName.OnClick(func() { t.copyPeerName(id) })
+			}
+			if t.OnCopySSHCommand != nil {
+				p.copySSH = item.AddSubMenuItem("Copy ssh command", "Copy a command to connect to this peer via Tailscale SSH")
+				p.copySSH.OnClick(optional("OnCopySSHCommand", bind(t.OnCopySSHCommand, id)))
+			}
+			if (t.OnOpenPeerService != nil) || (t.OnOpenURL != nil) {
+				p.services = item.AddSubMenuItem("Open service", "Open a web service offered by this peer")
+			}
+			if t.OnAcceptPeerRoutes != nil {
+				p.routes = item.AddSubMenuItem("Accept this router's routes", "Accept the subnet routes advertised by this peer")
+				p.routes.OnClick(t.unlessReadOnly(optional("OnAcceptPeerRoutes", bind(t.OnAcceptPeerRoutes, id))))
+				if t.isReadOnly() {
+					p.routes.Disable()
+				}
+			}
+			t.peerItems[id] = p
+		}
+		setVisible(t.peersItem, len(peers) > 0)
+	}
+
+	routers := status.RouterPeers()
+	magicDNS := status.MagicDNSActive()
+	now := t.currentTime()
+	for _, peer := range peers {
+		id := peer.StableID()
+		caps := status.PeerCaps(id)
+		label := peerLabel(peer, caps, t.maxNameLength)
+		tooltip := peerTooltip(status, peer, caps, now, t.formatter)
+		pinned := t.pinned.Contains(id)
+		router := routers.Contains(id)
+		name := ""
+		if magicDNS {
+			name = status.PeerMagicDNSName(id)
+		}
+		ssh := (name != "") && caps.Has(tsutil.PeerSSH) && peer.Online().Get()
+		var urls []string
+		if name != "" {
+			urls = status.PeerServiceURLs(id)
+		}
+		if t.dirty(peerHandle(id), label, tooltip, pinned, router, name, ssh, strings.Join(urls, "\n")) {
+			p := t.peerItems[id]
+			p.item.SetTitle(label)
+			p.item.SetTooltip(tooltip)
+			p.pin.SetTitle(pinText(pinned))
+			if p.routes != nil {
+				setVisible(p.routes, router)
+			}
+			if p.copyName != nil {
+				setVisible(p.copyName, name != "")
+			}
+			if p.copySSH != nil {
+				setVisible(p.copySSH, ssh)
+			}
+			if p.services != nil {
+				t.updatePeerServices(&p, urls)
+				t.peerItems[id] = p
+			}
+		}
+	}
+}
+
+// updatePeerServices replaces the items in a peer's services submenu
+// with one for each of urls. The submenu is hidden if there are none.
+func (t *trayImpl) updatePeerServices(p *peerMenu, urls []string) {
+	for _, item := range p.serviceItems {
+		item.Remove()
+	}
+	p.serviceItems = p.serviceItems[:0]
+	for _, u := range urls {
+		item := p.services.AddSubMenuItem(u, "Open "+u+" in the browser")
+		item.OnClick(func() { t.openPeerService(u) })
+		p.serviceItems = append(p.serviceItems, item)
+	}
+	setVisible(p.services, len(urls) > 0)
+}
+
+func (t *trayImpl) updateExitNodes(status *tsutil.IPNStatus) {
+	if (t.exitLANItem != nil) && t.dirty(exitLANHandle, t.defaultLANAccess) {
+		t.exitLANItem.SetTitle(exitLANText(t.defaultLANAccess))
+		setChecked(t.exitLANItem, t.defaultLANAccess)
+	}
+
+	peers := exitNodePeers(status)
+	var countries []tsutil.ExitNodeCountry
+	if status.Online() {
+		countries = status.ExitNodesByLocation()
+	}
+
+	if t.dirty(exitNodeListHandle, append(peerIDs(peers), exitNodeLocationKeys(countries)...)...) {
+		for id, item := range t.exitNodeItems {
+			item.Remove()
+			delete(t.exitNodeItems, id)
+			delete(t.prev, exitNodeItemHandle(id))
+		}
+		for _, item := range t.exitCountries {
+			item.Remove()
+		}
+		t.exitCountries = nil
+
+		for _, peer := range peers {
+			t.addExitNodeItem(t.exitNodesItem, peer.StableID())
+		}
+		for _, country := range countries {
+			countryItem := t.exitNodesItem.AddSubMenuItem(countryText(country), "")
+			for _, city := range country.Cities {
+				cityItem := countryItem.AddSubMenuItem(cityText(city), "")
+				for _, peer := range city.Nodes {
+					t.addExitNodeItem(cityItem, peer.StableID())
+				}
+			}
+			t.exitCountries = append(t.exitCountries, countryItem)
+		}
+		setVisible(t.exitNodesItem, (len(peers) > 0) || (len(countries) > 0))
+	}
+
+	for _, peer := range exitNodesIn(peers, countries) {
+		label := exitNodeText(status, peer, t.maxNameLength, t.autoExitNode)
+		if t.dirty(exitNodeItemHandle(peer.StableID()), label) {
+			t.exitNodeItems[peer.StableID()].SetTitle(label)
+		}
+	}
+}
+
+// addExitNodeItem adds an item to parent that selects the exit node
+// with the given ID.
+func (t *trayImpl) addExitNodeItem(parent menuItem, id tailcfg.StableNodeID) {
+	item := parent.AddSubMenuItem("", "")
+	if t.exitNodeLANChoice {
+		use := item.AddSubMenuItem("Use", "Use this exit node without access to the local network")
+		use.OnClick(t.unlessReadOnly(optional("OnExitNodeSelect", bind2(t.OnExitNodeSelect, id, false))))
+		lan := item.AddSubMenuItem("Use with local network access", "Use this exit node while still allowing access to the local network")
+		lan.OnClick(t.unlessReadOnly(optional("OnExitNodeSelect", bind2(t.OnExitNodeSelect, id, true))))
+		if t.isReadOnly() {
+			use.Disable()
+			lan.Disable()
+		}
+	} else {
+		item.OnClick(t.unlessReadOnly(func() { t.selectExitNode(id) }))
+		if t.isReadOnly() {
+			item.Disable()
+		}
+	}
+	t.exitNodeItems[id] = item
+}
+
+func (t *trayImpl) updateStatusIcon(status *tsutil.IPNStatus) {
+	ic := statusIcon(status)
+	if (ic != statusIconWarning) && !status.DaemonVersionSupported(t.minDaemonVersion) {
+		ic = statusIconWarning
+	}
+	if t.qualityIcon {
+		ic = qualityIcon(status, ic)
+	}
+	if seed := t.seedIcon(status); seed != nil {
+		ic = seed
+	} else {
+		t.saveState(ic)
+	}
+
+	newIcon := t.usableIcon(ic)
+	if (newIcon == nil) || !t.dirty(statusIconHandle, newIcon) {
+		return
+	}
+
+	t.setIcon(newIcon)
+	t.icon = newIcon
+	t.metrics.IconSets++
+}
+
+// setIcon sets the tray icon to ic, using its template variant on
+// platforms that use template icons.
+func (t *trayImpl) setIcon(ic *icon) {
+	if t.usesTemplateIcons && (ic.template != nil) {
+		t.host.SetTemplateIcon(ic)
+		t.iconPNG = ic.template
+		return
+	}
+	t.host.SetIcon(ic)
+	t.iconPNG = ic.data
+}
+
+// usableIcon returns ic if it was decoded successfully. If it wasn't,
+// it logs the error and falls back to the icon generated in its place
+// or, failing that, the last icon that was applied or the default
+// inactive icon. It returns nil if no usable icon is available at all.
+func (t *trayImpl) usableIcon(ic *icon) *icon {
+	if ic.err == nil {
+		return ic
+	}
+	slog.Error("decode status icon", "err", ic.err)
+
+	if (ic.fallback != nil) && (ic.fallback.err == nil) {
+		return ic.fallback
+	}
+
+	if t.icon != nil {
+		return t.icon
+	}
+	if statusIconInactive.err == nil {
+		return statusIconInactive
+	}
+	return nil
+}
+
+// qualityIcon returns a variant of the plain active icon that reflects
+// the connection quality of status if ic is the plain active icon. It
+// returns ic otherwise so that other states take precedence.
+func qualityIcon(status *tsutil.IPNStatus, ic *icon) *icon {
+	if ic != statusIconActive {
+		return ic
+	}
+	score, ok := status.ConnectionQuality()
+	if !ok {
+		return ic
+	}
+	switch qualityBand(score) {
+	case "fair":
+		return statusIconFair
+	case "poor":
+		return statusIconPoor
+	default:
+		return ic
+	}
+}
+
+func statusIcon(status *tsutil.IPNStatus) *icon {
+	if !status.DaemonReachable() {
+		return statusIconWarning
+	}
+	if _, ok := status.PendingAuthURL(); ok || status.ClockSkew() || status.CaptivePortal() {
+		return statusIconWarning
+	}
+	switch status.LoginState() {
+	case tsutil.NeverLoggedIn:
+		return statusIconSetup
+	case tsutil.KeyExpired:
+		return statusIconWarning
+	}
+	if enabled, signed := status.TailnetLock(); enabled && !signed {
+		return statusIconWarning
+	}
+	if !status.Online() {
+		return statusIconInactive
+	}
+	if len(status.RouteConflicts()) > 0 {
+		return statusIconWarning
+	}
+	if status.ExitNodeActive() {
+		return statusIconExitNode
+	}
+	if _, ok := status.UpdatePending(); ok {
+		return statusIconUpdate
+	}
+	if status.ServingAsExitNode() {
+		return statusIconServing
+	}
+	return statusIconActive
+}
@@ -0,0 +1,85 @@
+//go:build linux || darwin
+
+package tray
+
+// menuHost is the platform's system tray, reduced to the operations
+// that the tray's menu needs. Its methods mirror those of systray so
+// that the menu logic can be shared between platforms and tested
+// against a fake.
+type menuHost interface {
+	AddMenuItem(title, tooltip string) menuItem
+	AddMenuItemCheckbox(title, tooltip string, checked bool) menuItem
+	AddSeparator()
+
+	// ResetMenu removes every item and separator from the menu.
+	ResetMenu()
+
+	// SetIcon sets the icon shown in the tray to the regular variant
+	// of ic.
+	SetIcon(ic *icon)
+
+	// SetTemplateIcon sets the icon shown in the tray to the template
+	// variant of ic. Platforms without template icons fall back to
+	// the regular variant.
+	SetTemplateIcon(ic *icon)
+	SetTitle(title string)
+	SetTooltip(tooltip string)
+
+	// Batch calls f and applies every change that it makes to the menu
+	// at once, so that the tray host doesn't repaint the menu after
+	// each individual change. Batches may be nested, in which case the
+	// changes are applied when the outermost one finishes. Platforms
+	// without a way to group changes just call f.
+	Batch(f func())
+}
+
+// menuItem is a single item in a menuHost's menu. Platforms that
+// don't support a particular property, such as tooltips on Linux,
+// ignore it.
+type menuItem interface {
+	// OnClick sets f to be called whenever the item is clicked.
+	OnClick(f func())
+
+	SetTitle(title string)
+	SetTooltip(tooltip string)
+	Enable()
+	Disable()
+
+	// Enabled returns true if the item can currently be clicked.
+	Enabled() bool
+
+	Check()
+	Uncheck()
+	Show()
+	Hide()
+
+	AddSubMenuItem(title, tooltip string) menuItem
+	Remove()
+}
+
+// setEnabled enables or disables item.
+func setEnabled(item menuItem, enabled bool) {
+	if enabled {
+		item.Enable()
+		return
+	}
+	item.Disable()
+}
+
+// setChecked checks or unchecks item.
+func setChecked(item menuItem, checked bool) {
+	if checked {
+		item.Check()
+		return
+	}
+	item.Uncheck()
+}
+
+// setVisible shows or hides item.
+func setVisible(item menuItem, visible bool) {
+	if visible {
+		item.Show()
+		return
+	}
+	item.Hide()
+}
@@ -0,0 +1,1543 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/persist"
+	"tailscale.com/types/ptr"
+)
+
+func TestMain(m *testing.M) {
+	// Whether the menu is read-only would otherwise depend on the user
+	// that runs the tests. TestOperator covers the other case.
+	operatorIsCurrent = func(*tsutil.IPNStatus) bool { return true }
+	os.Exit(m.Run())
+}
+
+type fakeMenuHost struct {
+	items    []*fakeMenuItem
+	icon     *icon
+	template bool
+	titles   []string
+
+	// depth is the number of batches that are in progress, batches is
+	// the number that have finished, and unbatched is the number of
+	// items added outside of any batch.
+	depth, batches, unbatched int
+}
+
+func (h *fakeMenuHost) AddMenuItem(title, tooltip string) menuItem {
+	if h.depth == 0 {
+		h.unbatched++
+	}
+	item := &fakeMenuItem{title: title, tooltip: tooltip, enabled: true, visible: true}
+	h.items = append(h.items, item)
+	return item
+}
+
+func (h *fakeMenuHost) AddMenuItemCheckbox(title, tooltip string, checked bool) menuItem {
+	item := h.AddMenuItem(title, tooltip).(*fakeMenuItem)
+	item.checked = checked
+	return item
+}
+
+func (h *fakeMenuHost) AddSeparator()             {}
+func (h *fakeMenuHost) ResetMenu()                { h.items = nil }
+func (h *fakeMenuHost) SetIcon(ic *icon)          { h.icon, h.template = ic, false }
+func (h *fakeMenuHost) SetTemplateIcon(ic *icon)  { h.icon, h.template = ic, true }
+func (h *fakeMenuHost) SetTitle(title string)     { h.titles = append(h.titles, title) }
+func (h *fakeMenuHost) SetTooltip(tooltip string) {}
+
+func (h *fakeMenuHost) Batch(f func()) {
+	h.depth++
+	defer func() {
+		h.depth--
+		if h.depth == 0 {
+			h.batches++
+		}
+	}()
+	f()
+}
+
+type fakeMenuItem struct {
+	title, tooltip   string
+	enabled, checked bool
+	visible, removed bool
+	onClick          func()
+	children         []*fakeMenuItem
+}
+
+func (i *fakeMenuItem) OnClick(f func())          { i.onClick = f }
+func (i *fakeMenuItem) SetTitle(title string)     { i.title = title }
+func (i *fakeMenuItem) SetTooltip(tooltip string) { i.tooltip = tooltip }
+func (i *fakeMenuItem) Enable()                   { i.enabled = true }
+func (i *fakeMenuItem) Disable()                  { i.enabled = false }
+func (i *fakeMenuItem) Enabled() bool             { return i.enabled }
+func (i *fakeMenuItem) Check()                    { i.checked = true }
+func (i *fakeMenuItem) Uncheck()                  { i.checked = false }
+func (i *fakeMenuItem) Show()                     { i.visible = true }
+func (i *fakeMenuItem) Hide()                     { i.visible = false }
+func (i *fakeMenuItem) Remove()                   { i.removed = true }
+
+func (i *fakeMenuItem) AddSubMenuItem(title, tooltip string) menuItem {
+	item := &fakeMenuItem{title: title, tooltip: tooltip, enabled: true, visible: true}
+	i.children = append(i.children, item)
+	return item
+}
+
+func TestMenuHost(t *testing.T) {
+	var toggled int
+	tr := New(Callbacks{OnConnToggle: func() { toggled++ }}).(*trayImpl)
+
+	var host fakeMenuHost
+	prefs := ipn.NewPrefs().View()
+	tr.build(&host, &tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+
+	conn := tr.connToggleItem.(*fakeMenuItem)
+	require.Equal(t, "Connect", conn.title)
+	require.False(t, conn.checked)
+	require.Equal(t, "This machine: Not connected", tr.selfNodeItem.(*fakeMenuItem).title)
+	require.False(t, tr.selfNodeItem.(*fakeMenuItem).enabled)
+	require.False(t, tr.peersItem.(*fakeMenuItem).visible)
+	require.Same(t, statusIconInactive, host.icon)
+
+	conn.onClick()
+	require.Equal(t, 1, toggled)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.Equal(t, "Disconnect", conn.title)
+	require.True(t, conn.checked)
+	require.Same(t, statusIconActive, host.icon)
+}
+
+func TestMetrics(t *testing.T) {
+	tr := New(Callbacks{}).(*trayImpl)
+	status := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+
+	tr.Update(status)
+	require.Equal(t, Metrics{Updates: 1}, tr.Metrics())
+
+	tr.build(&fakeMenuHost{}, status)
+	tr.Update(status)
+	tr.Update(status)
+	require.Equal(t, Metrics{
+		Updates:        3,
+		AppliedUpdates: 3,
+		IconSets:       1,
+		MenuRebuilds:   1,
+	}, tr.Metrics())
+}
+
+func TestAcceptPeerRoutes(t *testing.T) {
+	var accepted []tailcfg.StableNodeID
+	tr := New(Callbacks{OnAcceptPeerRoutes: func(id tailcfg.StableNodeID) {
+		accepted = append(accepted, id)
+	}}).(*trayImpl)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"router": (&tailcfg.Node{
+				StableID:      "router",
+				Hostinfo:      (&tailcfg.Hostinfo{Hostname: "router"}).View(),
+				PrimaryRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+			}).View(),
+			"laptop": (&tailcfg.Node{
+				StableID: "laptop",
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "laptop"}).View(),
+			}).View(),
+		},
+	}
+	tr.build(&fakeMenuHost{}, status)
+
+	router := tr.peerItems["router"].routes.(*fakeMenuItem)
+	require.True(t, router.visible)
+	require.False(t, tr.peerItems["laptop"].routes.(*fakeMenuItem).visible)
+
+	router.onClick()
+	require.Equal(t, []tailcfg.StableNodeID{"router"}, accepted)
+}
+
+func TestCopyPeerName(t *testing.T) {
+	var copied []string
+	tr := New(Callbacks{OnCopy: func(text string) { copied = append(copied, text) }}).(*trayImpl)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"laptop": (&tailcfg.Node{
+				StableID: "laptop",
+				Name:     "laptop.example.ts.net.",
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "laptop"}).View(),
+			}).View(),
+			"phone": (&tailcfg.Node{
+				StableID: "phone",
+				Name:     "phone.example.ts.net.",
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "phone"}).View(),
+			}).View(),
+			"unnamed": (&tailcfg.Node{
+				StableID: "unnamed",
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "unnamed"}).View(),
+			}).View(),
+		},
+	}
+	tr.build(&fakeMenuHost{}, status)
+	require.False(t, tr.peerItems["laptop"].copyName.(*fakeMenuItem).visible)
+
+	status.NetMap = &netmap.NetworkMap{Name: "self.example.ts.net.", DNS: tailcfg.DNSConfig{Proxied: true}}
+	tr.Update(status)
+	require.True(t, tr.peerItems["laptop"].copyName.(*fakeMenuItem).visible)
+	require.False(t, tr.peerItems["unnamed"].copyName.(*fakeMenuItem).visible)
+	tr.peerItems["phone"].copyName.(*fakeMenuItem).onClick()
+	tr.peerItems["laptop"].copyName.(*fakeMenuItem).onClick()
+	require.Equal(t, []string{"phone.example.ts.net", "laptop.example.ts.net"}, copied)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.peerItems["laptop"].copyName)
+}
+
+func TestCopySSHCommand(t *testing.T) {
+	var copied []tailcfg.StableNodeID
+	tr := New(Callbacks{OnCopySSHCommand: func(id tailcfg.StableNodeID) { copied = append(copied, id) }}).(*trayImpl)
+
+	sshHost := (&tailcfg.Hostinfo{Hostname: "server", SSH_HostKeys: []string{"ssh-ed25519 AAAA"}}).View()
+	status := &tsutil.IPNStatus{
+		State:  ipn.Running,
+		Prefs:  ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{Name: "self.example.ts.net.", DNS: tailcfg.DNSConfig{Proxied: true}},
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"server": (&tailcfg.Node{
+				StableID: "server",
+				Name:     "server.example.ts.net.",
+				Online:   ptr.To(true),
+				Hostinfo: sshHost,
+			}).View(),
+			"offline": (&tailcfg.Node{
+				StableID: "offline",
+				Name:     "offline.example.ts.net.",
+				Online:   ptr.To(false),
+				Hostinfo: sshHost,
+			}).View(),
+			"phone": (&tailcfg.Node{
+				StableID: "phone",
+				Name:     "phone.example.ts.net.",
+				Online:   ptr.To(true),
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "phone"}).View(),
+			}).View(),
+		},
+	}
+	tr.build(&fakeMenuHost{}, status)
+	require.True(t, tr.peerItems["server"].copySSH.(*fakeMenuItem).visible)
+	require.False(t, tr.peerItems["offline"].copySSH.(*fakeMenuItem).visible)
+	require.False(t, tr.peerItems["phone"].copySSH.(*fakeMenuItem).visible)
+	tr.peerItems["server"].copySSH.(*fakeMenuItem).onClick()
+	require.Equal(t, []tailcfg.StableNodeID{"server"}, copied)
+
+	require.Equal(t, "ssh server.example.ts.net", status.PeerSSHCommand("server"))
+	require.Empty(t, status.PeerSSHCommand("phone"))
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.peerItems["server"].copySSH)
+}
+
+func TestQualityIcon(t *testing.T) {
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		Health: &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+			"a": {ImpactsConnectivity: true},
+			"b": {ImpactsConnectivity: true},
+		}},
+	}
+
+	host := &fakeMenuHost{}
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.build(host, status)
+	require.Same(t, statusIconActive, tr.icon)
+
+	tr = New(Callbacks{}, WithQualityIcon(true)).(*trayImpl)
+	tr.build(host, status)
+	require.Same(t, statusIconFair, tr.icon)
+
+	status.NetMap = &netmap.NetworkMap{SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+		WorkingUDP: opt.NewBool(false),
+	}}).View()}).View()}
+	tr.Update(status)
+	require.Same(t, statusIconPoor, tr.icon)
+
+	status.Health = nil
+	status.NetMap = nil
+	tr.Update(status)
+	require.Same(t, statusIconActive, tr.icon)
+	require.Equal(t, "Connection quality: 100 (good)", tr.qualityItem.(*fakeMenuItem).title)
+}
+
+func TestTailnetLock(t *testing.T) {
+	status := &tsutil.IPNStatus{
+		State:  ipn.Running,
+		Prefs:  ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{}).View()},
+	}
+
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	item := tr.lockItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+	require.Same(t, statusIconActive, tr.icon)
+
+	status.NetMap.TKAEnabled = true
+	tr.Update(status)
+	require.True(t, item.visible)
+	require.Equal(t, "Tailnet lock: node NOT signed — action needed", item.title)
+	require.Same(t, statusIconWarning, tr.icon)
+
+	status.NetMap.SelfNode = (&tailcfg.Node{KeySignature: []byte("sig")}).View()
+	tr.Update(status)
+	require.Equal(t, "Tailnet lock: enabled (node signed)", item.title)
+	require.Same(t, statusIconActive, tr.icon)
+}
+
+func TestLockSign(t *testing.T) {
+	var signed []key.NodePublic
+	tr := New(Callbacks{OnSignNode: func(nodeKey key.NodePublic) { signed = append(signed, nodeKey) }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.False(t, tr.lockSignItem.(*fakeMenuItem).visible)
+
+	self := key.NewNLPrivate().Public()
+	nodeKey := key.NewNode().Public()
+	lock := &ipnstate.NetworkLockStatus{
+		Enabled:     true,
+		PublicKey:   self,
+		TrustedKeys: []ipnstate.TKAKey{{Key: self}},
+		FilteredPeers: []*ipnstate.TKAPeer{{
+			Name:         "laptop.example.ts.net.",
+			StableID:     "laptop",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			NodeKey:      nodeKey,
+		}},
+	}
+	tr.Update(&tsutil.TailnetLockStatus{Lock: lock})
+	require.True(t, tr.lockSignItem.(*fakeMenuItem).visible)
+	require.Len(t, tr.lockSignItems, 1)
+	item := tr.lockSignItems[0].(*fakeMenuItem)
+	require.Equal(t, "laptop.example.ts.net (100.64.0.2)", item.title)
+	item.onClick()
+	require.Equal(t, []key.NodePublic{nodeKey}, signed)
+
+	lock.FilteredPeers = nil
+	tr.Update(&tsutil.TailnetLockStatus{Lock: lock})
+	require.False(t, tr.lockSignItem.(*fakeMenuItem).visible)
+	require.Empty(t, tr.lockSignItems)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.lockSignItem)
+}
+
+func TestRefresh(t *testing.T) {
+	tr := New(Callbacks{OnConnToggle: func() {}}).(*trayImpl)
+	tr.Refresh()
+
+	var host fakeMenuHost
+	status := &tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()}
+	tr.build(&host, status)
+
+	conn := tr.connToggleItem.(*fakeMenuItem)
+	self := tr.selfNodeItem.(*fakeMenuItem)
+	conn.title, self.title, host.icon = "stale", "stale", nil
+	tr.Update(status)
+	require.Equal(t, "stale", conn.title)
+	require.Nil(t, host.icon)
+
+	tr.Refresh()
+	require.Equal(t, "Connect", conn.title)
+	require.Equal(t, "This machine: Not connected", self.title)
+	require.Same(t, statusIconInactive, host.icon)
+
+	tr.reset()
+	conn.title = "stale"
+	tr.Refresh()
+	require.Equal(t, "stale", conn.title)
+}
+
+func TestPeerServices(t *testing.T) {
+	var opened []string
+	tr := New(Callbacks{OnOpenPeerService: func(url string) { opened = append(opened, url) }}).(*trayImpl)
+
+	hostinfo := &tailcfg.Hostinfo{Hostname: "server", Services: []tailcfg.Service{{Proto: tailcfg.TCP, Port: 443}}}
+	peer := &tailcfg.Node{StableID: "server", Name: "server.example.ts.net.", Hostinfo: hostinfo.View()}
+	status := &tsutil.IPNStatus{
+		State:  ipn.Running,
+		Prefs:  ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{Name: "self.example.ts.net.", DNS: tailcfg.DNSConfig{Proxied: true}},
+		Peers:  map[tailcfg.StableNodeID]tailcfg.NodeView{"server": peer.View()},
+	}
+	tr.build(&fakeMenuHost{}, status)
+
+	p := tr.peerItems["server"]
+	require.True(t, p.services.(*fakeMenuItem).visible)
+	require.Len(t, p.serviceItems, 1)
+	p.serviceItems[0].(*fakeMenuItem).onClick()
+	require.Equal(t, []string{"https://server.example.ts.net/"}, opened)
+
+	hostinfo.Services = nil
+	peer.Hostinfo = hostinfo.View()
+	status.Peers = map[tailcfg.StableNodeID]tailcfg.NodeView{"server": peer.View()}
+	tr.Update(status)
+	p = tr.peerItems["server"]
+	require.False(t, p.services.(*fakeMenuItem).visible)
+	require.Empty(t, p.serviceItems)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.peerItems["server"].services)
+
+	opened = nil
+	tr = New(Callbacks{OnOpenURL: func(url string) { opened = append(opened, url) }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.NotNil(t, tr.peerItems["server"].services)
+	tr.openPeerService("https://server.example.ts.net/")
+	tr.openPeerService("file:///etc/passwd")
+	tr.openPeerService("javascript:alert(1)")
+	require.Equal(t, []string{"https://server.example.ts.net/"}, opened)
+}
+
+func TestTaildrop(t *testing.T) {
+	var cleared int
+	tr := New(Callbacks{OnClearTaildrop: func() { cleared++ }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	open := tr.taildropOpen.(*fakeMenuItem)
+	clear := tr.taildropClear.(*fakeMenuItem)
+	require.False(t, open.enabled)
+	require.False(t, clear.enabled)
+
+	tr.Update(&tsutil.FileStatus{Files: make([]apitype.WaitingFile, 2)})
+	require.True(t, clear.enabled)
+	require.Equal(t, "Clear received files (2)", clear.title)
+	clear.onClick()
+	require.Equal(t, 1, cleared)
+
+	tr.Update(&tsutil.FileStatus{})
+	require.False(t, clear.enabled)
+	require.Equal(t, "Clear received files", clear.title)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.taildropItem)
+}
+
+func TestExitBadge(t *testing.T) {
+	exit := ipn.NewPrefs()
+	exit.ExitNodeID = "exit"
+	status := func(state ipn.State, prefs *ipn.Prefs) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{State: state, Prefs: prefs.View()}
+	}
+
+	var host fakeMenuHost
+	tr := New(Callbacks{}, WithExitBadge(true)).(*trayImpl)
+	tr.usesAccessoryLabel = true
+	tr.build(&host, status(ipn.Running, ipn.NewPrefs()))
+	tr.Update(status(ipn.Running, exit))
+	tr.Update(status(ipn.Running, exit))
+	tr.Update(status(ipn.Stopped, exit))
+	require.Equal(t, []string{"", "EXIT", ""}, host.titles)
+
+	host = fakeMenuHost{}
+	tr = New(Callbacks{}, WithExitBadge(true)).(*trayImpl)
+	tr.usesAccessoryLabel = false
+	tr.build(&host, status(ipn.Running, exit))
+	require.Empty(t, host.titles)
+}
+
+func TestServiceStartStop(t *testing.T) {
+	var calls []bool
+	tr := New(Callbacks{OnServiceStartStop: func(start bool) { calls = append(calls, start) }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	item := tr.serviceItem.(*fakeMenuItem)
+	require.Equal(t, "Stop Tailscale service", item.title)
+	item.onClick()
+
+	tr.Update(&tsutil.IPNStatus{DaemonUnreachable: true})
+	require.Equal(t, "Start Tailscale service", item.title)
+	item.onClick()
+	require.Equal(t, []bool{false, true}, calls)
+
+	tr = New(Callbacks{OnServiceStartStop: func(bool) { t.Fatal("called in read-only mode") }}, WithReadOnly(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.False(t, tr.serviceItem.(*fakeMenuItem).enabled)
+	tr.serviceItem.(*fakeMenuItem).onClick()
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.serviceItem)
+}
+
+func TestShowHide(t *testing.T) {
+	var calls []string
+	tr := New(Callbacks{
+		OnShowWithHint: func(ShowHint) { calls = append(calls, "show") },
+		OnHide:         func() { calls = append(calls, "hide") },
+	}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	item := tr.showItem.(*fakeMenuItem)
+	require.Equal(t, "Show", item.title)
+	item.onClick()
+
+	tr.SetWindowVisible(true)
+	require.Equal(t, "Hide", item.title)
+	item.onClick()
+
+	tr.SetWindowVisible(false)
+	require.Equal(t, "Show", item.title)
+	require.Equal(t, []string{"show", "hide"}, calls)
+
+	calls = nil
+	tr = New(Callbacks{OnShowWithHint: func(ShowHint) { calls = append(calls, "show") }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	tr.SetWindowVisible(true)
+	require.Equal(t, "Show", tr.showItem.(*fakeMenuItem).title)
+	tr.showItem.(*fakeMenuItem).onClick()
+	require.Equal(t, []string{"show"}, calls)
+}
+
+func TestSelfItemAction(t *testing.T) {
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			}).View(),
+		},
+	}
+
+	var calls []string
+	cb := Callbacks{
+		OnShowWithHint: func(ShowHint) { calls = append(calls, "show") },
+		OnSelfNode:     func() { calls = append(calls, "self") },
+		OnCopy:         func(text string) { calls = append(calls, "copy "+text) },
+	}
+	for _, action := range []SelfItemAction{SelfItemCallback, SelfItemCopyAddr, SelfItemShowDetails, SelfItemNone} {
+		tr := New(cb, WithSelfItemAction(action)).(*trayImpl)
+		tr.build(&fakeMenuHost{}, status)
+		tr.selfNodeItem.(*fakeMenuItem).onClick()
+	}
+	require.Equal(t, []string{"self", "copy 100.64.0.1", "show"}, calls)
+
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	tr.selfNodeItem.(*fakeMenuItem).onClick()
+}
+
+// unsupportedStatus is a status that the tray doesn't know about.
+type unsupportedStatus struct {
+	tsutil.Status
+}
+
+func TestUpdateUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	tr.Update(unsupportedStatus{})
+	require.Contains(t, buf.String(), "ignoring unsupported status")
+	require.Contains(t, buf.String(), "tray.unsupportedStatus")
+
+	tr.UpdateIPN(nil)
+	require.EqualValues(t, 1, tr.Metrics().Updates)
+
+	status := &tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()}
+	tr.UpdateIPN(status)
+	require.Same(t, status, tr.CurrentStatus())
+}
+
+func TestTemplateIcons(t *testing.T) {
+	status := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+
+	var host fakeMenuHost
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.usesTemplateIcons = false
+	tr.build(&host, status)
+	require.Same(t, statusIconActive, host.icon)
+	require.False(t, host.template)
+	require.Equal(t, statusIconActive.data, tr.IconPNG())
+
+	host = fakeMenuHost{}
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.usesTemplateIcons = true
+	tr.build(&host, status)
+	require.Same(t, statusIconActive, host.icon)
+	require.True(t, host.template)
+	require.Equal(t, statusIconActive.template, tr.IconPNG())
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()})
+	require.Equal(t, statusIconInactive.template, tr.IconPNG())
+
+	tr.reset()
+	require.Nil(t, tr.IconPNG())
+}
+
+func TestSeparateConnectItems(t *testing.T) {
+	tr := New(Callbacks{}, WithSeparateConnectItems(true)).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+
+	require.Nil(t, tr.connToggleItem)
+	connect := tr.connectItem.(*fakeMenuItem)
+	disconnect := tr.disconnectItem.(*fakeMenuItem)
+	require.True(t, connect.enabled)
+	require.False(t, disconnect.enabled)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.False(t, connect.enabled)
+	require.True(t, disconnect.enabled)
+}
+
+func TestResumed(t *testing.T) {
+	var resumed bool
+	tr := New(Callbacks{OnResume: func() { resumed = true }}).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+
+	self := tr.selfNodeItem.(*fakeMenuItem)
+	self.title = "stale"
+	tr.Update(tr.status)
+	require.Equal(t, "stale", self.title)
+
+	tr.resumed()
+	require.True(t, resumed)
+	require.NotEqual(t, "stale", self.title)
+	require.EqualValues(t, 3, tr.Metrics().AppliedUpdates)
+}
+
+func TestCompactMode(t *testing.T) {
+	tr := New(Callbacks{OnShowWithHint: func(ShowHint) {}}, WithCompactMode(true)).(*trayImpl)
+	host := &fakeMenuHost{}
+	prefs := ipn.NewPrefs().View()
+	tr.build(host, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+
+	titles := func() (titles []string) {
+		for _, item := range host.items {
+			titles = append(titles, item.title)
+		}
+		return titles
+	}
+	require.Equal(t, []string{"Show", "Disconnect", "Enable exit node", "Quit"}, titles())
+
+	tr.SetCompactMode(false)
+	require.Greater(t, len(host.items), 4)
+	require.Contains(t, titles(), "Copy status report")
+	require.EqualValues(t, 2, tr.Metrics().MenuRebuilds)
+
+	tr.SetCompactMode(false)
+	require.EqualValues(t, 2, tr.Metrics().MenuRebuilds)
+
+	tr.SetCompactMode(true)
+	require.Equal(t, []string{"Show", "Disconnect", "Enable exit node", "Quit"}, titles())
+}
+
+func TestSelfQRItems(t *testing.T) {
+	shown := make(chan string, 1)
+	tr := New(Callbacks{OnShowQR: func(text string) { shown <- text }}, WithQRItems(true)).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+
+	addr := tr.selfQRAddrItem.(*fakeMenuItem)
+	name := tr.selfQRNameItem.(*fakeMenuItem)
+	require.False(t, addr.visible)
+	require.False(t, name.visible)
+
+	tr.Update(&tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: prefs,
+		NetMap: &netmap.NetworkMap{
+			Name: "self.tail1234.ts.net.",
+			SelfNode: (&tailcfg.Node{
+				Name:      "self.tail1234.ts.net.",
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			}).View(),
+			DNS: tailcfg.DNSConfig{Proxied: true},
+		},
+	})
+	require.True(t, addr.visible)
+	require.True(t, name.visible)
+
+	addr.onClick()
+	require.Equal(t, "100.64.0.1", <-shown)
+	name.onClick()
+	require.Equal(t, "self.tail1234.ts.net", <-shown)
+
+	tr = New(Callbacks{OnShowQR: func(string) {}}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{Prefs: prefs})
+	require.Nil(t, tr.selfQRAddrItem)
+}
+
+func TestFirstUpdate(t *testing.T) {
+	tr := New(Callbacks{}).(*trayImpl)
+	host := &fakeMenuHost{}
+	prefs := ipn.NewPrefs().View()
+	tr.build(host, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.False(t, tr.firstUpdate)
+
+	// Simulate a platform that ignored the initial state of items.
+	conn := tr.connToggleItem.(*fakeMenuItem)
+	conn.checked = false
+	tr.peersItem.Show()
+
+	tr.firstUpdate = true
+	tr.update(tr.status)
+	require.True(t, conn.checked)
+	require.False(t, tr.peersItem.(*fakeMenuItem).visible)
+	require.EqualValues(t, 1, tr.Metrics().MenuRebuilds)
+}
+
+func TestNoShowItem(t *testing.T) {
+	prefs := ipn.NewPrefs().View()
+
+	tr := New(Callbacks{}).(*trayImpl)
+	host := &fakeMenuHost{}
+	tr.build(host, &tsutil.IPNStatus{Prefs: prefs})
+	require.Nil(t, tr.showItem)
+	require.NotEqual(t, "Show", host.items[0].title)
+	tr.show()
+
+	tr = New(Callbacks{OnShowWithHint: func(ShowHint) {}}).(*trayImpl)
+	host = &fakeMenuHost{}
+	tr.build(host, &tsutil.IPNStatus{Prefs: prefs})
+	require.Equal(t, "Show", host.items[0].title)
+}
+
+func TestPollInterval(t *testing.T) {
+	prefs := ipn.NewPrefs().View()
+	polled := make(chan struct{}, 1)
+	tr := New(Callbacks{}, WithPollInterval(10*time.Millisecond, func() tsutil.Status {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		return &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs}
+	})).(*trayImpl)
+
+	tr.m.Lock()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+	tr.m.Unlock()
+
+	select {
+	case <-polled:
+	case <-time.After(time.Second):
+		t.Fatal("status was never polled")
+	}
+	require.Eventually(t, func() bool {
+		tr.m.Lock()
+		defer tr.m.Unlock()
+		return tr.status.Online()
+	}, time.Second, 5*time.Millisecond)
+
+	tr.m.Lock()
+	tr.reset()
+	tr.m.Unlock()
+	require.Nil(t, tr.watchdogDone)
+}
+
+func TestConnectivityCheck(t *testing.T) {
+	defer func(f func(context.Context, string) error) { pingTarget = f }(pingTarget)
+	var fail atomic.Bool
+	pingTarget = func(ctx context.Context, target string) error {
+		if target != "derp:nyc" {
+			return fmt.Errorf("unexpected target %q", target)
+		}
+		if fail.Load() {
+			return errors.New("timed out")
+		}
+		return nil
+	}
+
+	results := make(chan bool, 1)
+	tr := New(Callbacks{
+		OnConnectivityResult: func(ok bool) {
+			select {
+			case results <- ok:
+			default:
+			}
+		},
+	}, WithConnectivityCheck("derp:nyc", 10*time.Millisecond)).(*trayImpl)
+
+	tr.m.Lock()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	tr.m.Unlock()
+
+	require.True(t, <-results)
+	require.Eventually(t, func() bool {
+		tr.m.Lock()
+		defer tr.m.Unlock()
+		item := tr.connCheckItem.(*fakeMenuItem)
+		return item.visible && (item.title == "Last connectivity check: OK just now")
+	}, time.Second, 5*time.Millisecond)
+
+	fail.Store(true)
+	require.Eventually(t, func() bool {
+		select {
+		case ok := <-results:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool {
+		tr.m.Lock()
+		defer tr.m.Unlock()
+		return tr.connCheckItem.(*fakeMenuItem).title == "Last connectivity check: FAILED just now"
+	}, time.Second, 5*time.Millisecond)
+
+	tr.m.Lock()
+	tr.reset()
+	tr.m.Unlock()
+	require.Nil(t, tr.connCheckDone)
+}
+
+func TestWithItemOrder(t *testing.T) {
+	tr := New(Callbacks{}, WithItemOrder(ItemAdminConsole, ItemExitNode)).(*trayImpl)
+	host := &fakeMenuHost{}
+	tr.build(host, &tsutil.IPNStatus{Prefs: ipn.NewPrefs().View()})
+
+	require.Same(t, tr.adminItem, menuItem(host.items[0]))
+	require.Same(t, tr.exitToggleItem, menuItem(host.items[1]))
+	require.Same(t, tr.connToggleItem, menuItem(host.items[2]))
+	require.Same(t, tr.quitItem, menuItem(host.items[len(host.items)-1]))
+}
+
+func TestExitNodeSelect(t *testing.T) {
+	type selection struct {
+		id       tailcfg.StableNodeID
+		allowLAN bool
+	}
+	var selected []selection
+	cb := Callbacks{OnExitNodeSelect: func(id tailcfg.StableNodeID, allowLAN bool) {
+		selected = append(selected, selection{id, allowLAN})
+	}}
+
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+	prefs.ExitNodeAllowLANAccess = true
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: prefs.View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"exit": (&tailcfg.Node{
+				StableID:             "exit",
+				ComputedNameWithHost: "us-nyc-1",
+				Hostinfo:             (&tailcfg.Hostinfo{}).View(),
+				AllowedIPs:           tsaddr.ExitRoutes(),
+			}).View(),
+			"laptop": (&tailcfg.Node{
+				StableID:             "laptop",
+				ComputedNameWithHost: "laptop",
+				Hostinfo:             (&tailcfg.Hostinfo{}).View(),
+			}).View(),
+		},
+	}
+
+	tr := New(cb).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.True(t, tr.exitNodesItem.(*fakeMenuItem).visible)
+	require.Len(t, tr.exitNodeItems, 1)
+	item := tr.exitNodeItems["exit"].(*fakeMenuItem)
+	require.Equal(t, "us-nyc-1 (in use, LAN on)", item.title)
+	item.onClick()
+	require.Equal(t, []selection{{"exit", false}}, selected)
+
+	selected = nil
+	tr.SetDefaultLANAccess(true)
+	item.onClick()
+	require.Equal(t, []selection{{"exit", true}}, selected)
+
+	selected = nil
+	tr = New(cb, WithExitNodeLANChoice(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	item = tr.exitNodeItems["exit"].(*fakeMenuItem)
+	require.Nil(t, item.onClick)
+	require.Len(t, item.children, 2)
+	item.children[0].onClick()
+	item.children[1].onClick()
+	require.Equal(t, []selection{{"exit", false}, {"exit", true}}, selected)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.exitNodesItem)
+}
+
+func TestExitNodeLocations(t *testing.T) {
+	mullvad := func(id tailcfg.StableNodeID, loc *tailcfg.Location) tailcfg.NodeView {
+		return (&tailcfg.Node{
+			StableID:             id,
+			ComputedNameWithHost: string(id),
+			Tags:                 []string{"tag:mullvad-exit-node"},
+			AllowedIPs:           tsaddr.ExitRoutes(),
+			Hostinfo:             (&tailcfg.Hostinfo{Hostname: string(id), Location: loc}).View(),
+		}).View()
+	}
+
+	peers := map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"ca-tor-1": mullvad("ca-tor-1", &tailcfg.Location{Country: "Canada", CountryCode: "CA", City: "Toronto"}),
+		"nowhere":  mullvad("nowhere", nil),
+	}
+	status := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View(), Peers: peers}
+
+	tr := New(Callbacks{OnExitNodeSelect: func(tailcfg.StableNodeID, bool) {}}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	menu := tr.exitNodesItem.(*fakeMenuItem)
+	require.Len(t, menu.children, 2)
+	require.Equal(t, "nowhere", menu.children[0].title)
+
+	country := menu.children[1]
+	require.Equal(t, "🇨🇦 Canada", country.title)
+	require.Len(t, country.children, 1)
+	city := country.children[0]
+	require.Equal(t, "Toronto", city.title)
+	require.Len(t, city.children, 1)
+	require.Same(t, tr.exitNodeItems["ca-tor-1"], city.children[0])
+	require.Equal(t, "ca-tor-1", city.children[0].title)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View(), Peers: peers})
+	require.False(t, country.removed)
+
+	delete(peers, "ca-tor-1")
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View(), Peers: peers})
+	require.True(t, country.removed)
+	require.Len(t, tr.exitNodeItems, 1)
+}
+
+func TestDefaultLANAccess(t *testing.T) {
+	var toggled []bool
+	tr := New(Callbacks{
+		OnExitNodeSelect:         func(tailcfg.StableNodeID, bool) {},
+		OnDefaultLANAccessToggle: func(allow bool) { toggled = append(toggled, allow) },
+	}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	item := tr.exitLANItem.(*fakeMenuItem)
+	require.Same(t, item, tr.exitNodesItem.(*fakeMenuItem).children[0])
+	require.Equal(t, "Local network access: off", item.title)
+	item.onClick()
+	require.Equal(t, []bool{true}, toggled)
+
+	tr.SetDefaultLANAccess(true)
+	require.Equal(t, "Local network access: on", item.title)
+	require.True(t, item.checked)
+	item.onClick()
+	require.Equal(t, []bool{true, false}, toggled)
+
+	tr = New(Callbacks{
+		OnExitNodeSelect:         func(tailcfg.StableNodeID, bool) {},
+		OnDefaultLANAccessToggle: func(bool) {},
+	}, WithExitNodeLANChoice(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.exitLANItem)
+}
+
+func TestUpdatePending(t *testing.T) {
+	var applied atomic.Bool
+	tr := New(Callbacks{OnApplyUpdate: func() { applied.Store(true) }}).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()})
+
+	item := tr.updateItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+
+	prefs.AutoUpdate.Apply.Set(true)
+	status := &tsutil.IPNStatus{
+		State:        ipn.Running,
+		Prefs:        prefs.View(),
+		ClientUpdate: &tailcfg.ClientVersion{LatestVersion: "1.78.0"},
+	}
+	tr.Update(status)
+	require.True(t, item.visible)
+	require.Contains(t, item.tooltip, "1.78.0")
+	require.Same(t, statusIconUpdate, statusIcon(status))
+
+	item.onClick()
+	require.True(t, applied.Load())
+}
+
+func TestFinishLogin(t *testing.T) {
+	tr := New(Callbacks{}).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs})
+	item := tr.loginItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+
+	status := &tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs, BrowseToURL: "https://login.tailscale.com/a/1234"}
+	tr.Update(status)
+	require.True(t, item.visible)
+	require.Same(t, statusIconWarning, statusIcon(status))
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.False(t, item.visible)
+}
+
+func TestLoginState(t *testing.T) {
+	var logins int
+	tr := New(Callbacks{OnLogin: func() { logins++ }}).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()})
+	item := tr.loginItem.(*fakeMenuItem)
+	require.True(t, item.visible)
+	require.Equal(t, "Set up Tailscale", item.title)
+	require.Equal(t, "This machine: Not set up", tr.selfNodeItem.(*fakeMenuItem).title)
+
+	item.onClick()
+	require.Equal(t, 1, logins)
+
+	prefs.LoggedOut = true
+	tr.Update(&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()})
+	require.Equal(t, "Log in", item.title)
+	require.Same(t, statusIconInactive, tr.icon)
+
+	prefs.LoggedOut = false
+	prefs.Persist = &persist.Persist{NodeID: "self"}
+	tr.Update(&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()})
+	require.Equal(t, "Re-authenticate", item.title)
+	require.Equal(t, "This machine: Key expired", tr.selfNodeItem.(*fakeMenuItem).title)
+	require.Same(t, statusIconWarning, tr.icon)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View(), BrowseToURL: "https://login.tailscale.com/a/1234"})
+	require.Equal(t, "Finish login in browser", item.title)
+	item.onClick()
+	require.Equal(t, 1, logins)
+}
+
+func TestSetup(t *testing.T) {
+	var logins int
+	tr := New(Callbacks{OnLogin: func() { logins++ }}).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()})
+	toggle := tr.connToggleItem.(*fakeMenuItem)
+	login := tr.loginItem.(*fakeMenuItem)
+	require.False(t, toggle.visible)
+	require.True(t, login.visible)
+	require.Equal(t, "Set up Tailscale", login.title)
+	require.Same(t, statusIconSetup, tr.icon)
+
+	login.onClick()
+	require.Equal(t, 1, logins)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()})
+	require.True(t, toggle.visible)
+	require.False(t, login.visible)
+	require.Same(t, statusIconActive, tr.icon)
+
+	tr = New(Callbacks{}, WithSeparateConnectItems(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()})
+	require.True(t, tr.connectItem.(*fakeMenuItem).visible)
+	require.Same(t, statusIconSetup, tr.icon)
+}
+
+func TestLastExitNode(t *testing.T) {
+	var selected []tailcfg.StableNodeID
+	var saved []tailcfg.StableNodeID
+	var toggled int
+	tr := New(Callbacks{
+		OnExitToggle:          func() { toggled++ },
+		OnExitNodeSelect:      func(id tailcfg.StableNodeID, allowLAN bool) { selected = append(selected, id) },
+		OnLastExitNodeChanged: func(id tailcfg.StableNodeID) { saved = append(saved, id) },
+	}).(*trayImpl)
+
+	peers := map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"exit": (&tailcfg.Node{
+			StableID:   "exit",
+			Hostinfo:   (&tailcfg.Hostinfo{}).View(),
+			AllowedIPs: tsaddr.ExitRoutes(),
+		}).View(),
+	}
+	off := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View(), Peers: peers}
+	tr.build(&fakeMenuHost{}, off)
+
+	tr.toggleExitNode()
+	require.Equal(t, 1, toggled)
+	require.Empty(t, selected)
+
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View(), Peers: peers})
+	require.Equal(t, []tailcfg.StableNodeID{"exit"}, saved)
+
+	tr.toggleExitNode()
+	require.Equal(t, 2, toggled)
+
+	tr.Update(off)
+	tr.toggleExitNode()
+	require.Equal(t, 2, toggled)
+	require.Equal(t, []tailcfg.StableNodeID{"exit"}, selected)
+
+	tr.SetLastExitNode("gone")
+	tr.toggleExitNode()
+	require.Equal(t, 3, toggled)
+	require.Equal(t, []tailcfg.StableNodeID{"exit"}, saved)
+}
+
+func TestReadOnly(t *testing.T) {
+	var fired []string
+	record := func(name string) func() {
+		return func() { fired = append(fired, name) }
+	}
+	tr := New(Callbacks{
+		OnShowWithHint:     func(ShowHint) { fired = append(fired, "show") },
+		OnConnToggle:       record("conn"),
+		OnExitToggle:       record("exit"),
+		OnUseSuggestedExit: record("suggested"),
+		OnApplyUpdate:      record("update"),
+		OnQuit:             record("quit"),
+		OnExitNodeSelect:   func(tailcfg.StableNodeID, bool) { fired = append(fired, "select") },
+	}, WithReadOnly(true)).(*trayImpl)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"exit": (&tailcfg.Node{
+				StableID:   "exit",
+				Hostinfo:   (&tailcfg.Hostinfo{}).View(),
+				AllowedIPs: tsaddr.ExitRoutes(),
+			}).View(),
+		},
+	}
+	tr.build(&fakeMenuHost{}, status)
+
+	conn := tr.connToggleItem.(*fakeMenuItem)
+	exit := tr.exitToggleItem.(*fakeMenuItem)
+	node := tr.exitNodeItems["exit"].(*fakeMenuItem)
+	require.False(t, conn.enabled)
+	require.False(t, exit.enabled)
+	require.False(t, node.enabled)
+	require.False(t, tr.updateItem.(*fakeMenuItem).enabled)
+
+	conn.onClick()
+	exit.onClick()
+	node.onClick()
+	tr.suggestedItem.(*fakeMenuItem).onClick()
+	tr.updateItem.(*fakeMenuItem).onClick()
+	tr.showItem.(*fakeMenuItem).onClick()
+	tr.quitItem.(*fakeMenuItem).onClick()
+	require.Equal(t, []string{"show", "quit"}, fired)
+}
+
+func TestClockSkew(t *testing.T) {
+	tr := New(Callbacks{}).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	item := tr.clockSkewItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.NeedsLogin,
+		Prefs: prefs,
+		Health: &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+			"tls-connection-failed": {Text: "x509: certificate has expired or is not yet valid"},
+		}},
+	}
+	tr.Update(status)
+	require.True(t, item.visible)
+	require.Same(t, statusIconWarning, statusIcon(status))
+}
+
+func TestConfirmDisconnect(t *testing.T) {
+	var toggled int
+	var pending func()
+	cb := Callbacks{
+		OnConnToggle:        func() { toggled++ },
+		OnConfirmDisconnect: func(proceed func()) { pending = proceed },
+	}
+	online := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+
+	tr := New(cb).(*trayImpl)
+	tr.build(&fakeMenuHost{}, online)
+	tr.connToggleItem.(*fakeMenuItem).onClick()
+	require.Equal(t, 1, toggled)
+	require.Nil(t, pending)
+
+	tr = New(cb, WithConfirmDisconnect(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, online)
+	tr.connToggleItem.(*fakeMenuItem).onClick()
+	require.Equal(t, 1, toggled)
+	require.NotNil(t, pending)
+	pending()
+	require.Equal(t, 2, toggled)
+
+	pending = nil
+	tr.Update(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()})
+	tr.connToggleItem.(*fakeMenuItem).onClick()
+	require.Equal(t, 3, toggled)
+	require.Nil(t, pending)
+
+	tr = New(cb, WithConfirmDisconnect(true), WithSeparateConnectItems(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, online)
+	tr.disconnectItem.(*fakeMenuItem).onClick()
+	require.Equal(t, 3, toggled)
+	require.NotNil(t, pending)
+}
+
+func TestPanicItem(t *testing.T) {
+	var panics int
+	cb := Callbacks{OnPanic: func() { panics++ }}
+
+	tr := New(cb).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+	require.Nil(t, tr.panicItem)
+
+	tr = New(cb, WithPanicItem(true)).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()})
+	item := tr.panicItem.(*fakeMenuItem)
+	require.Equal(t, "⛔ Panic: disconnect and block incoming", item.title)
+	require.True(t, item.enabled)
+	item.onClick()
+	require.Equal(t, 1, panics)
+
+	prefs.ShieldsUp = true
+	prefs.WantRunning = false
+	tr.Update(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs.View()})
+	require.Equal(t, "Locked down (disconnected, shields up)", item.title)
+	require.False(t, item.enabled)
+}
+
+func TestCaptivePortal(t *testing.T) {
+	var opened int
+	tr := New(Callbacks{OnCaptivePortal: func() { opened++ }}).(*trayImpl)
+	prefs := ipn.NewPrefs().View()
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	item := tr.captiveItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+	require.True(t, item.enabled)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: prefs,
+		Health: &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+			"captive-portal-detected": {Title: "Captive portal detected"},
+		}},
+	}
+	tr.Update(status)
+	require.True(t, item.visible)
+	require.Same(t, statusIconWarning, tr.icon)
+
+	item.onClick()
+	require.Equal(t, 1, opened)
+
+	var urls []string
+	tr = New(Callbacks{OnOpenURL: func(url string) { urls = append(urls, url) }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	item = tr.captiveItem.(*fakeMenuItem)
+	require.True(t, item.enabled)
+	item.onClick()
+	require.Equal(t, []string{captivePortalURL}, urls)
+}
+
+func TestRouteConflict(t *testing.T) {
+	tr := New(Callbacks{}).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	prefs.RouteAll = true
+	status := func(routes ...string) *tsutil.IPNStatus {
+		peers := map[tailcfg.StableNodeID]tailcfg.NodeView{}
+		for i, r := range routes {
+			id := tailcfg.StableNodeID(fmt.Sprint("router", i))
+			peers[id] = (&tailcfg.Node{
+				StableID:             id,
+				ComputedNameWithHost: string(id),
+				Hostinfo:             (&tailcfg.Hostinfo{}).View(),
+				PrimaryRoutes:        []netip.Prefix{netip.MustParsePrefix(r)},
+			}).View()
+		}
+		return &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View(), Peers: peers}
+	}
+
+	tr.build(&fakeMenuHost{}, status("10.0.0.0/24", "10.0.1.0/24"))
+	item := tr.conflictItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+	require.False(t, item.enabled)
+	require.NotSame(t, statusIconWarning, tr.icon)
+
+	tr.Update(status("10.0.0.0/24", "10.0.0.0/16"))
+	require.True(t, item.visible)
+	require.Equal(t, "Route conflict: 10.0.0.0/24 advertised by 2 nodes", item.title)
+	require.Equal(t, "10.0.0.0/24: router0, router1", item.tooltip)
+	require.Same(t, statusIconWarning, tr.icon)
+
+	tr.Update(status("10.0.0.0/24", "10.0.0.0/16", "10.0.1.0/24"))
+	require.Equal(t, "Route conflict: 10.0.0.0/24 advertised by 2 nodes (+1 more)", item.title)
+	require.Equal(t, "10.0.0.0/24: router0, router1\n10.0.1.0/24: router1, router2", item.tooltip)
+
+	tr.Update(status("10.0.0.0/24"))
+	require.False(t, item.visible)
+}
+
+func TestAdvertisedRoutes(t *testing.T) {
+	var opened []string
+	tr := New(Callbacks{OnOpenURL: func(url string) { opened = append(opened, url) }}).(*trayImpl)
+
+	prefs := ipn.NewPrefs()
+	status := func(approved ...netip.Prefix) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{
+			State: ipn.Running,
+			Prefs: prefs.View(),
+			NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+				Addresses:  []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+				AllowedIPs: approved,
+			}).View()},
+		}
+	}
+
+	tr.build(&fakeMenuHost{}, status())
+	item := tr.routesItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+	require.Empty(t, item.children)
+
+	lan, office := netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("10.0.0.0/16")
+	prefs.AdvertiseRoutes = []netip.Prefix{lan, office}
+	tr.Update(status(lan))
+	require.True(t, item.visible)
+	require.Equal(t, "Advertised routes: 1 of 2 approved", item.title)
+	require.Len(t, item.children, 3)
+	require.Equal(t, "10.0.0.0/16 (pending approval)", item.children[0].title)
+	require.Equal(t, "✓ 192.168.1.0/24 (approved)", item.children[1].title)
+	require.False(t, item.children[2].enabled)
+
+	item.children[0].onClick()
+	require.Equal(t, []string{"https://login.tailscale.com/admin/machines/100.64.0.1"}, opened)
+
+	tr.Update(status(lan, office))
+	require.Equal(t, "Advertised routes: all approved", item.title)
+	require.True(t, item.children[0].removed)
+	require.Len(t, item.children, 6)
+	require.Equal(t, "✓ 10.0.0.0/16 (approved)", item.children[3].title)
+
+	prefs.AdvertiseRoutes = nil
+	tr.Update(status())
+	require.False(t, item.visible)
+}
+
+func TestSelfAddrs(t *testing.T) {
+	var copied []string
+	tr := New(Callbacks{OnCopy: func(text string) { copied = append(copied, text) }}).(*trayImpl)
+	status := func(addrs ...string) *tsutil.IPNStatus {
+		var prefixes []netip.Prefix
+		for _, a := range addrs {
+			prefixes = append(prefixes, netip.MustParsePrefix(a))
+		}
+		return &tsutil.IPNStatus{
+			State:  ipn.Running,
+			Prefs:  ipn.NewPrefs().View(),
+			NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{Addresses: prefixes}).View()},
+		}
+	}
+
+	tr.build(&fakeMenuHost{}, status())
+	item := tr.selfAddrsItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+
+	tr.Update(status("fd7a:115c:a1e0::1/128", "100.64.0.1/32", "100.100.0.1/32"))
+	require.True(t, item.visible)
+	require.Len(t, item.children, 3)
+	require.Equal(t, "100.64.0.1", item.children[0].title)
+	require.Equal(t, "100.100.0.1", item.children[1].title)
+	require.Equal(t, "fd7a:115c:a1e0::1", item.children[2].title)
+	require.Contains(t, tr.selfNodeItem.(*fakeMenuItem).title, "100.64.0.1")
+
+	item.children[1].onClick()
+	require.Equal(t, []string{"100.100.0.1"}, copied)
+
+	tr.Update(status("fd7a:115c:a1e0::1/128", "100.64.0.1/32", "100.100.0.1/32"))
+	require.Len(t, item.children, 3)
+
+	tr.Update(status("100.64.0.1/32"))
+	require.True(t, item.children[0].removed)
+	require.Len(t, item.children, 4)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status("100.64.0.1/32"))
+	require.Nil(t, tr.selfAddrsItem)
+}
+
+func TestDaemonVersion(t *testing.T) {
+	var opened []string
+	tr := New(Callbacks{OnOpenURL: func(url string) { opened = append(opened, url) }}, WithMinDaemonVersion("1.80.0")).(*trayImpl)
+	status := func(version string) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{
+			State: ipn.Running,
+			Prefs: ipn.NewPrefs().View(),
+			NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+				Hostinfo: (&tailcfg.Hostinfo{IPNVersion: version}).View(),
+			}).View()},
+		}
+	}
+
+	tr.build(&fakeMenuHost{}, status("1.80.2-tabc"))
+	item := tr.daemonVerItem.(*fakeMenuItem)
+	require.False(t, item.visible)
+	require.Same(t, statusIconActive, tr.icon)
+
+	tr.Update(status("1.76.1-t77ae916c7"))
+	require.True(t, item.visible)
+	require.Equal(t, "Tailscale 1.76.1 is older than 1.80.0, the oldest supported version", item.tooltip)
+	require.Same(t, statusIconWarning, tr.icon)
+
+	item.onClick()
+	require.Equal(t, []string{daemonUpgradeURL}, opened)
+
+	tr = New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status("1.76.1-t77ae916c7"))
+	require.False(t, tr.daemonVerItem.(*fakeMenuItem).visible)
+}
+
+func TestAboutItem(t *testing.T) {
+	var abouts int
+	cb := Callbacks{OnAbout: func() { abouts++ }}
+	status := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+
+	tr := New(cb).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.aboutMenuItem)
+
+	tr = New(Callbacks{}, WithAboutItem(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	require.Nil(t, tr.aboutMenuItem)
+
+	host := &fakeMenuHost{}
+	tr = New(cb, WithAboutItem(true), WithVersion("v1.2.3")).(*trayImpl)
+	tr.build(host, status)
+	item := tr.aboutMenuItem.(*fakeMenuItem)
+	require.Equal(t, "About Trayscale", item.title)
+	require.Equal(t, "Trayscale v1.2.3", item.tooltip)
+	require.Same(t, item, host.items[len(host.items)-2])
+
+	item.onClick()
+	require.Equal(t, 1, abouts)
+}
+
+func TestOperator(t *testing.T) {
+	defer func(f func(*tsutil.IPNStatus) bool) { operatorIsCurrent = f }(operatorIsCurrent)
+	operatorIsCurrent = func(s *tsutil.IPNStatus) bool { return s.Prefs.OperatorUser() == "alice" }
+
+	var toggled int
+	tr := New(Callbacks{OnConnToggle: func() { toggled++ }}).(*trayImpl)
+	prefs := ipn.NewPrefs()
+	status := func() *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{
+			State: ipn.Running,
+			Prefs: prefs.View(),
+		}
+	}
+
+	tr.build(&fakeMenuHost{}, status())
+	toggle := tr.connToggleItem.(*fakeMenuItem)
+	require.False(t, toggle.enabled)
+	require.True(t, tr.operatorItem.(*fakeMenuItem).visible)
+	toggle.onClick()
+	require.Zero(t, toggled)
+
+	prefs.OperatorUser = "alice"
+	tr.Update(status())
+	require.NotSame(t, toggle, tr.connToggleItem)
+	toggle = tr.connToggleItem.(*fakeMenuItem)
+	require.True(t, toggle.enabled)
+	require.False(t, tr.operatorItem.(*fakeMenuItem).visible)
+	toggle.onClick()
+	require.Equal(t, 1, toggled)
+}
+
+func TestRename(t *testing.T) {
+	var renames int
+	cb := Callbacks{OnRename: func() { renames++ }}
+	prefs := ipn.NewPrefs().View()
+
+	tr := New(Callbacks{}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.Nil(t, tr.renameItem)
+
+	tr = New(cb).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	item := tr.renameItem.(*fakeMenuItem)
+	require.True(t, item.enabled)
+	item.onClick()
+	require.Equal(t, 1, renames)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+	require.False(t, item.enabled)
+
+	tr = New(cb, WithReadOnly(true)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	item = tr.renameItem.(*fakeMenuItem)
+	require.False(t, item.enabled)
+	item.onClick()
+	require.Equal(t, 1, renames)
+}
+
+func TestBatch(t *testing.T) {
+	tr := New(Callbacks{OnShowWithHint: func(ShowHint) {}}).(*trayImpl)
+	host := &fakeMenuHost{}
+	prefs := ipn.NewPrefs().View()
+	tr.build(host, &tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+	require.Equal(t, 1, host.batches)
+	require.Zero(t, host.unbatched)
+
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	require.Equal(t, 2, host.batches)
+
+	tr.SetCompactMode(true)
+	require.Equal(t, 3, host.batches)
+	require.Zero(t, host.unbatched)
+	require.Zero(t, host.depth)
+}
+
+func TestSelfLabelFormat(t *testing.T) {
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		Prefs: ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+			ComputedNameWithHost: "laptop",
+			Addresses:            []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View()},
+	}
+
+	tr := New(Callbacks{}, WithSelfLabelFormat(SelfLabelAddr)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, status)
+	self := tr.selfNodeItem.(*fakeMenuItem)
+	require.Equal(t, "This machine: 100.64.0.1", self.title)
+	require.Equal(t, "laptop (100.64.0.1)", self.tooltip)
+
+	tr = New(Callbacks{}, WithSelfLabelFormat(SelfLabelFormat(42))).(*trayImpl)
+	require.Equal(t, SelfLabelNameAndAddr, tr.selfLabel)
+	tr.build(&fakeMenuHost{}, status)
+	require.Equal(t, "This machine: laptop (100.64.0.1)", tr.selfNodeItem.(*fakeMenuItem).title)
+}
@@ -0,0 +1,396 @@
+package tray
+
+import (
+	"log/slog"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"golang.org/x/text/language"
+)
+
+// An Option configures optional behavior of a tray.
+type Option func(*options)
+
+type options struct {
+	autoShow       bool
+	leftClickShows bool
+	maxNameLength  int
+	selfAddrFamily AddrFamily
+	selfItemAction SelfItemAction
+	selfLabel      SelfLabelFormat
+	formatter      formatter
+
+	separateConnectItems bool
+	compactMode          bool
+	qrItems              bool
+	exitNodeLANChoice    bool
+	exitNodeFlag         bool
+	exitBadge            bool
+	qualityIcon          bool
+	readOnly             bool
+	confirmDisconnects   bool
+	panicButton          bool
+	aboutItem            bool
+
+	pollInterval time.Duration
+	pollStatus   func() tsutil.Status
+
+	connCheckTarget   string
+	connCheckInterval time.Duration
+
+	healthAddr       string
+	stateFile        string
+	minDaemonVersion string
+	version          string
+
+	itemOrder []MenuItemID
+
+	// now returns the current time. It is only replaced by tests. Use
+	// currentTime instead of calling it directly.
+	now func() time.Time
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		maxNameLength:  defaultMaxNameLength,
+		selfAddrFamily: IPv4,
+		itemOrder:      defaultItemOrder,
+		leftClickShows: true,
+		now:            time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// currentTime returns the current time according to o.now, or
+// time.Now if it isn't set, such as in a tray constructed directly
+// instead of via New.
+func (o *options) currentTime() time.Time {
+	if o.now == nil {
+		return time.Now()
+	}
+	return o.now()
+}
+
+// WithAutoShow sets whether or not the tray should call
+// OnShowWithHint once it has started for the first time. By default it
+// does not, leaving it up to the app to decide whether or not the
+// window should be shown on launch.
+//
+// On macOS, activating the hint gives the app a Dock icon, so leaving
+// this disabled also keeps the app out of the Dock until the window is
+// shown some other way.
+func WithAutoShow(show bool) Option {
+	return func(o *options) {
+		o.autoShow = show
+	}
+}
+
+// WithLeftClickShows sets whether activating the tray icon, usually
+// by left-clicking it, calls OnShowWithHint. If it doesn't, the tray
+// host is asked to open the menu instead. It is enabled by default.
+//
+// This only affects Linux, and only tray hosts that honor the
+// StatusNotifierItem ItemIsMenu property. Some, such as GNOME's
+// AppIndicator extension, always open the menu on a left-click no
+// matter what. On macOS, clicking the menu bar icon always opens the
+// menu.
+func WithLeftClickShows(show bool) Option {
+	return func(o *options) {
+		o.leftClickShows = show
+	}
+}
+
+// WithMaxNameLength sets the maximum number of characters of a node's
+// name that are shown in a menu item. Longer names are ellipsized.
+// Where the platform supports it, the full name is still available in
+// the item's tooltip. A length of zero or less disables truncation.
+func WithMaxNameLength(length int) Option {
+	return func(o *options) {
+		o.maxNameLength = length
+	}
+}
+
+// AddrFamily selects which of a node's addresses are displayed.
+type AddrFamily int
+
+const (
+	// IPv4 displays only the IPv4 address.
+	IPv4 AddrFamily = iota
+
+	// IPv6 displays only the IPv6 address.
+	IPv6
+
+	// BothFamilies displays both the IPv4 and IPv6 addresses.
+	BothFamilies
+)
+
+// WithSelfAddrFamily sets which of the local node's addresses are
+// shown in the self item's label. The default is [IPv4]. If the node
+// doesn't have an address of the requested family, its primary
+// address is shown instead.
+func WithSelfAddrFamily(family AddrFamily) Option {
+	return func(o *options) {
+		o.selfAddrFamily = family
+	}
+}
+
+// SelfLabelFormat selects how the local node is described in the self
+// item's label.
+type SelfLabelFormat int
+
+const (
+	// SelfLabelNameAndAddr shows the node's name followed by its
+	// addresses, such as "laptop (100.64.0.1)".
+	SelfLabelNameAndAddr SelfLabelFormat = iota
+
+	// SelfLabelAddr shows only the node's addresses.
+	SelfLabelAddr
+
+	// SelfLabelName shows only the node's name.
+	SelfLabelName
+)
+
+// WithSelfLabelFormat sets how the local node is described in the
+// self item's label. The item's tooltip always shows both its name
+// and addresses. The default is [SelfLabelNameAndAddr], which is also
+// used if format isn't one of the defined formats.
+func WithSelfLabelFormat(format SelfLabelFormat) Option {
+	return func(o *options) {
+		if (format < SelfLabelNameAndAddr) || (format > SelfLabelName) {
+			slog.Warn("ignoring unknown self label format", "format", int(format))
+			format = SelfLabelNameAndAddr
+		}
+		o.selfLabel = format
+	}
+}
+
+// SelfItemAction selects what happens when the self item, which shows
+// the local node's name and address, is clicked.
+type SelfItemAction int
+
+const (
+	// SelfItemCallback calls OnSelfNode.
+	SelfItemCallback SelfItemAction = iota
+
+	// SelfItemCopyAddr passes the local node's address to OnCopy.
+	SelfItemCopyAddr
+
+	// SelfItemShowDetails asks for the window to be shown.
+	SelfItemShowDetails
+
+	// SelfItemNone does nothing, leaving the item for display only.
+	// The item isn't disabled, as that would make its submenu
+	// unreachable.
+	SelfItemNone
+)
+
+// WithSelfItemAction sets what happens when the self item is clicked.
+// The default is [SelfItemCallback].
+func WithSelfItemAction(action SelfItemAction) Option {
+	return func(o *options) {
+		o.selfItemAction = action
+	}
+}
+
+// WithSeparateConnectItems sets whether the tray shows separate
+// "Connect" and "Disconnect" items, only one of which is enabled at a
+// time, instead of a single item that toggles the connection. Clicking
+// them calls OnConnect and OnDisconnect respectively.
+func WithSeparateConnectItems(separate bool) Option {
+	return func(o *options) {
+		o.separateConnectItems = separate
+	}
+}
+
+// WithLocale sets the locale used to format numbers and amounts of
+// time, such as how long ago a peer was last seen. Unsupported locales
+// fall back to the closest supported one. The default is English.
+func WithLocale(tag language.Tag) Option {
+	return func(o *options) {
+		o.formatter = newFormatter(tag)
+	}
+}
+
+// WithCompactMode sets whether the tray starts with a compact menu
+// that only contains the items for showing the window, connecting,
+// toggling the exit node, and quitting. It can be changed later with
+// [Tray.SetCompactMode].
+func WithCompactMode(compact bool) Option {
+	return func(o *options) {
+		o.compactMode = compact
+	}
+}
+
+// WithQRItems sets whether the local node's submenu has items for
+// showing its address and MagicDNS name as QR codes. Clicking them
+// calls OnShowQR. They are never shown if OnShowQR is nil.
+func WithQRItems(show bool) Option {
+	return func(o *options) {
+		o.qrItems = show
+	}
+}
+
+// WithExitNodeFlag sets whether the flag of the country that the
+// current exit node is in is shown next to the exit node item's
+// label. Nothing is shown for exit nodes without location data.
+func WithExitNodeFlag(show bool) Option {
+	return func(o *options) {
+		o.exitNodeFlag = show
+	}
+}
+
+// WithExitBadge sets whether a short "EXIT" label is shown next to
+// the tray icon while an exit node is in use. It only has an effect
+// on macOS, where the menu bar can show text alongside an icon.
+func WithExitBadge(show bool) Option {
+	return func(o *options) {
+		o.exitBadge = show
+	}
+}
+
+// WithQualityIcon sets whether the tray icon reflects the connection
+// quality score while connected. If it does, the icon gets a badge
+// while the quality is only fair or poor. States that already have
+// their own icon, such as using an exit node, are unaffected.
+func WithQualityIcon(show bool) Option {
+	return func(o *options) {
+		o.qualityIcon = show
+	}
+}
+
+// WithReadOnly sets whether the tray only displays status, such as
+// for deployments where Tailscale is managed by an administrator. In
+// read-only mode, every item that would change Tailscale's state is
+// disabled and clicks on them are ignored. Show, Quit, the copy items,
+// the local node's details and QR codes, the admin console, finishing
+// a login, and pinning peers all remain available. The tray is also
+// read-only whenever the status says that the current user isn't
+// allowed to change Tailscale's settings, regardless of this option.
+// See [tsutil.IPNStatus.OperatorIsCurrent].
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		o.readOnly = readOnly
+	}
+}
+
+// WithConfirmDisconnect sets whether disconnecting from the tray
+// passes the disconnect to OnConfirmDisconnect instead of doing it
+// immediately, so that the app can ask the user first. It has no
+// effect if OnConfirmDisconnect is nil. The exit node toggle is
+// unaffected.
+func WithConfirmDisconnect(confirm bool) Option {
+	return func(o *options) {
+		o.confirmDisconnects = confirm
+	}
+}
+
+// WithPanicItem sets whether the menu has an item that immediately
+// locks the local node down by calling OnPanic, which is expected to
+// disconnect and enable shields up. It has no effect if OnPanic is
+// nil. Unlike disconnecting, it is never confirmed first. It is
+// disabled by default.
+func WithPanicItem(show bool) Option {
+	return func(o *options) {
+		o.panicButton = show
+	}
+}
+
+// WithAboutItem sets whether the menu has an "About Trayscale" item
+// next to Quit that calls OnAbout. It has no effect if OnAbout is nil.
+// It is disabled by default so that apps that already offer an about
+// dialog elsewhere don't end up with two.
+func WithAboutItem(show bool) Option {
+	return func(o *options) {
+		o.aboutItem = show
+	}
+}
+
+// WithVersion sets the version of the app that the about item
+// reports, such as the one from metadata.Version. If it isn't set, the
+// about item leaves the version out.
+func WithVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// WithMinDaemonVersion sets the oldest version of the Tailscale daemon,
+// such as "1.80.0", that the app supports. If the daemon is older, the
+// menu warns about it and offers instructions for upgrading. There is
+// no minimum by default.
+func WithMinDaemonVersion(version string) Option {
+	return func(o *options) {
+		o.minDaemonVersion = version
+	}
+}
+
+// WithPollInterval makes the tray call fn to get the status itself
+// whenever Update hasn't been called for at least d, so that the menu
+// doesn't go stale if updates stop arriving. A nil status returned by
+// fn is ignored. It is disabled by default.
+func WithPollInterval(d time.Duration, fn func() tsutil.Status) Option {
+	return func(o *options) {
+		o.pollInterval = d
+		o.pollStatus = fn
+	}
+}
+
+// WithConnectivityCheck makes the tray ping target every interval and
+// show the result of the most recent check in the menu, giving
+// always-on machines a visible heartbeat. target is a peer or DERP
+// relay as accepted by [tsutil.Ping]. Each result is also passed to
+// OnConnectivityResult. The checks run while the menu is shown and
+// are stopped by Close. It is disabled by default.
+func WithConnectivityCheck(target string, interval time.Duration) Option {
+	return func(o *options) {
+		o.connCheckTarget = target
+		o.connCheckInterval = interval
+	}
+}
+
+// WithHealthEndpoint makes the tray serve a JSON summary of the
+// current status over HTTP at addr, such as "localhost:9191", so that
+// it can be monitored. Only loopback addresses are allowed. The server
+// runs while the menu is shown and is stopped by Close. It is disabled
+// by default.
+func WithHealthEndpoint(addr string) Option {
+	return func(o *options) {
+		o.healthAddr = addr
+	}
+}
+
+// WithStateFile sets a file that the tray saves its icon to so that
+// the next session can show the same icon until the backend has
+// finished starting, instead of briefly showing a stale one. Only the
+// icon is saved. The menu's items are always built from the current
+// status, so they may still change once the backend has started. The
+// saved icon is ignored if the active profile has changed since it
+// was saved. It is disabled by default.
+func WithStateFile(path string) Option {
+	return func(o *options) {
+		o.stateFile = path
+	}
+}
+
+// WithItemOrder sets the order in which the standard items appear in
+// the menu. Unknown IDs are ignored and any items that are left out
+// are placed after the others in their default order.
+func WithItemOrder(order ...MenuItemID) Option {
+	return func(o *options) {
+		o.itemOrder = itemOrder(order)
+	}
+}
+
+// WithExitNodeLANChoice sets whether each exit node in the exit nodes
+// submenu has a submenu of its own that offers using it either with
+// or without local network access. By default, clicking an exit node
+// selects it with the default from the "Local network access"
+// checkbox. See [Tray.SetDefaultLANAccess].
+func WithExitNodeLANChoice(choice bool) Option {
+	return func(o *options) {
+		o.exitNodeLANChoice = choice
+	}
+}
@@ -0,0 +1,120 @@
+package tray
+
+import (
+	"log/slog"
+
+	"tailscale.com/util/set"
+)
+
+// A MenuItemID identifies one of the standard items in the tray's
+// menu for use with [WithItemOrder]. The Show and Quit items are
+// always first and last respectively and can't be reordered.
+type MenuItemID string
+
+const (
+	ItemConnection    MenuItemID = "connection"
+	ItemService       MenuItemID = "service"
+	ItemOperator      MenuItemID = "operator"
+	ItemPanic         MenuItemID = "panic"
+	ItemLogin         MenuItemID = "login"
+	ItemClockSkew     MenuItemID = "clock-skew"
+	ItemCaptive       MenuItemID = "captive-portal"
+	ItemRouteConflict MenuItemID = "route-conflict"
+	ItemDaemonVersion MenuItemID = "daemon-version"
+	ItemExitNode      MenuItemID = "exit-node"
+	ItemTunnel        MenuItemID = "tunnel"
+	ItemExitRouting   MenuItemID = "exit-routing"
+	ItemServing       MenuItemID = "serving"
+	ItemRoutes        MenuItemID = "advertised-routes"
+	ItemIncoming      MenuItemID = "incoming"
+	ItemTailnetLock   MenuItemID = "tailnet-lock"
+	ItemLockSign      MenuItemID = "tailnet-lock-sign"
+	ItemIdentity      MenuItemID = "identity"
+	ItemMagicDNS      MenuItemID = "magic-dns"
+	ItemDERP          MenuItemID = "derp"
+	ItemQuality       MenuItemID = "quality"
+	ItemConnCheck     MenuItemID = "connectivity-check"
+	ItemIPFamilies    MenuItemID = "ip-families"
+	ItemThroughput    MenuItemID = "throughput"
+	ItemUpdate        MenuItemID = "update"
+	ItemSuggestedExit MenuItemID = "suggested-exit"
+	ItemExitNodes     MenuItemID = "exit-nodes"
+	ItemSelf          MenuItemID = "self"
+	ItemPeers         MenuItemID = "peers"
+	ItemTaildrop      MenuItemID = "taildrop"
+	ItemStatusReport  MenuItemID = "status-report"
+	ItemDNSSuffix     MenuItemID = "dns-suffix"
+	ItemAdminConsole  MenuItemID = "admin-console"
+)
+
+// defaultItemOrder is the order of the standard items unless
+// otherwise configured. It contains every MenuItemID.
+var defaultItemOrder = []MenuItemID{
+	ItemConnection,
+	ItemService,
+	ItemOperator,
+	ItemPanic,
+	ItemLogin,
+	ItemClockSkew,
+	ItemCaptive,
+	ItemRouteConflict,
+	ItemDaemonVersion,
+	ItemExitNode,
+	ItemTunnel,
+	ItemExitRouting,
+	ItemServing,
+	ItemRoutes,
+	ItemIncoming,
+	ItemTailnetLock,
+	ItemLockSign,
+	ItemIdentity,
+	ItemMagicDNS,
+	ItemDERP,
+	ItemQuality,
+	ItemConnCheck,
+	ItemIPFamilies,
+	ItemThroughput,
+	ItemUpdate,
+	ItemSuggestedExit,
+	ItemExitNodes,
+	ItemSelf,
+	ItemPeers,
+	ItemTaildrop,
+	ItemStatusReport,
+	ItemDNSSuffix,
+	ItemAdminConsole,
+}
+
+// compactItems are the standard items that are included in compact
+// mode.
+var compactItems = set.Of(ItemConnection, ItemExitNode)
+
+// itemOrder returns a complete ordering of the standard items that
+// follows order as closely as possible. Unknown and repeated IDs in
+// order are ignored and any items that it leaves out are placed after
+// the rest in their default order.
+func itemOrder(order []MenuItemID) []MenuItemID {
+	known := set.Of(defaultItemOrder...)
+	seen := make(set.Set[MenuItemID], len(defaultItemOrder))
+	result := make([]MenuItemID, 0, len(defaultItemOrder))
+
+	add := func(id MenuItemID) {
+		if seen.Contains(id) {
+			return
+		}
+		seen.Add(id)
+		result = append(result, id)
+	}
+
+	for _, id := range order {
+		if !known.Contains(id) {
+			slog.Warn("ignoring unknown tray item", "id", id)
+			continue
+		}
+		add(id)
+	}
+	for _, id := range defaultItemOrder {
+		add(id)
+	}
+	return result
+}
@@ -0,0 +1,16 @@
+package tray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemOrder(t *testing.T) {
+	require.Equal(t, defaultItemOrder, itemOrder(nil))
+
+	order := itemOrder([]MenuItemID{ItemSelf, "unknown", ItemExitNode, ItemSelf})
+	require.Len(t, order, len(defaultItemOrder))
+	require.Equal(t, []MenuItemID{ItemSelf, ItemExitNode, ItemConnection, ItemService}, order[:4])
+	require.ElementsMatch(t, defaultItemOrder, order)
+}
@@ -0,0 +1,196 @@
+package tray
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unique"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"deedles.dev/xiter"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/set"
+)
+
+var (
+	peersHandle        = unique.Make("peers")
+	exitNodeListHandle = unique.Make("exitNodes")
+	exitLANHandle      = unique.Make("exitNodeLANDefault")
+)
+
+func peerHandle(id tailcfg.StableNodeID) unique.Handle[string] {
+	return unique.Make("peer:" + string(id))
+}
+
+func exitNodeItemHandle(id tailcfg.StableNodeID) unique.Handle[string] {
+	return unique.Make("exitNode:" + string(id))
+}
+
+// menuPeers returns the peers that should be listed in the peers
+// submenu in the order that they should be listed in. Pinned peers
+// come first. Mullvad exit nodes are left out as there tend to be a
+// lot of them.
+func menuPeers(status *tsutil.IPNStatus, pinned set.Set[tailcfg.StableNodeID]) []tailcfg.NodeView {
+	if !status.Online() {
+		return nil
+	}
+
+	peers := xiter.Filter(maps.Values(status.Peers), func(peer tailcfg.NodeView) bool {
+		return !tsutil.IsMullvad(peer)
+	})
+	return slices.SortedFunc(peers, func(p1, p2 tailcfg.NodeView) int {
+		pin1, pin2 := pinned.Contains(p1.StableID()), pinned.Contains(p2.StableID())
+		if pin1 != pin2 {
+			if pin1 {
+				return -1
+			}
+			return 1
+		}
+		return tsutil.ComparePeers(p1, p2)
+	})
+}
+
+// exitNodePeers returns the peers that should be listed directly in
+// the exit nodes submenu in the order that they should be listed in.
+// Mullvad exit nodes with location data are left out as they're
+// grouped by location instead.
+func exitNodePeers(status *tsutil.IPNStatus) []tailcfg.NodeView {
+	if !status.Online() {
+		return nil
+	}
+
+	peers := xiter.Filter(maps.Values(status.Peers), func(peer tailcfg.NodeView) bool {
+		if tsutil.IsMullvad(peer) && tsutil.HasLocation(peer) {
+			return false
+		}
+		return status.PeerCaps(peer.StableID())&tsutil.PeerExitNode != 0
+	})
+	return slices.SortedFunc(peers, tsutil.ComparePeers)
+}
+
+// exitLANText returns the label for the item that toggles whether
+// exit nodes selected from the exit nodes submenu allow local network
+// access.
+func exitLANText(allow bool) string {
+	if allow {
+		return "Local network access: on"
+	}
+	return "Local network access: off"
+}
+
+// exitNodeLocationKeys returns the countries, cities, and node IDs of
+// countries in order as a slice suitable for passing to dirty.
+func exitNodeLocationKeys(countries []tsutil.ExitNodeCountry) []any {
+	var keys []any
+	for _, country := range countries {
+		keys = append(keys, country.Name)
+		for _, city := range country.Cities {
+			keys = append(keys, city.Name)
+			keys = append(keys, peerIDs(city.Nodes)...)
+		}
+	}
+	return keys
+}
+
+// exitNodesIn returns peers followed by every node in countries.
+func exitNodesIn(peers []tailcfg.NodeView, countries []tsutil.ExitNodeCountry) []tailcfg.NodeView {
+	nodes := slices.Clone(peers)
+	for _, country := range countries {
+		for _, city := range country.Cities {
+			nodes = append(nodes, city.Nodes...)
+		}
+	}
+	return nodes
+}
+
+// countryText returns the label for a country in the exit nodes
+// submenu, such as "🇨🇦 Canada".
+func countryText(country tsutil.ExitNodeCountry) string {
+	if flag := flagEmoji(country.Code); flag != "" {
+		return fmt.Sprintf("%v %v", flag, country.Name)
+	}
+	return country.Name
+}
+
+// cityText returns the label for a city in the exit nodes submenu.
+func cityText(city tsutil.ExitNodeCity) string {
+	if city.Name == "" {
+		return "Other"
+	}
+	return city.Name
+}
+
+// exitNodeText returns the label for an exit node's item in the exit
+// nodes submenu, noting whether it's the one in use and, if so,
+// whether local network access is allowed and whether it was selected
+// automatically, which it was if it is auto, followed by its
+// advertised priority, if any, such as
+// "us-nyc-1 (in use, LAN on, auto, priority 100)".
+func exitNodeText(status *tsutil.IPNStatus, peer tailcfg.NodeView, maxName int, auto tailcfg.StableNodeID) string {
+	var notes []string
+	if status.Prefs.ExitNodeID() == peer.StableID() {
+		notes = append(notes, "in use")
+		if status.Prefs.ExitNodeAllowLANAccess() {
+			notes = append(notes, "LAN on")
+		}
+		if (auto != "") && (peer.StableID() == auto) {
+			notes = append(notes, "auto")
+		}
+	}
+	if priority, ok := status.ExitNodePriority(peer.StableID()); ok {
+		notes = append(notes, fmt.Sprintf("priority %v", priority))
+	}
+
+	name := ellipsize(peer.DisplayName(true), maxName)
+	if len(notes) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v (%v)", name, strings.Join(notes, ", "))
+}
+
+// peerIDs returns the IDs of peers as a slice suitable for passing to
+// dirty.
+func peerIDs(peers []tailcfg.NodeView) []any {
+	ids := make([]any, 0, len(peers))
+	for _, peer := range peers {
+		ids = append(ids, peer.StableID())
+	}
+	return ids
+}
+
+// peerLabel returns the label for a peer's menu item, annotated with
+// its capabilities, such as "laptop [SSH]". The peer's name is
+// ellipsized to maxName characters.
+func peerLabel(peer tailcfg.NodeView, caps tsutil.PeerCaps, maxName int) string {
+	name := ellipsize(peer.DisplayName(true), maxName)
+	if caps == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v [%v]", name, caps)
+}
+
+// pinText returns the label for the item that pins or unpins a peer.
+func pinText(pinned bool) string {
+	if pinned {
+		return "Unpin"
+	}
+	return "Pin to top"
+}
+
+// peerTooltip returns the tooltip for a peer's menu item. It shows the
+// peer's full label along with whether it is online or, if it isn't,
+// roughly how long ago it was last seen as of now.
+func peerTooltip(status *tsutil.IPNStatus, peer tailcfg.NodeView, caps tsutil.PeerCaps, now time.Time, f formatter) string {
+	label := peerLabel(peer, caps, 0)
+	if peer.Online().Get() {
+		return fmt.Sprintf("%v (online)", label)
+	}
+
+	lastSeen, ok := status.PeerLastSeen(peer.StableID())
+	if !ok {
+		return fmt.Sprintf("%v (offline)", label)
+	}
+	return fmt.Sprintf("%v (last seen %v)", label, f.since(now.Sub(lastSeen)))
+}
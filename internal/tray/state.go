@@ -0,0 +1,118 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/ipn"
+)
+
+// savedState is what is persisted to the state file between sessions.
+// It only covers the icon, which is the one part of the tray that is
+// visible before the menu is opened. See [WithStateFile].
+type savedState struct {
+	Profile ipn.ProfileID `json:"profile"`
+	Icon    string        `json:"icon"`
+}
+
+// settling returns true if status looks like it was taken while the
+// backend was still starting up, before it had a network map, and so
+// is likely to be superseded shortly.
+func settling(status *tsutil.IPNStatus) bool {
+	if !status.DaemonReachable() || (status.NetMap != nil) {
+		return false
+	}
+	switch status.State {
+	case ipn.NoState, ipn.Starting, ipn.Running:
+		return true
+	default:
+		return false
+	}
+}
+
+// iconName returns the name of ic in statusIcons, or an empty string
+// if it isn't one of them, such as if it was generated.
+func iconName(ic *icon) string {
+	for name, other := range statusIcons {
+		if other == ic {
+			return name
+		}
+	}
+	return ""
+}
+
+// loadState reads the state file, if there is one, to seed the first
+// icon that is shown. It only does so once per tray. It must be called
+// with t.m held.
+func (t *trayImpl) loadState() {
+	if (t.stateFile == "") || t.stateLoaded {
+		return
+	}
+	t.stateLoaded = true
+
+	data, err := os.ReadFile(t.stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("read tray state", "path", t.stateFile, "err", err)
+		}
+		return
+	}
+
+	var state savedState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		slog.Warn("decode tray state", "path", t.stateFile, "err", err)
+		return
+	}
+	if _, ok := statusIcons[state.Icon]; !ok || (state.Profile == "") {
+		return
+	}
+	t.saved = state
+	t.seed = &state
+}
+
+// seedIcon returns the icon from the previous session if status is
+// still settling and is from the same profile. Once it isn't, the
+// seed is discarded for good. It returns nil if there is no seed.
+func (t *trayImpl) seedIcon(status *tsutil.IPNStatus) *icon {
+	if t.seed == nil {
+		return nil
+	}
+	if !settling(status) || ((t.profile != "") && (t.profile != t.seed.Profile)) {
+		t.seed = nil
+		return nil
+	}
+	return statusIcons[t.seed.Icon]
+}
+
+// saveState writes ic and the current profile to the state file if
+// they differ from what it already contains.
+func (t *trayImpl) saveState(ic *icon) {
+	if (t.stateFile == "") || (t.profile == "") {
+		return
+	}
+	state := savedState{Profile: t.profile, Icon: iconName(ic)}
+	if (state.Icon == "") || (state == t.saved) {
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("encode tray state", "err", err)
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(t.stateFile), 0o700)
+	if err == nil {
+		err = os.WriteFile(t.stateFile, data, 0o600)
+	}
+	if err != nil {
+		slog.Warn("write tray state", "path", t.stateFile, "err", err)
+		return
+	}
+	t.saved = state
+}
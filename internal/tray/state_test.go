@@ -0,0 +1,60 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "tray.json")
+	writeState := func(state savedState) {
+		data, err := json.Marshal(state)
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+	}
+	readState := func() (state savedState) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &state))
+		return state
+	}
+
+	starting := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()}
+	running := &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View(), NetMap: &netmap.NetworkMap{}}
+
+	tr := New(Callbacks{}, WithStateFile(path)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, starting)
+	require.Same(t, statusIconActive, tr.icon)
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "a"}})
+	tr.Update(running)
+	require.Equal(t, savedState{Profile: "a", Icon: "active"}, readState())
+
+	writeState(savedState{Profile: "a", Icon: "exit node"})
+	tr = New(Callbacks{}, WithStateFile(path)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, starting)
+	require.Same(t, statusIconExitNode, tr.icon)
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "a"}})
+	require.Same(t, statusIconExitNode, tr.icon)
+	tr.Update(running)
+	require.Same(t, statusIconActive, tr.icon)
+	tr.Update(starting)
+	require.Same(t, statusIconActive, tr.icon)
+
+	writeState(savedState{Profile: "a", Icon: "exit node"})
+	tr = New(Callbacks{}, WithStateFile(path)).(*trayImpl)
+	tr.build(&fakeMenuHost{}, starting)
+	require.Same(t, statusIconExitNode, tr.icon)
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "b"}})
+	require.Same(t, statusIconActive, tr.icon)
+	require.Equal(t, savedState{Profile: "b", Icon: "active"}, readState())
+}
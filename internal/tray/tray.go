@@ -3,43 +3,188 @@
 package tray
 
 import (
-	"bytes"
-	_ "embed"
 	"fmt"
-	"image/png"
-	"slices"
-	"sync"
-	"unique"
+	"log/slog"
 
 	"deedles.dev/tray"
 	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/godbus/dbus/v5"
 )
 
-var (
-	//go:embed status-icon-active.png
-	statusIconActiveData []byte
-	statusIconActive     = decode(statusIconActiveData)
+// trayHostWatchers are the names that a StatusNotifierWatcher can own
+// on the session bus.
+var trayHostWatchers = []string{
+	"org.kde.StatusNotifierWatcher",
+	"org.freedesktop.StatusNotifierWatcher",
+}
 
-	//go:embed status-icon-inactive.png
-	statusIconInactiveData []byte
-	statusIconInactive     = decode(statusIconInactiveData)
+// hasTrayHost returns true if a StatusNotifierWatcher is running on
+// the session bus. It is a variable so that tests can simulate a
+// missing watcher.
+var hasTrayHost = func() (bool, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return false, fmt.Errorf("connect to session bus: %w", err)
+	}
 
-	//go:embed status-icon-exit-node.png
-	statusIconExitNodeData []byte
-	statusIconExitNode     = decode(statusIconExitNodeData)
+	for _, name := range trayHostWatchers {
+		var ok bool
+		err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&ok)
+		if err != nil {
+			return false, fmt.Errorf("check owner of %v: %w", name, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	selfHandle       = unique.Make("self")
-	connToggleHandle = unique.Make("connToggle")
-	exitToggleHandle = unique.Make("exitToggle")
-	statusIconHandle = unique.Make("statusIcon")
-)
+// watchResume calls f each time that logind reports that the system
+// has woken from sleep until the returned function is called. It is a
+// variable so that tests can avoid the system bus.
+var watchResume = func(f func()) (stop func(), err error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect to system bus: %w", err)
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath("/org/freedesktop/login1"),
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	}
+	err = conn.AddMatchSignal(match...)
+	if err != nil {
+		return nil, fmt.Errorf("watch for PrepareForSleep: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if sig.Name != "org.freedesktop.login1.Manager.PrepareForSleep" || len(sig.Body) == 0 {
+					continue
+				}
+				if sleeping, ok := sig.Body[0].(bool); ok && !sleeping {
+					f()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		conn.RemoveSignal(signals)
+		conn.RemoveMatchSignal(match...)
+		close(done)
+	}, nil
+}
+
+// networkManager is the bus name and object path of NetworkManager.
+const networkManager = "org.freedesktop.NetworkManager"
+
+// watchNetwork calls f with the SSID of the Wi-Fi network that
+// NetworkManager considers the primary connection, or an empty string
+// if the primary connection isn't Wi-Fi, once immediately and then
+// each time that it changes until the returned function is called. It
+// is a variable so that tests can avoid the system bus.
+var watchNetwork = func(f func(ssid string)) (stop func(), err error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect to system bus: %w", err)
+	}
+
+	prev, err := primarySSID(conn)
+	if err != nil {
+		return nil, fmt.Errorf("get primary connection: %w", err)
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath("/org/freedesktop/NetworkManager"),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	}
+	err = conn.AddMatchSignal(match...)
+	if err != nil {
+		return nil, fmt.Errorf("watch for PropertiesChanged: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+
+	done := make(chan struct{})
+	go func() {
+		f(prev)
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if (sig.Path != "/org/freedesktop/NetworkManager") || (sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged") {
+					continue
+				}
+				ssid, err := primarySSID(conn)
+				if err != nil {
+					slog.Warn("get primary connection", "err", err)
+					continue
+				}
+				if ssid != prev {
+					prev = ssid
+					f(ssid)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		conn.RemoveSignal(signals)
+		conn.RemoveMatchSignal(match...)
+		close(done)
+	}, nil
+}
+
+// primarySSID returns the SSID of NetworkManager's primary connection,
+// or an empty string if there is none or it isn't Wi-Fi.
+func primarySSID(conn *dbus.Conn) (string, error) {
+	v, err := conn.Object(networkManager, "/org/freedesktop/NetworkManager").GetProperty(networkManager + ".PrimaryConnection")
+	if err != nil {
+		return "", err
+	}
+	path, ok := v.Value().(dbus.ObjectPath)
+	if !ok || (path == "/") {
+		return "", nil
+	}
+
+	active := conn.Object(networkManager, path)
+	v, err = active.GetProperty(networkManager + ".Connection.Active.Type")
+	if err != nil {
+		return "", err
+	}
+	if typ, _ := v.Value().(string); typ != "802-11-wireless" {
+		return "", nil
+	}
+
+	v, err = active.GetProperty(networkManager + ".Connection.Active.SpecificObject")
+	if err != nil {
+		return "", err
+	}
+	ap, ok := v.Value().(dbus.ObjectPath)
+	if !ok || (ap == "/") {
+		return "", nil
+	}
 
-func decode(data []byte) tray.Pixmap {
-	img, err := png.Decode(bytes.NewReader(data))
+	v, err = conn.Object(networkManager, ap).GetProperty(networkManager + ".AccessPoint.Ssid")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return tray.ToPixmap(img)
+	ssid, _ := v.Value().([]byte)
+	return string(ssid), nil
 }
 
 func handler(f func()) tray.MenuItemProp {
@@ -49,183 +194,220 @@ func handler(f func()) tray.MenuItemProp {
 	}))
 }
 
-type trayImpl struct {
-	Callbacks
+// platform holds the Linux-specific state of a trayImpl.
+type platform struct {
+	item        *tray.Item
+	stopResume  func()
+	stopNetwork func()
+
+	usesTemplateIcons  bool
+	usesAccessoryLabel bool
+}
 
-	m    sync.Mutex
+func newPlatform() platform {
+	return platform{}
+}
+
+// dbusHost is a menuHost backed by a StatusNotifierItem.
+type dbusHost struct {
 	item *tray.Item
-	prev map[unique.Handle[string]][]any
 
-	showItem       *tray.MenuItem
-	connToggleItem *tray.MenuItem
-	exitToggleItem *tray.MenuItem
-	selfNodeItem   *tray.MenuItem
-	quitItem       *tray.MenuItem
+	// children are the top-level items of the menu, including
+	// separators, so that they can be removed by ResetMenu.
+	children []*tray.MenuItem
 }
 
-// New creates a new tray for the current platform
-func New(cb Callbacks) Tray {
-	return &trayImpl{Callbacks: cb}
+func (h *dbusHost) AddMenuItem(title, tooltip string) menuItem {
+	item, _ := h.item.Menu().AddChild(tray.MenuItemLabel(title))
+	h.children = append(h.children, item)
+	return dbusItem{item}
 }
 
-func (t *trayImpl) Start(status *tsutil.IPNStatus) error {
-	if t.item != nil {
-		return nil
-	}
+// AddMenuItemCheckbox adds an item that would be a checkbox on other
+// platforms. The item's label already describes its state, so no
+// checkmark is shown on Linux.
+func (h *dbusHost) AddMenuItemCheckbox(title, tooltip string, checked bool) menuItem {
+	return h.AddMenuItem(title, tooltip)
+}
 
-	t.m.Lock()
-	defer t.m.Unlock()
+func (h *dbusHost) AddSeparator() {
+	item, _ := h.item.Menu().AddChild(tray.MenuItemType(tray.Separator))
+	h.children = append(h.children, item)
+}
 
-	item, err := tray.New(
-		tray.ItemID("dev.deedles.Trayscale"),
-		tray.ItemTitle("Trayscale"),
-		tray.ItemHandler(tray.ActivateHandler(func(x, y int) error {
-			t.OnShow()
-			return nil
-		})),
-	)
-	if err != nil {
-		return err
+func (h *dbusHost) ResetMenu() {
+	for _, item := range h.children {
+		item.Remove()
 	}
-	t.item = item
-	t.prev = make(map[unique.Handle[string]][]any)
+	h.children = nil
+}
 
-	menu := item.Menu()
+// SetIcon sets the icon at several sizes so that the tray host can
+// choose the one that best fits its panel instead of scaling a single
+// large image itself.
+func (h *dbusHost) SetIcon(ic *icon) {
+	h.item.SetProps(tray.ItemIconPixmap(ic.pixmaps()...))
+}
 
-	t.showItem, _ = menu.AddChild(tray.MenuItemLabel("Show"), handler(t.OnShow))
-	menu.AddChild(tray.MenuItemType(tray.Separator))
-	t.connToggleItem, _ = menu.AddChild(handler(t.OnConnToggle))
-	t.exitToggleItem, _ = menu.AddChild(handler(t.OnExitToggle))
-	t.selfNodeItem, _ = menu.AddChild(handler(t.OnSelfNode))
-	menu.AddChild(tray.MenuItemType(tray.Separator))
-	t.quitItem, _ = menu.AddChild(tray.MenuItemLabel("Quit"), handler(t.OnQuit))
+// SetTemplateIcon sets a regular icon as StatusNotifierItems don't
+// support template icons.
+func (h *dbusHost) SetTemplateIcon(ic *icon) {
+	h.SetIcon(ic)
+}
 
-	t.update(status)
+// Batch just calls f. dbusmenu emits a layout update for each change
+// and the library doesn't provide a way to hold them back.
+func (h *dbusHost) Batch(f func()) {
+	f()
+}
 
-	return nil
+func (h *dbusHost) SetTitle(title string) {
+	h.item.SetProps(tray.ItemTitle(title))
 }
 
-func (t *trayImpl) Close() error {
-	if t == nil {
-		return nil
-	}
+func (h *dbusHost) SetTooltip(tooltip string) {
+	h.item.SetProps(tray.ItemToolTip("", nil, "Trayscale", tooltip))
+}
 
-	t.m.Lock()
-	defer t.m.Unlock()
+// dbusItem is a menuItem backed by a dbusmenu item.
+type dbusItem struct {
+	item *tray.MenuItem
+}
 
-	if t.item == nil {
-		return nil
-	}
+func (i dbusItem) OnClick(f func()) {
+	i.item.SetProps(handler(f))
+}
 
-	err := t.item.Close()
-	t.item = nil
-	t.prev = nil
-	return err
+func (i dbusItem) SetTitle(title string) {
+	i.item.SetProps(tray.MenuItemLabel(title))
 }
 
-// HideDock is a no-op on Linux
-func (t *trayImpl) HideDock() {}
+// SetTooltip is a no-op as dbusmenu items don't have tooltips.
+func (i dbusItem) SetTooltip(tooltip string) {}
 
-// ShowDock is a no-op on Linux
-func (t *trayImpl) ShowDock() {}
+func (i dbusItem) Enable() {
+	i.item.SetProps(tray.MenuItemEnabled(true))
+}
 
-func (t *trayImpl) Update(s tsutil.Status) {
-	if t == nil {
-		return
-	}
+func (i dbusItem) Disable() {
+	i.item.SetProps(tray.MenuItemEnabled(false))
+}
 
-	status, ok := s.(*tsutil.IPNStatus)
-	if !ok {
-		return
-	}
+func (i dbusItem) Enabled() bool {
+	return i.item.Enabled()
+}
 
-	t.m.Lock()
-	defer t.m.Unlock()
+// Check is a no-op. See [dbusHost.AddMenuItemCheckbox].
+func (i dbusItem) Check() {}
 
-	t.update(status)
-}
+// Uncheck is a no-op. See [dbusHost.AddMenuItemCheckbox].
+func (i dbusItem) Uncheck() {}
 
-func (t *trayImpl) dirty(key unique.Handle[string], vals ...any) bool {
-	prev := t.prev[key]
-	if slices.Equal(vals, prev) {
-		return false
-	}
+func (i dbusItem) Show() {
+	i.item.SetProps(tray.MenuItemVisible(true))
+}
 
-	t.prev[key] = vals
-	return true
+func (i dbusItem) Hide() {
+	i.item.SetProps(tray.MenuItemVisible(false))
 }
 
-func (t *trayImpl) update(status *tsutil.IPNStatus) {
-	if t.item == nil {
-		return
-	}
+func (i dbusItem) AddSubMenuItem(title, tooltip string) menuItem {
+	item, _ := i.item.AddChild(tray.MenuItemLabel(title))
+	return dbusItem{item}
+}
 
-	selfTitle, connected := selfTitle(status)
-	connToggleLabel := connToggleText(status.Online())
-	exitToggleLabel := exitToggleText(status)
+func (i dbusItem) Remove() {
+	i.item.Remove()
+}
 
-	t.updateStatusIcon(status)
+func (t *trayImpl) Start(status *tsutil.IPNStatus) error {
+	t.m.Lock()
+	defer t.m.Unlock()
 
-	if t.dirty(selfHandle, selfTitle, connected) {
-		t.selfNodeItem.SetProps(
-			tray.MenuItemLabel(fmt.Sprintf("This machine: %v", selfTitle)),
-			tray.MenuItemEnabled(connected),
-		)
+	if t.item != nil {
+		return nil
 	}
 
-	if t.dirty(connToggleHandle, connToggleLabel) {
-		t.connToggleItem.SetProps(tray.MenuItemLabel(connToggleLabel))
+	ok, err := hasTrayHost()
+	if err != nil {
+		slog.Warn("check for tray host", "err", err)
+	}
+	if (err == nil) && !ok {
+		return ErrNoTrayHost
 	}
 
-	if t.dirty(exitToggleHandle, exitToggleLabel, connected) {
-		t.exitToggleItem.SetProps(
-			tray.MenuItemLabel(exitToggleLabel),
-			tray.MenuItemEnabled(connected),
-		)
+	item, err := tray.New(
+		tray.ItemID("dev.deedles.Trayscale"),
+		tray.ItemTitle("Trayscale"),
+		tray.ItemIsMenu(!t.leftClickShows),
+		tray.ItemHandler(tray.ActivateHandler(func(x, y int) error {
+			if t.leftClickShows {
+				t.show()
+			}
+			return nil
+		})),
+	)
+	if err != nil {
+		return err
 	}
-}
+	t.item = item
+	t.closed = false
 
-func (t *trayImpl) updateStatusIcon(status *tsutil.IPNStatus) {
-	newIcon := statusIcon(status)
-	if !t.dirty(statusIconHandle, newIcon) {
-		return
+	stop, err := watchResume(func() { go t.resumed() })
+	if err != nil {
+		slog.Warn("watch for resume", "err", err)
+	}
+	t.stopResume = stop
+
+	if t.OnNetworkChanged != nil {
+		stop, err := watchNetwork(t.OnNetworkChanged)
+		if err != nil {
+			slog.Warn("watch for network changes", "err", err)
+		}
+		t.stopNetwork = stop
 	}
 
-	t.item.SetProps(tray.ItemIconPixmap(newIcon))
+	t.build(&dbusHost{item: item}, status)
+	return nil
 }
 
-func statusIcon(status *tsutil.IPNStatus) *tray.Pixmap {
-	if !status.Online() {
-		return &statusIconInactive
+func (t *trayImpl) Close() error {
+	if t == nil {
+		return nil
 	}
-	if status.ExitNodeActive() {
-		return &statusIconExitNode
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.quitDone()
+	t.closed = true
+	if t.item == nil {
+		return nil
 	}
-	return &statusIconActive
-}
 
-func selfTitle(status *tsutil.IPNStatus) (string, bool) {
-	addr := status.SelfAddr()
-	if !addr.IsValid() {
-		return "Not connected", false
+	if t.stopResume != nil {
+		t.stopResume()
+		t.stopResume = nil
+	}
+	if t.stopNetwork != nil {
+		t.stopNetwork()
+		t.stopNetwork = nil
 	}
 
-	return fmt.Sprintf("%v (%v)", status.NetMap.SelfNode.DisplayName(true), addr), true
+	err := t.item.Close()
+	t.item = nil
+	t.reset()
+	return err
 }
 
-func connToggleText(online bool) string {
-	if online {
-		return "Disconnect"
-	}
-
-	return "Connect"
+// showHint returns the hint passed to OnShowWithHint. Linux needs no
+// activation before the window is raised.
+func (t *trayImpl) showHint() ShowHint {
+	return ShowHint{}
 }
 
-func exitToggleText(status *tsutil.IPNStatus) string {
-	if status.ExitNodeActive() {
-		// TODO: Show some actual information about the current exit node?
-		return "Disable exit node"
-	}
+// HideDock is a no-op on Linux
+func (t *trayImpl) HideDock() {}
 
-	return "Enable exit node"
-}
+// ShowDock is a no-op on Linux
+func (t *trayImpl) ShowDock() {}
@@ -5,144 +5,162 @@ package tray
 /*
 #cgo CFLAGS: -x objective-c
 #cgo LDFLAGS: -framework Cocoa
-#import <Cocoa/Cocoa.h>
 
-void HideDock(void) {
-    [NSApp setActivationPolicy:NSApplicationActivationPolicyAccessory];
-}
-
-void ShowDock(void) {
-    [NSApp setActivationPolicy:NSApplicationActivationPolicyRegular];
-    [NSApp activateIgnoringOtherApps:YES];
-}
+void HideDock(void);
+void ShowDock(void);
+void WatchWake(void);
 */
 import "C"
 
 import (
-	"bytes"
-	_ "embed"
-	"fmt"
 	"log/slog"
-	"slices"
-	"sync"
-	"unique"
+	"sync/atomic"
 
 	"deedles.dev/trayscale/internal/tsutil"
 	"fyne.io/systray"
 )
 
-var (
-	//go:embed status-icon-active-template.png
-	statusIconActiveData []byte
+// platform holds the macOS-specific state of a trayImpl.
+type platform struct {
+	appStart func()
+	appClose func()
 
-	//go:embed status-icon-inactive-template.png
-	statusIconInactiveData []byte
+	usesTemplateIcons  bool
+	usesAccessoryLabel bool
+}
 
-	//go:embed status-icon-exit-node-template.png
-	statusIconExitNodeData []byte
+func newPlatform() platform {
+	return platform{usesTemplateIcons: true, usesAccessoryLabel: true}
+}
 
-	selfHandle       = unique.Make("self")
-	connToggleHandle = unique.Make("connToggle")
-	exitToggleHandle = unique.Make("exitToggle")
-	statusIconHandle = unique.Make("statusIcon")
-)
+// onWake is called by trayDidWake. macOS only ever has a single tray,
+// so there is no need to track more than one.
+var onWake atomic.Pointer[func()]
 
-type trayImpl struct {
-	Callbacks
+//export trayDidWake
+func trayDidWake() {
+	if f := onWake.Load(); f != nil {
+		go (*f)()
+	}
+}
+
+// systrayHost is a menuHost backed by systray.
+type systrayHost struct{}
 
-	m         sync.Mutex
-	prev      map[unique.Handle[string]][]any
-	prevBytes map[unique.Handle[string]][][]byte
+func (systrayHost) AddMenuItem(title, tooltip string) menuItem {
+	return systrayItem{systray.AddMenuItem(title, tooltip)}
+}
 
-	appStart  func()
-	appClose  func()
-	trayReady bool
+func (systrayHost) AddMenuItemCheckbox(title, tooltip string, checked bool) menuItem {
+	return systrayItem{systray.AddMenuItemCheckbox(title, tooltip, checked)}
+}
 
-	showItem       *systray.MenuItem
-	connToggleItem *systray.MenuItem
-	exitToggleItem *systray.MenuItem
-	selfNodeItem   *systray.MenuItem
-	quitItem       *systray.MenuItem
+func (systrayHost) AddSeparator() {
+	systray.AddSeparator()
 }
 
-// New creates a new tray for the current platform
-func New(cb Callbacks) Tray {
-	return &trayImpl{Callbacks: cb}
+func (systrayHost) ResetMenu() {
+	systray.ResetMenu()
 }
 
-func (t *trayImpl) Start(status *tsutil.IPNStatus) error {
-	t.trayReady = false
+func (systrayHost) SetIcon(ic *icon) {
+	systray.SetIcon(ic.data)
+}
+
+func (systrayHost) SetTemplateIcon(ic *icon) {
+	systray.SetTemplateIcon(ic.template, ic.data)
+}
+
+func (systrayHost) SetTitle(title string) {
+	systray.SetTitle(title)
+}
+
+func (systrayHost) SetTooltip(tooltip string) {
+	systray.SetTooltip(tooltip)
+}
+
+// Batch just calls f. systray applies each change to the native menu
+// as it is made.
+func (systrayHost) Batch(f func()) {
+	f()
+}
+
+// systrayItem adapts a systray menu item to a menuItem.
+type systrayItem struct {
+	*systray.MenuItem
+}
 
+func (item systrayItem) Clicked() <-chan struct{} {
+	return item.ClickedCh
+}
+
+func (item systrayItem) OnClick(f func()) {
+	bindClicks(item, f)
+}
+
+func (item systrayItem) Enabled() bool {
+	return !item.Disabled()
+}
+
+func (item systrayItem) AddSubMenuItem(title, tooltip string) menuItem {
+	return systrayItem{item.MenuItem.AddSubMenuItem(title, tooltip)}
+}
+
+func (t *trayImpl) Start(status *tsutil.IPNStatus) error {
 	t.m.Lock()
-	defer t.m.Unlock()
+	t.closed = false
+	t.status = status
 
 	onExit := func() {
-		t.trayReady = false
 		slog.Info("Tray exiting")
 		t.close()
 	}
 
 	onReady := func() {
+		t.m.Lock()
+		defer t.m.Unlock()
+
 		systray.SetRemovalAllowed(true)
-		systray.SetTemplateIcon(statusIconActiveData, statusIconActiveData)
+		systray.SetTemplateIcon(statusIconActive.template, statusIconActive.data)
 		// systray.SetTitle("TS")
 
-		t.showItem = systray.AddMenuItem("Show", "Show Trayscale")
-		go func() {
-			for range t.showItem.ClickedCh {
-				t.OnShow()
-			}
-		}()
-		systray.AddSeparator()
-		t.connToggleItem = systray.AddMenuItemCheckbox("Connected", "Connect to tailscale", status.Online())
-		go func() {
-			for range t.connToggleItem.ClickedCh {
-				t.OnConnToggle()
-			}
-		}()
-		t.exitToggleItem = systray.AddMenuItemCheckbox("Exit Node Enabled", "Allow use of this device as an exit node", status.ExitNodeActive())
-		go func() {
-			for range t.exitToggleItem.ClickedCh {
-				t.OnExitToggle()
-			}
-		}()
-		t.selfNodeItem = systray.AddMenuItem(status.SelfAddr().String(), "Current Node IP")
-		go func() {
-			for range t.selfNodeItem.ClickedCh {
-				t.OnSelfNode()
-			}
-		}()
-		systray.AddSeparator()
-		t.quitItem = systray.AddMenuItem("Quit", "Quit Trayscale (tailscale will remain running)")
-		go func() {
-			for range t.quitItem.ClickedCh {
-				t.OnQuit()
-			}
-		}()
-
-		t.trayReady = true
-
-		t.update(status)
+		// Use the latest status instead of the one passed to Start as
+		// updates may have arrived in the meantime.
+		t.build(systrayHost{}, t.status)
 	}
 
+	resumed := t.resumed
+	onWake.Store(&resumed)
+	C.WatchWake()
+
 	slog.Info("Starting loop")
 	t.appStart, t.appClose = systray.RunWithExternalLoop(onReady, onExit)
+	appStart := t.appStart
+	t.m.Unlock()
 
-	t.prev = make(map[unique.Handle[string]][]any)
-	t.prevBytes = make(map[unique.Handle[string]][][]byte)
-
-	t.appStart()
+	// onReady may be called before appStart returns, so t.m must not
+	// be held.
+	appStart()
 
 	return nil
 }
 
 func (t *trayImpl) Close() error {
-	if t.appClose != nil {
-		t.appClose()
-	}
+	t.m.Lock()
+	t.quitDone()
+	appClose := t.appClose
+	t.m.Unlock()
+
+	call(appClose)
 	return nil
 }
 
+// showHint returns the hint passed to OnShowWithHint. On macOS, the
+// app needs to be activated before its window can be raised.
+func (t *trayImpl) showHint() ShowHint {
+	return ShowHint{activate: t.ShowDock}
+}
+
 func (t *trayImpl) HideDock() {
 	C.HideDock()
 }
@@ -163,136 +181,8 @@ func (t *trayImpl) close() error {
 	t.appStart = nil
 
 	slog.Info("Quit")
+	onWake.Store(nil)
 	systray.Quit()
-	t.prev = nil
+	t.reset()
 	return nil
 }
-
-func (t *trayImpl) Update(s tsutil.Status) {
-	if t == nil {
-		return
-	}
-
-	status, ok := s.(*tsutil.IPNStatus)
-	if !ok {
-		return
-	}
-
-	t.m.Lock()
-	defer t.m.Unlock()
-
-	t.update(status)
-}
-
-func (t *trayImpl) dirty(key unique.Handle[string], vals ...any) bool {
-	prev := t.prev[key]
-	if slices.Equal(vals, prev) {
-		return false
-	}
-
-	t.prev[key] = vals
-	return true
-}
-
-func (t *trayImpl) dirtyBytes(key unique.Handle[string], vals ...[]byte) bool {
-	prevBytesSlices := t.prevBytes[key]
-	if len(prevBytesSlices) != len(vals) {
-		t.prevBytes[key] = vals
-		return true
-	}
-	for index, prevBytes := range prevBytesSlices {
-		if !bytes.Equal(vals[index], prevBytes) {
-			t.prevBytes[key] = vals
-			return true
-		}
-	}
-	return false
-}
-
-func (t *trayImpl) update(status *tsutil.IPNStatus) {
-	if !t.trayReady {
-		return
-	}
-
-	selfTitle, connected := selfTitle(status)
-	connToggleLabel := connToggleText(status.Online())
-	exitToggleLabel := exitToggleText(status)
-
-	t.updateStatusIcon(status)
-
-	if t.dirty(selfHandle, selfTitle, connected) {
-		t.selfNodeItem.SetTitle(fmt.Sprintf("This machine: %v", selfTitle))
-		if connected {
-			t.selfNodeItem.Enable()
-		} else {
-			t.selfNodeItem.Disable()
-		}
-	}
-
-	if t.dirty(connToggleHandle, connToggleLabel, status.Online()) {
-		t.connToggleItem.SetTitle(connToggleLabel)
-		if status.Online() {
-			t.connToggleItem.Check()
-		} else {
-			t.connToggleItem.Uncheck()
-		}
-	}
-
-	if t.dirty(exitToggleHandle, exitToggleLabel, connected, status.ExitNodeActive()) {
-		t.exitToggleItem.SetTitle(exitToggleLabel)
-		if connected {
-			t.exitToggleItem.Enable()
-		} else {
-			t.exitToggleItem.Disable()
-		}
-		if status.ExitNodeActive() {
-			t.exitToggleItem.Check()
-		} else {
-			t.exitToggleItem.Uncheck()
-		}
-	}
-}
-
-func (t *trayImpl) updateStatusIcon(status *tsutil.IPNStatus) {
-	newIcon := statusIcon(status)
-	if !t.dirtyBytes(statusIconHandle, newIcon) {
-		return
-	}
-
-	systray.SetTemplateIcon(newIcon, newIcon)
-}
-
-func statusIcon(status *tsutil.IPNStatus) []byte {
-	if !status.Online() {
-		return statusIconInactiveData
-	}
-	if status.ExitNodeActive() {
-		return statusIconExitNodeData
-	}
-	return statusIconActiveData
-}
-
-func selfTitle(status *tsutil.IPNStatus) (string, bool) {
-	addr := status.SelfAddr()
-	if !addr.IsValid() {
-		return "Not connected", false
-	}
-
-	return fmt.Sprintf("%v (%v)", status.NetMap.SelfNode.DisplayName(true), addr), true
-}
-
-func connToggleText(online bool) string {
-	if online {
-		return "Disconnect"
-	}
-
-	return "Connect"
-}
-
-func exitToggleText(status *tsutil.IPNStatus) string {
-	if status.ExitNodeActive() {
-		return "Disable exit node"
-	}
-
-	return "Enable exit node"
-}
@@ -0,0 +1,37 @@
+#import <Cocoa/Cocoa.h>
+
+void trayDidWake(void);
+
+void HideDock(void) {
+    [NSApp setActivationPolicy:NSApplicationActivationPolicyAccessory];
+}
+
+void ShowDock(void) {
+    [NSApp setActivationPolicy:NSApplicationActivationPolicyRegular];
+    [NSApp activateIgnoringOtherApps:YES];
+}
+
+@interface TrayscaleWakeObserver : NSObject
+@end
+
+@implementation TrayscaleWakeObserver
+- (void)didWake:(NSNotification *)notification {
+    trayDidWake();
+}
+@end
+
+// WatchWake registers for NSWorkspace wake notifications. It is safe
+// to call more than once.
+void WatchWake(void) {
+    static TrayscaleWakeObserver *observer;
+    if (observer != nil) {
+        return;
+    }
+
+    observer = [TrayscaleWakeObserver new];
+    [[[NSWorkspace sharedWorkspace] notificationCenter]
+        addObserver:observer
+           selector:@selector(didWake:)
+               name:NSWorkspaceDidWakeNotification
+             object:nil];
+}
@@ -1,21 +1,342 @@
 package tray
 
-import "deedles.dev/trayscale/internal/tsutil"
+import (
+	"errors"
+	"net/netip"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// ErrNoTrayHost is returned by Start if there is nothing available to
+// display the tray icon. On Linux, this means that no
+// StatusNotifierWatcher is running, which is generally the case on
+// GNOME without the AppIndicator extension installed.
+var ErrNoTrayHost = errors.New("no system tray host available")
+
+// ErrUnknownItem and ErrItemUnavailable are returned by Trigger if
+// the item ID isn't one of the standard items or if that item isn't
+// currently in the menu or is disabled, respectively.
+var (
+	ErrUnknownItem     = errors.New("unknown menu item")
+	ErrItemUnavailable = errors.New("menu item is not available")
+)
 
 // Tray defines the interface for system tray implementations
 type Tray interface {
 	Start(status *tsutil.IPNStatus) error
 	Close() error
 	Update(s tsutil.Status)
+
+	// UpdateIPN is like Update but only accepts an IPNStatus, which
+	// is all that most callers have, so that passing the wrong kind
+	// of status is caught at compile time.
+	UpdateIPN(s *tsutil.IPNStatus)
 	HideDock()
 	ShowDock()
+
+	// SetNotificationPrefs enables and disables individual events.
+	// Keys are Event values and events that are mapped to false are
+	// never passed to OnNotify. Events not present are enabled.
+	SetNotificationPrefs(prefs map[string]bool)
+
+	// SetCompactMode switches between the full menu and a compact one
+	// that leaves out everything but the basic controls, rebuilding
+	// the menu if it has changed. See [WithCompactMode].
+	SetCompactMode(compact bool)
+
+	// SetWindowVisible tells the tray whether the app's window is
+	// open so that the Show item can hide it instead. See OnHide.
+	SetWindowVisible(visible bool)
+
+	// SetPinnedPeers sets the peers that are always listed first in
+	// the peers submenu.
+	SetPinnedPeers(ids []tailcfg.StableNodeID)
+
+	// SetDefaultLANAccess sets whether exit nodes selected from the
+	// exit nodes submenu allow local network access. See
+	// OnDefaultLANAccessToggle.
+	SetDefaultLANAccess(allow bool)
+
+	// SetLastExitNode sets the exit node that the exit node toggle
+	// switches to when it's turned on, such as one saved from a
+	// previous run. See OnLastExitNodeChanged.
+	SetLastExitNode(id tailcfg.StableNodeID)
+
+	// SetAutoExitNode marks the exit node with the given ID as having
+	// been selected automatically, such as by the app in response to
+	// OnNetworkChanged, so that it is labeled as such while it is in
+	// use. An empty ID clears the mark.
+	SetAutoExitNode(id tailcfg.StableNodeID)
+
+	// Refresh reapplies the most recent status to the whole menu, even
+	// the parts that seem to be up to date, such as after the theme
+	// changes or if the menu is suspected to be out of sync with it.
+	// It does nothing if the tray isn't running.
+	Refresh()
+
+	// Trigger calls the same handler that clicking the standard item
+	// with the given ID would, such as "connToggle" or "quit", so that
+	// the menu can be scripted. It fails if the item isn't currently in
+	// the menu or is disabled there. See [TriggerItemIDs] for the IDs.
+	Trigger(itemID string) error
+
+	// CurrentStatus returns the most recent status passed to Update,
+	// or nil if there hasn't been one. Statuses are shared, so the
+	// returned one must not be modified.
+	CurrentStatus() *tsutil.IPNStatus
+
+	// IconPNG returns the PNG-encoded icon that is currently shown in
+	// the tray, which is the template variant on platforms that use
+	// template icons. It returns nil if no icon is shown.
+	IconPNG() []byte
+
+	// History returns the most recent changes of the local node's
+	// status, oldest first. Only a limited number are kept.
+	History() []StatusEvent
+
+	// Metrics returns a snapshot of the tray's counters.
+	Metrics() Metrics
+}
+
+// Metrics holds counters describing the work that a tray has done.
+// They are intended for diagnosing performance problems, such as
+// flickering menus, and for checking that redundant updates are
+// being skipped.
+type Metrics struct {
+	// Updates is the number of calls to Update.
+	Updates uint64
+
+	// AppliedUpdates is the number of updates that were applied to
+	// the menu rather than skipped because the tray was not running.
+	AppliedUpdates uint64
+
+	// IconSets is the number of times that the tray icon was changed.
+	IconSets uint64
+
+	// MenuRebuilds is the number of times that the menu or the peers
+	// submenu was built from scratch.
+	MenuRebuilds uint64
+}
+
+// ShowHint describes how the window should be shown when the tray
+// asks for it via OnShowWithHint.
+type ShowHint struct {
+	activate func()
+}
+
+// Activate performs any platform-specific activation that needs to
+// happen before the window is raised, such as making the app a regular
+// app with a Dock icon on macOS. It does nothing on platforms that
+// don't need it.
+func (h ShowHint) Activate() {
+	if h.activate != nil {
+		h.activate()
+	}
 }
 
 // Callbacks holds the tray event handlers
 type Callbacks struct {
-	OnShow       func()
+	// OnShowWithHint is called when the window should be shown and
+	// focused. Handlers should call hint.Activate before raising the
+	// window.
+	OnShowWithHint func(hint ShowHint)
+
+	// OnHide, if non-nil, is called when the user chooses to hide the
+	// window. The Show item offers to hide the window instead of
+	// showing it while SetWindowVisible reports that it's open. If
+	// OnHide is nil, it always shows it.
+	OnHide func()
+
 	OnConnToggle func()
+
+	// OnLogin, if non-nil, is called when the user chooses to log in
+	// while no login is in progress, so that the app can start one.
+	// Logins that are already in progress are finished by opening
+	// their URL with OnOpenURL instead. If it is nil, the login item
+	// is only shown while a login is in progress.
+	OnLogin func()
+
+	// OnConnect and OnDisconnect are called by the separate connect
+	// and disconnect items. See [WithSeparateConnectItems]. If either
+	// is nil, OnConnToggle is called in its place.
+	OnConnect    func()
+	OnDisconnect func()
+
+	// OnServiceStartStop, if non-nil, is called when the user chooses
+	// to start or stop the Tailscale daemon itself, as opposed to
+	// merely connecting or disconnecting. The handler is responsible
+	// for any privileges that doing so requires. If it is nil, the
+	// option is not offered.
+	OnServiceStartStop func(start bool)
+
 	OnExitToggle func()
 	OnSelfNode   func()
-	OnQuit       func()
+
+	// OnQuit is called when the user chooses to quit. The app should
+	// shut itself down and call Close on the tray once it is ready for
+	// the icon to go away, either from within OnQuit or afterwards.
+	// The tray doesn't remove itself before OnQuit is called, but if
+	// Close hasn't been called a few seconds after OnQuit returns, the
+	// tray closes itself anyway.
+	OnQuit func()
+
+	// OnAbout, if non-nil, is called when the user chooses to see
+	// information about the app. The item is only shown if the tray
+	// was created with [WithAboutItem].
+	OnAbout func()
+
+	// OnCopy is called with text that should be copied to the
+	// clipboard.
+	OnCopy func(text string)
+
+	// OnNotify, if non-nil, is called when the tray notices a state
+	// transition. It is never called for the initial state.
+	OnNotify func(event Event)
+
+	// OnConnected and OnDisconnected, if non-nil, are called once each
+	// time that the local node actually comes online or goes offline,
+	// no matter what caused it. Like OnNotify, they are never called
+	// for the initial state. They are unaffected by
+	// SetNotificationPrefs.
+	OnConnected    func()
+	OnDisconnected func()
+
+	// OnConfirmDisconnect is called instead of disconnecting when the
+	// tray was created with [WithConfirmDisconnect]. It should call
+	// proceed if and only if the user confirms that they want to
+	// disconnect.
+	OnConfirmDisconnect func(proceed func())
+
+	// OnPanic, if non-nil, is called when the user chooses to lock the
+	// local node down immediately. It should disconnect and enable
+	// shields up, and may also stop advertising the local node as an
+	// exit node. The item is only shown if the tray was created with
+	// [WithPanicItem].
+	OnPanic func()
+
+	// OnRename, if non-nil, is called when the user chooses to rename
+	// the local node, so that the app can ask for a new name and
+	// submit it. The menu picks up the new name from the status once
+	// it has taken effect. If it is nil, the option is not offered.
+	OnRename func()
+
+	// OnCaptivePortal, if non-nil, is called when the user clicks the
+	// item that is shown while a captive portal seems to be blocking
+	// connectivity. If it is nil, a page that the portal will
+	// intercept is opened with OnOpenURL instead, which is usually all
+	// that is needed. See [tsutil.IPNStatus.CaptivePortal].
+	OnCaptivePortal func()
+
+	// OnSelfAddrChanged, if non-nil, is called when the local node's
+	// address changes, such as after it is reauthenticated. It is
+	// never called for the initial address or when the node merely
+	// goes offline.
+	OnSelfAddrChanged func(old, new netip.Addr)
+
+	// OnUseSuggestedExit, if non-nil, is called when the user chooses
+	// to switch to the exit node suggested by the backend.
+	OnUseSuggestedExit func()
+
+	// OnSignNode, if non-nil, is called when the user chooses to sign
+	// the node key of a node that is waiting to be allowed onto a
+	// tailnet with tailnet lock enabled. Such nodes are only listed if
+	// the local node holds a trusted tailnet lock key. If it is nil,
+	// the submenu is not shown.
+	OnSignNode func(nodeKey key.NodePublic)
+
+	// OnNetworkChanged, if non-nil, is called with the SSID of the
+	// Wi-Fi network that the system is connected to once the tray has
+	// started and then each time that it changes, so that the app can,
+	// for example, select an exit node on untrusted networks. The SSID
+	// is empty if the system's primary connection isn't Wi-Fi. It is
+	// only supported on Linux with NetworkManager.
+	OnNetworkChanged func(ssid string)
+
+	// OnLastExitNodeChanged, if non-nil, is called when an exit node
+	// other than the last one starts being used so that the app can
+	// persist it and pass it to SetLastExitNode on the next run.
+	OnLastExitNodeChanged func(id tailcfg.StableNodeID)
+
+	// OnPeerPinToggle, if non-nil, is called when the user chooses to
+	// pin or unpin a peer in the peers submenu. The handler is
+	// expected to persist the change and call SetPinnedPeers.
+	OnPeerPinToggle func(id tailcfg.StableNodeID)
+
+	// OnPeerPathChanged, if non-nil, is called when the connection to
+	// an active peer switches between direct and relayed, which often
+	// points to NAT trouble. A new path is only reported once it has
+	// been seen several times in a row, and a peer's first path after
+	// it becomes active isn't reported at all, so that the switches
+	// made while a connection is being established are left out. It
+	// needs [tsutil.PeerPathStatus] updates.
+	OnPeerPathChanged func(id tailcfg.StableNodeID, direct bool)
+
+	// OnCopySSHCommand, if non-nil, is called when the user chooses to
+	// copy a command for connecting to a peer via Tailscale SSH. It is
+	// only offered for online peers that run Tailscale SSH. See
+	// [tsutil.IPNStatus.PeerSSHCommand].
+	OnCopySSHCommand func(id tailcfg.StableNodeID)
+
+	// OnOpenURL, if non-nil, is called with a URL that should be
+	// opened in the user's browser. Every item that opens a page goes
+	// through it, including the admin console, a pending login, a
+	// captive portal, and peers' web services, so it is generally the
+	// only callback that an app needs for opening URLs. If it is nil,
+	// those items do nothing unless a more specific callback is set.
+	OnOpenURL func(url string)
+
+	// OnOpenPeerService, if non-nil, is called instead of OnOpenURL
+	// with the URL of a web service offered by a peer when the user
+	// chooses to open it. Either way, only HTTP and HTTPS URLs are
+	// opened. See [tsutil.IPNStatus.PeerServiceURLs]. If both are nil,
+	// peers' services are not listed.
+	OnOpenPeerService func(url string)
+
+	// OnAcceptPeerRoutes, if non-nil, is called when the user chooses
+	// to accept the subnet routes advertised by a specific peer. If it
+	// is nil, the option is not offered at all.
+	OnAcceptPeerRoutes func(id tailcfg.StableNodeID)
+
+	// OnExitNodeSelect, if non-nil, is called when the user selects
+	// an exit node from the exit nodes submenu, along with whether
+	// local network access should be allowed while using it. If it is
+	// nil, the submenu is not shown.
+	OnExitNodeSelect func(id tailcfg.StableNodeID, allowLAN bool)
+
+	// OnDefaultLANAccessToggle, if non-nil, is called with the new
+	// value when the user toggles whether exit nodes selected from the
+	// exit nodes submenu allow local network access. The handler is
+	// expected to persist the change and call SetDefaultLANAccess. If
+	// it is nil, or if the tray was created with
+	// [WithExitNodeLANChoice], the toggle is not shown.
+	OnDefaultLANAccessToggle func(allow bool)
+
+	// OnShowQR, if non-nil, is called with text, such as the local
+	// node's address, that the user wants to see as a QR code. It is
+	// only used if the tray was created with [WithQRItems].
+	OnShowQR func(text string)
+
+	// OnApplyUpdate, if non-nil, is called when the user chooses to
+	// restart Tailscale to apply an update that has already been
+	// downloaded.
+	OnApplyUpdate func()
+
+	// OnOpenTaildropDir and OnClearTaildrop, if non-nil, are called
+	// when the user chooses to open the folder that received Taildrop
+	// files are saved to or to delete any received files that are
+	// still waiting, respectively. The tray doesn't touch the files
+	// itself. If both are nil, the Taildrop submenu is not shown.
+	OnOpenTaildropDir func()
+	OnClearTaildrop   func()
+
+	// OnResume, if non-nil, is called after the system wakes from
+	// sleep so that the app can fetch fresh status.
+	OnResume func()
+
+	// OnConnectivityResult, if non-nil, is called with the outcome of
+	// each check made by [WithConnectivityCheck]. It is called from a
+	// background goroutine.
+	OnConnectivityResult func(ok bool)
 }
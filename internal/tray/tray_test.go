@@ -0,0 +1,383 @@
+//go:build linux
+
+package tray
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unique"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestProfileSwitch(t *testing.T) {
+	tr := &trayImpl{prev: make(map[unique.Handle[string]][]any)}
+	tr.Update(&tsutil.IPNStatus{State: ipn.Running})
+
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "a"}})
+	tr.prev[selfHandle] = []any{"old", true}
+
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "a"}})
+	require.Contains(t, tr.prev, selfHandle)
+
+	tr.Update(&tsutil.ProfileStatus{Profile: ipn.LoginProfile{ID: "b"}})
+	require.Empty(t, tr.prev)
+}
+
+func TestIconFallback(t *testing.T) {
+	broken := newIcon([]byte("not a png"), nil)
+	require.Error(t, broken.err)
+
+	var tr trayImpl
+	require.Same(t, statusIconInactive, tr.usableIcon(broken))
+
+	tr.icon = statusIconExitNode
+	require.Same(t, statusIconExitNode, tr.usableIcon(broken))
+	require.Same(t, statusIconActive, tr.usableIcon(statusIconActive))
+
+	require.NoError(t, ValidateIcons())
+	require.Same(t, statusIconWarning, statusIcon(&tsutil.IPNStatus{DaemonUnreachable: true}))
+}
+
+func TestGeneratedIcon(t *testing.T) {
+	ic := defaultGeneratedIcon("warning")
+	require.NoError(t, ic.err)
+	require.Equal(t, generatedIconSize, ic.img.Bounds().Dx())
+	require.Equal(t, warningColor, color.NRGBAModel.Convert(ic.img.At(generatedIconSize/2, generatedIconSize/2)))
+	require.Zero(t, color.NRGBAModel.Convert(ic.img.At(0, 0)).(color.NRGBA).A)
+	_, err := png.Decode(bytes.NewReader(ic.data))
+	require.NoError(t, err)
+
+	require.Nil(t, orGenerated("active", statusIconActive).fallback)
+	stripped := orGenerated("exit node", newIcon(nil, nil))
+	require.Error(t, stripped.err)
+
+	var tr trayImpl
+	tr.icon = statusIconActive
+	require.Same(t, stripped.fallback, tr.usableIcon(stripped))
+
+	for name := range statusIcons {
+		require.Contains(t, generatedIconColors, name, "status icon %q has no generated color", name)
+	}
+}
+
+func TestAutoShow(t *testing.T) {
+	var shown atomic.Int32
+	done := make(chan struct{}, 2)
+	cb := Callbacks{OnShowWithHint: func(ShowHint) {
+		shown.Add(1)
+		done <- struct{}{}
+	}}
+
+	tr := New(cb).(*trayImpl)
+	tr.autoShowOnce()
+	require.Zero(t, shown.Load())
+
+	tr = New(cb, WithAutoShow(true)).(*trayImpl)
+	tr.autoShowOnce()
+	tr.autoShowOnce()
+	<-done
+	require.EqualValues(t, 1, shown.Load())
+}
+
+func TestNoTrayHost(t *testing.T) {
+	defer func(f func() (bool, error)) { hasTrayHost = f }(hasTrayHost)
+	hasTrayHost = func() (bool, error) { return false, nil }
+
+	tr := New(Callbacks{})
+	err := tr.Start(&tsutil.IPNStatus{})
+	require.ErrorIs(t, err, ErrNoTrayHost)
+}
+
+func TestNotifyEvents(t *testing.T) {
+	var events []Event
+	tr := &trayImpl{
+		Callbacks: Callbacks{OnNotify: func(event Event) { events = append(events, event) }},
+		prev:      make(map[unique.Handle[string]][]any),
+	}
+
+	prefs := ipn.NewPrefs().View()
+	tr.updateEvents(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+	tr.notify()
+	require.Empty(t, events)
+
+	tr.updateEvents(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	tr.notify()
+	require.Equal(t, []Event{EventConnected}, events)
+
+	events = nil
+	tr.SetNotificationPrefs(map[string]bool{string(EventDisconnected): false})
+	tr.updateEvents(&tsutil.IPNStatus{State: ipn.Stopped, Prefs: prefs})
+	tr.updateEvents(&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs})
+	tr.notify()
+	require.Equal(t, []Event{EventConnected}, events)
+}
+
+func TestNotifyFlapping(t *testing.T) {
+	var events []Event
+	tr := &trayImpl{
+		Callbacks: Callbacks{OnNotify: func(event Event) { events = append(events, event) }},
+		prev:      make(map[unique.Handle[string]][]any),
+	}
+
+	prefs := ipn.NewPrefs().View()
+	start := time.Unix(0, 0)
+	update := func(at time.Duration, state ipn.State) {
+		status := &tsutil.IPNStatus{State: state, Prefs: prefs}
+		tr.history.record(statusEvent(start.Add(at), status))
+		tr.updateEvents(status)
+	}
+
+	update(0, ipn.Stopped)
+	for i := range 5 {
+		state := ipn.Running
+		if i%2 == 1 {
+			state = ipn.Stopped
+		}
+		update(time.Duration(i+1)*time.Second, state)
+	}
+	tr.notify()
+	require.Equal(t, []Event{EventConnected, EventDisconnected, EventConnectionUnstable}, events)
+
+	events = nil
+	update(time.Hour, ipn.Stopped)
+	tr.notify()
+	require.Equal(t, []Event{EventDisconnected}, events)
+}
+
+func TestUpdateAfterClose(t *testing.T) {
+	tr := &trayImpl{prev: make(map[unique.Handle[string]][]any)}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			tr.Update(&tsutil.IPNStatus{State: ipn.Running})
+		}
+	}()
+	require.NoError(t, tr.Close())
+	wg.Wait()
+
+	status := &tsutil.IPNStatus{State: ipn.Stopped}
+	tr.Update(status)
+	require.NotSame(t, status, tr.status)
+}
+
+func TestConnectionTransitions(t *testing.T) {
+	var transitions []string
+	tr := &trayImpl{
+		Callbacks: Callbacks{
+			OnConnected:    func() { transitions = append(transitions, "connected") },
+			OnDisconnected: func() { transitions = append(transitions, "disconnected") },
+		},
+		prev: make(map[unique.Handle[string]][]any),
+	}
+	tr.SetNotificationPrefs(map[string]bool{string(EventConnected): false, string(EventDisconnected): false})
+
+	prefs := ipn.NewPrefs().View()
+	for _, state := range []ipn.State{ipn.Running, ipn.Running, ipn.Stopped, ipn.Stopped, ipn.Running} {
+		tr.updateEvents(&tsutil.IPNStatus{State: state, Prefs: prefs})
+	}
+	tr.notify()
+	require.Equal(t, []string{"disconnected", "connected"}, transitions)
+
+	transitions = nil
+	tr.notify()
+	require.Empty(t, transitions)
+}
+
+func TestIconPixmaps(t *testing.T) {
+	pixmaps := statusIconActive.pixmaps()
+	require.Len(t, pixmaps, len(pixmapSizes)+1)
+	for i, size := range pixmapSizes {
+		require.Equal(t, size, pixmaps[i].Bounds().Dx())
+		require.Equal(t, size, pixmaps[i].Bounds().Dy())
+	}
+	require.Same(t, statusIconActive.img, pixmaps[len(pixmaps)-1])
+
+	small := iconPixmaps(image.NewNRGBA(image.Rect(0, 0, 24, 24)))
+	require.Len(t, small, 3)
+}
+
+func TestValidateIcons(t *testing.T) {
+	require.NoError(t, ValidateIcons())
+
+	defer func(icons map[string]*icon) { statusIcons = icons }(statusIcons)
+	statusIcons = map[string]*icon{
+		"broken": newIcon(nil, nil),
+		"mono":   {img: statusIconActive.img, template: []byte("not a png")},
+	}
+	err := ValidateIcons()
+	require.ErrorContains(t, err, "broken icon")
+	require.ErrorContains(t, err, "mono template icon")
+}
+
+func TestCurrentStatus(t *testing.T) {
+	tr := New(Callbacks{})
+	require.Nil(t, tr.CurrentStatus())
+
+	status := &tsutil.IPNStatus{State: ipn.Stopped, Prefs: ipn.NewPrefs().View()}
+	tr.Update(status)
+	require.Same(t, status, tr.CurrentStatus())
+}
+
+func TestSelfAddrChanged(t *testing.T) {
+	type change struct{ old, new netip.Addr }
+	var changes []change
+	tr := &trayImpl{
+		Callbacks: Callbacks{OnSelfAddrChanged: func(old, new netip.Addr) { changes = append(changes, change{old, new}) }},
+		prev:      make(map[unique.Handle[string]][]any),
+	}
+
+	status := func(state ipn.State, addr string) *tsutil.IPNStatus {
+		self := &tailcfg.Node{}
+		if addr != "" {
+			self.Addresses = []netip.Prefix{netip.MustParsePrefix(addr + "/32")}
+		}
+		return &tsutil.IPNStatus{
+			State:  state,
+			Prefs:  ipn.NewPrefs().View(),
+			NetMap: &netmap.NetworkMap{SelfNode: self.View()},
+		}
+	}
+
+	tr.updateEvents(status(ipn.Running, "100.64.0.1"))
+	tr.updateEvents(status(ipn.Running, "100.64.0.1"))
+	tr.updateEvents(status(ipn.Stopped, ""))
+	tr.updateEvents(status(ipn.Running, "100.64.0.1"))
+	tr.notify()
+	require.Empty(t, changes)
+
+	tr.updateEvents(status(ipn.Running, "100.64.0.2"))
+	tr.notify()
+	require.Equal(t, []change{{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2")}}, changes)
+}
+
+func TestPeerPathChanged(t *testing.T) {
+	type change struct {
+		id     tailcfg.StableNodeID
+		direct bool
+	}
+	var changes []change
+	tr := &trayImpl{
+		Callbacks: Callbacks{OnPeerPathChanged: func(id tailcfg.StableNodeID, direct bool) {
+			changes = append(changes, change{id, direct})
+		}},
+		prev: make(map[unique.Handle[string]][]any),
+	}
+	report := func(direct ...bool) {
+		for _, d := range direct {
+			tr.Update(&tsutil.PeerPathStatus{Direct: map[tailcfg.StableNodeID]bool{"peer": d}})
+		}
+	}
+
+	// Establishing the connection via a relay first isn't a change.
+	report(false, true, true, true)
+	require.Empty(t, changes)
+
+	// Neither is a brief blip.
+	report(false, true, true, true)
+	require.Empty(t, changes)
+
+	report(false, false, false, false)
+	require.Equal(t, []change{{"peer", false}}, changes)
+
+	// Going idle and reconnecting starts over.
+	changes = nil
+	tr.Update(&tsutil.PeerPathStatus{})
+	report(true, true, true)
+	require.Empty(t, changes)
+}
+
+func TestClock(t *testing.T) {
+	var events []Event
+	tr := New(Callbacks{OnNotify: func(event Event) { events = append(events, event) }}).(*trayImpl)
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+
+	lastSeen := now.Add(-2*time.Hour - 10*time.Minute)
+	prefs := ipn.NewPrefs().View()
+	status := func(state ipn.State) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{
+			State: state,
+			Prefs: prefs,
+			Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+				"off": (&tailcfg.Node{
+					StableID:             "off",
+					ComputedNameWithHost: "nas",
+					Hostinfo:             (&tailcfg.Hostinfo{}).View(),
+					LastSeen:             &lastSeen,
+				}).View(),
+			},
+		}
+	}
+
+	tr.build(&fakeMenuHost{}, status(ipn.Running))
+	peer := tr.peerItems["off"].item.(*fakeMenuItem)
+	require.Equal(t, "nas (last seen 2h ago)", peer.tooltip)
+
+	now = now.Add(24 * time.Hour)
+	tr.Update(status(ipn.Running))
+	require.Equal(t, "nas (last seen 1d ago)", peer.tooltip)
+	require.Equal(t, now, tr.lastUpdate)
+
+	for i := range 4 {
+		now = now.Add(time.Second)
+		state := ipn.Stopped
+		if i%2 == 1 {
+			state = ipn.Running
+		}
+		tr.Update(status(state))
+	}
+	require.Equal(t, []Event{EventDisconnected, EventConnected, EventConnectionUnstable}, events)
+
+	events = nil
+	now = now.Add(time.Hour)
+	tr.Update(status(ipn.Stopped))
+	require.Equal(t, []Event{EventDisconnected}, events)
+}
+
+func TestQuit(t *testing.T) {
+	var order []string
+	var tr *trayImpl
+	tr = New(Callbacks{OnQuit: func() {
+		order = append(order, "quit")
+		tr.Close()
+		order = append(order, "closed")
+	}}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	tr.quitItem.(*fakeMenuItem).onClick()
+	require.Equal(t, []string{"quit", "closed"}, order)
+	require.True(t, tr.closed)
+	require.Nil(t, tr.quitWait)
+	tr.quitting.Wait()
+
+	defer func(timeout time.Duration) { quitTimeout = timeout }(quitTimeout)
+	quitTimeout = 10 * time.Millisecond
+
+	var quit atomic.Bool
+	tr = New(Callbacks{OnQuit: func() { quit.Store(true) }}).(*trayImpl)
+	tr.build(&fakeMenuHost{}, &tsutil.IPNStatus{State: ipn.Running, Prefs: ipn.NewPrefs().View()})
+
+	tr.quitItem.(*fakeMenuItem).onClick()
+	require.True(t, quit.Load())
+	require.Eventually(t, func() bool {
+		tr.m.Lock()
+		defer tr.m.Unlock()
+		return tr.closed
+	}, time.Second, time.Millisecond)
+	tr.quitting.Wait()
+}
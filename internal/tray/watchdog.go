@@ -0,0 +1,58 @@
+//go:build linux || darwin
+
+package tray
+
+import (
+	"log/slog"
+	"time"
+)
+
+// startWatchdog starts polling for status if the tray was configured
+// with [WithPollInterval] and it isn't already doing so. It must be
+// called with t.m held.
+func (t *trayImpl) startWatchdog() {
+	if (t.pollInterval <= 0) || (t.pollStatus == nil) || (t.watchdogDone != nil) {
+		return
+	}
+
+	stop := make(chan struct{})
+	t.watchdogDone = stop
+	t.lastUpdate = t.currentTime()
+	go t.watchdog(stop)
+}
+
+// stopWatchdog stops polling started by startWatchdog. It must be
+// called with t.m held.
+func (t *trayImpl) stopWatchdog() {
+	if t.watchdogDone != nil {
+		close(t.watchdogDone)
+		t.watchdogDone = nil
+	}
+}
+
+// watchdog polls for status whenever no update has arrived for a full
+// interval until stop is closed.
+func (t *trayImpl) watchdog(stop <-chan struct{}) {
+	tick := time.NewTicker(t.pollInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+
+		t.m.Lock()
+		stale := t.currentTime().Sub(t.lastUpdate)
+		t.m.Unlock()
+		if stale < t.pollInterval {
+			continue
+		}
+
+		slog.Warn("no tray updates received, polling", "since", stale)
+		if s := t.pollStatus(); s != nil {
+			t.Update(s)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log/slog"
 	"net/netip"
+	"strings"
 	"time"
 
 	"tailscale.com/client/local"
@@ -17,7 +18,9 @@ import (
 	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/eventbus"
 )
 
@@ -65,6 +68,39 @@ func Stop(ctx context.Context) error {
 	return cli.Run([]string{"down"})
 }
 
+// Lockdown disconnects the local peer from the Tailscale network and
+// enables shields up in a single change so that no incoming
+// connections are accepted if it is reconnected. If stopExitNode is
+// true, it also stops advertising the local peer as an exit node,
+// leaving any other advertised routes alone.
+func Lockdown(ctx context.Context, stopExitNode bool) error {
+	mp := &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			WantRunning: false,
+			ShieldsUp:   true,
+		},
+		WantRunningSet: true,
+		ShieldsUpSet:   true,
+	}
+
+	if stopExitNode {
+		prefs, err := Prefs(ctx)
+		if err != nil {
+			return fmt.Errorf("get prefs: %w", err)
+		}
+		mp.AdvertiseRoutes = prefs.AdvertiseRoutes
+		mp.SetAdvertiseExitNode(false)
+		mp.AdvertiseRoutesSet = true
+	}
+
+	_, err := localClient.EditPrefs(ctx, mp)
+	if err != nil {
+		return fmt.Errorf("edit prefs: %w", err)
+	}
+
+	return nil
+}
+
 // ExitNode uses the specified peer as an exit node, or unsets
 // an existing exit node if peer is an empty string.
 func ExitNode(ctx context.Context, peer tailcfg.StableNodeID) error {
@@ -188,6 +224,27 @@ func AcceptRoutes(ctx context.Context, accept bool) error {
 	return nil
 }
 
+// SetHostname changes the hostname that the local node reports to
+// the control plane, which names the machine in the tailnet unless it
+// has been renamed in the admin console. The name must be a valid DNS
+// label.
+func SetHostname(ctx context.Context, name string) error {
+	err := dnsname.ValidHostname(name)
+	if err != nil {
+		return fmt.Errorf("invalid hostname: %w", err)
+	}
+
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{Hostname: name},
+		HostnameSet: true,
+	})
+	if err != nil {
+		return fmt.Errorf("edit prefs: %w", err)
+	}
+
+	return nil
+}
+
 // SetControlURL changes the URL of the control plane server used by
 // the daemon. If controlURL is empty, the default Tailscale server is
 // used.
@@ -230,6 +287,82 @@ func NetCheck(ctx context.Context, full bool) (*netcheck.Report, *tailcfg.DERPMa
 	return r, dm, nil
 }
 
+// Ping checks that target can be reached. target is either a peer,
+// given as one of its Tailscale IP addresses, its MagicDNS name, or
+// its host name, or a DERP relay, given as "derp" for whichever region
+// is closest or as "derp:" followed by a region code, such as
+// "derp:nyc", for a specific one.
+func Ping(ctx context.Context, target string) error {
+	if region, ok := strings.CutPrefix(target, "derp"); ok && ((region == "") || (region[0] == ':')) {
+		return pingDERP(ctx, strings.TrimPrefix(region, ":"))
+	}
+
+	ip, err := resolvePeer(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	r, err := localClient.Ping(ctx, ip, tailcfg.PingDisco)
+	if err != nil {
+		return fmt.Errorf("ping %v: %w", target, err)
+	}
+	if r.Err != "" {
+		return fmt.Errorf("ping %v: %v", target, r.Err)
+	}
+	return nil
+}
+
+// resolvePeer returns the Tailscale IP address of the peer identified
+// by target as described by [Ping].
+func resolvePeer(ctx context.Context, target string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(target); err == nil {
+		return ip, nil
+	}
+
+	st, err := GetStatus(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, peer := range st.Peer {
+		dnsName := strings.TrimSuffix(peer.DNSName, ".")
+		base, _, _ := strings.Cut(dnsName, ".")
+		if !strings.EqualFold(target, dnsName) && !strings.EqualFold(target, base) && !strings.EqualFold(target, peer.HostName) {
+			continue
+		}
+		if len(peer.TailscaleIPs) == 0 {
+			return netip.Addr{}, fmt.Errorf("peer %q has no addresses", target)
+		}
+		return peer.TailscaleIPs[0], nil
+	}
+	return netip.Addr{}, fmt.Errorf("no peer named %q", target)
+}
+
+// pingDERP checks that the DERP region with the given code, or any
+// region if code is empty, can be reached.
+func pingDERP(ctx context.Context, code string) error {
+	r, dm, err := NetCheck(ctx, false)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		if r.PreferredDERP == 0 {
+			return errors.New("no DERP region is reachable")
+		}
+		return nil
+	}
+
+	for id, region := range dm.Regions {
+		if !strings.EqualFold(region.RegionCode, code) {
+			continue
+		}
+		if _, ok := r.RegionLatency[id]; !ok {
+			return fmt.Errorf("DERP region %q is not reachable", code)
+		}
+		return nil
+	}
+	return fmt.Errorf("no DERP region with code %q", code)
+}
+
 func PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
 	return localClient.PushFile(ctx, target, size, name, r)
 }
@@ -261,6 +394,17 @@ func SwitchProfile(ctx context.Context, id ipn.ProfileID) error {
 	return localClient.SwitchProfile(ctx, id)
 }
 
+func NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
+	return localClient.NetworkLockStatus(ctx)
+}
+
+// SignNode signs the node key of a node that is waiting to be allowed
+// onto a tailnet with tailnet lock enabled. The local node must hold
+// a trusted tailnet lock key.
+func SignNode(ctx context.Context, nodeKey key.NodePublic) error {
+	return localClient.NetworkLockSign(ctx, nodeKey, nil)
+}
+
 func StartLogin(ctx context.Context) error {
 	return localClient.StartLoginInteractive(ctx)
 }
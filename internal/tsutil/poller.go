@@ -1,13 +1,21 @@
 package tsutil
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"maps"
+	"net"
 	"net/netip"
+	"net/url"
 	"os/user"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,9 +23,15 @@ import (
 	"deedles.dev/trayscale/internal/xnetip"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/feature/taildrop"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tsconst"
 	"tailscale.com/types/netmap"
+	"tailscale.com/util/cmpver"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/set"
 )
 
@@ -70,6 +84,8 @@ func (p *Poller) Run(ctx context.Context) {
 	go p.watchIPN(ctx)
 	go p.watchFiles(ctx, n)
 	go p.watchProfiles(ctx, n)
+	go p.watchTailnetLock(ctx, n)
+	go p.watchPeerPaths(ctx, n)
 
 	interval := p.Interval
 	if interval < 0 {
@@ -96,20 +112,7 @@ func (p *Poller) Run(ctx context.Context) {
 }
 
 func (p *Poller) watchIPN(ctx context.Context) {
-	const watcherOpts = ipn.NotifyInitialState | ipn.NotifyInitialPrefs | ipn.NotifyInitialNetMap | ipn.NotifyNoPrivateKeys | ipn.NotifyWatchEngineUpdates | ipn.NotifyRateLimit
-
-watch:
-	watcher, err := localClient.WatchIPNBus(ctx, watcherOpts)
-	if err != nil {
-		slog.Error("start IPN bus watcher", "err", err)
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(5 * time.Second):
-			goto watch
-		}
-	}
-	defer watcher.Close()
+	const watcherOpts = ipn.NotifyInitialState | ipn.NotifyInitialPrefs | ipn.NotifyInitialNetMap | ipn.NotifyNoPrivateKeys | ipn.NotifyWatchEngineUpdates | ipn.NotifyRateLimit | ipn.NotifyInitialHealthState | ipn.NotifyInitialSuggestedExitNode
 
 	set := make(chan *IPNStatus)
 	go func() {
@@ -128,6 +131,48 @@ watch:
 	}()
 
 	var s IPNStatus
+
+	publish := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-p.poll:
+		}
+
+		c := s.copy()
+		select {
+		case <-ctx.Done():
+			return false
+		case set <- c:
+		}
+		select {
+		case p.nextIPN <- c:
+		default:
+		}
+		return true
+	}
+
+watch:
+	watcher, err := localClient.WatchIPNBus(ctx, watcherOpts)
+	if err != nil {
+		slog.Error("start IPN bus watcher", "err", err)
+		if !s.DaemonUnreachable {
+			s = IPNStatus{DaemonUnreachable: true}
+			if !publish() {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+			goto watch
+		}
+	}
+	defer watcher.Close()
+	s.DaemonUnreachable = false
+
 	for {
 		notify, err := watcher.Next()
 		if err != nil {
@@ -164,33 +209,32 @@ watch:
 			dirty = true
 		}
 		if notify.Engine != nil {
-			s.Engine = notify.Engine
+			s.PrevEngine, s.PrevEngineTime = s.Engine, s.EngineTime
+			s.Engine, s.EngineTime = notify.Engine, time.Now()
 			dirty = true
 		}
 		if notify.BrowseToURL != nil {
 			s.BrowseToURL = *notify.BrowseToURL
 			dirty = true
 		}
-		// TODO: Handle health warnings.
+		if notify.Health != nil {
+			s.Health = notify.Health
+			dirty = true
+		}
+		if notify.SuggestedExitNode != nil {
+			s.SuggestedExitNodeID = *notify.SuggestedExitNode
+			dirty = true
+		}
+		if notify.ClientVersion != nil {
+			s.ClientUpdate = notify.ClientVersion
+			dirty = true
+		}
 		if !dirty {
 			continue
 		}
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-p.poll:
-		}
-
-		c := s.copy()
-		select {
-		case <-ctx.Done():
+		if !publish() {
 			return
-		case set <- c:
-		}
-		select {
-		case p.nextIPN <- c:
-		default:
 		}
 	}
 }
@@ -241,6 +285,52 @@ func (p *Poller) watchProfiles(ctx context.Context, n *notifier) {
 	}
 }
 
+func (p *Poller) watchTailnetLock(ctx context.Context, n *notifier) {
+	for {
+		lock, err := NetworkLockStatus(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("get tailnet lock status", "err", err)
+			goto wait
+		}
+
+		p.New(&TailnetLockStatus{Lock: lock})
+
+	wait:
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.notify:
+			n = n.next
+		}
+	}
+}
+
+func (p *Poller) watchPeerPaths(ctx context.Context, n *notifier) {
+	for {
+		st, err := localClient.Status(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("get peer paths", "err", err)
+			goto wait
+		}
+
+		p.New(NewPeerPathStatus(st))
+
+	wait:
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.notify:
+			n = n.next
+		}
+	}
+}
+
 // Poll returns a channel that, when received from, causes a new
 // status to be fetched from Tailscale.
 func (p *Poller) Poll() <-chan struct{} {
@@ -290,6 +380,28 @@ type IPNStatus struct {
 	FileTargets set.Set[tailcfg.StableNodeID]
 	Engine      *ipn.EngineStatus
 	BrowseToURL string
+	Health      *health.State
+
+	// EngineTime is when Engine was received. PrevEngine and
+	// PrevEngineTime are the engine status received before that and
+	// when it was received, if there was one. They are used to
+	// calculate Throughput.
+	EngineTime     time.Time
+	PrevEngine     *ipn.EngineStatus
+	PrevEngineTime time.Time
+
+	// SuggestedExitNodeID is the ID of the exit node that the backend
+	// suggests using. It is empty if there is no suggestion.
+	SuggestedExitNodeID tailcfg.StableNodeID
+
+	// ClientUpdate is the most recently reported information about
+	// updates to the local Tailscale client. It is nil if none has
+	// been reported.
+	ClientUpdate *tailcfg.ClientVersion
+
+	// DaemonUnreachable is true if the local Tailscale daemon could not
+	// be contacted. If it is, the rest of the status is empty.
+	DaemonUnreachable bool
 }
 
 func (*IPNStatus) status() {}
@@ -328,6 +440,12 @@ func (s *IPNStatus) rebuildPeers(ctx context.Context) {
 	}
 }
 
+// DaemonReachable returns true if the local Tailscale daemon could be
+// contacted when s was retrieved.
+func (s *IPNStatus) DaemonReachable() bool {
+	return !s.DaemonUnreachable
+}
+
 // Online returns true if s indicates that the local node is online
 // and connected to the tailnet.
 func (s *IPNStatus) Online() bool {
@@ -338,10 +456,221 @@ func (s *IPNStatus) NeedsAuth() bool {
 	return s.State == ipn.NeedsLogin
 }
 
+// A LoginState is how the local node came to need a login, if it
+// does.
+type LoginState int
+
+const (
+	// LoggedIn means that no login is needed.
+	LoggedIn LoginState = iota
+
+	// NeverLoggedIn means that the node has never been logged in.
+	NeverLoggedIn
+
+	// LoggedOut means that the user explicitly logged out.
+	LoggedOut
+
+	// KeyExpired means that the node was logged in until its key
+	// expired or was otherwise invalidated.
+	KeyExpired
+)
+
+// LoginState returns why the local node needs a login. A node that was
+// previously logged in is identified by the node ID kept in its
+// persisted prefs.
+func (s *IPNStatus) LoginState() LoginState {
+	switch {
+	case !s.NeedsAuth():
+		return LoggedIn
+	case s.Prefs.Valid() && s.Prefs.LoggedOut():
+		return LoggedOut
+	case (s.NetMap != nil) && s.NetMap.SelfNode.Valid() && s.NetMap.SelfNode.Expired():
+		return KeyExpired
+	case s.Prefs.Valid() && s.Prefs.Persist().Valid() && (s.Prefs.Persist().NodeID() != ""):
+		return KeyExpired
+	default:
+		return NeverLoggedIn
+	}
+}
+
+// PendingAuthURL returns the URL that the user needs to visit to
+// finish an interactive login that is in progress, if there is one.
+func (s *IPNStatus) PendingAuthURL() (string, bool) {
+	if !s.NeedsAuth() || (s.BrowseToURL == "") {
+		return "", false
+	}
+	return s.BrowseToURL, true
+}
+
 func (s *IPNStatus) ExitNodeActive() bool {
+	if !s.Prefs.Valid() {
+		return false
+	}
 	return s.Prefs.ExitNodeID() != "" || s.Prefs.ExitNodeIP().IsValid()
 }
 
+// FullTunnel returns true if all of the local node's traffic,
+// including traffic to the local network, is being sent through an
+// exit node.
+func (s *IPNStatus) FullTunnel() bool {
+	return s.ExitNodeActive() && !s.Prefs.ExitNodeAllowLANAccess()
+}
+
+// ClockSkew returns true if the health warnings suggest that the
+// system clock is wrong, which makes encrypted connections and logins
+// fail. The backend has no warning specifically for this, so it looks
+// for failed TLS connections caused by certificates that seem to have
+// expired or to not be valid yet, and for warnings about the clock.
+func (s *IPNStatus) ClockSkew() bool {
+	if s.Health == nil {
+		return false
+	}
+	for code, w := range s.Health.Warnings {
+		text := strings.ToLower(w.Text)
+		if (code == tsconst.HealthWarnableTLSConnectionFailed) && (strings.Contains(text, "not yet valid") || strings.Contains(text, "has expired")) {
+			return true
+		}
+		if strings.Contains(strings.ToLower(w.Title), "clock") || strings.Contains(text, "clock") {
+			return true
+		}
+	}
+	return false
+}
+
+// captivePortalWarnable is the code of the health warning that the
+// backend raises when it suspects that a captive portal is blocking
+// connectivity. It has no exported constant.
+const captivePortalWarnable health.WarnableCode = "captive-portal-detected"
+
+// CaptivePortal returns true if the health warnings suggest that a
+// captive portal, such as a hotel or airport Wi-Fi login page, is
+// blocking connectivity until the user signs in to it.
+func (s *IPNStatus) CaptivePortal() bool {
+	if s.Health == nil {
+		return false
+	}
+	_, ok := s.Health.Warnings[captivePortalWarnable]
+	return ok
+}
+
+// Throughput returns the rates, in bytes per second, at which data
+// was most recently received and sent over the tailnet. It returns
+// false if there haven't been enough engine updates to tell.
+func (s *IPNStatus) Throughput() (rx, tx float64, ok bool) {
+	if (s.Engine == nil) || (s.PrevEngine == nil) {
+		return 0, 0, false
+	}
+	d := s.EngineTime.Sub(s.PrevEngineTime).Seconds()
+	r, w := s.Engine.RBytes-s.PrevEngine.RBytes, s.Engine.WBytes-s.PrevEngine.WBytes
+	if (d <= 0) || (r < 0) || (w < 0) {
+		// The counters are reset if the daemon restarts.
+		return 0, 0, false
+	}
+	return float64(r) / d, float64(w) / d, true
+}
+
+// ExitNodeRouting returns whether any traffic was exchanged with the
+// current exit node between the two most recent engine updates,
+// meaning that traffic really is being routed through it rather than
+// the exit node merely being selected. It returns false for ok if no
+// exit node is in use or there haven't been enough engine updates to
+// tell.
+func (s *IPNStatus) ExitNodeRouting() (routing, ok bool) {
+	if !s.ExitNodeActive() || (s.Engine == nil) || (s.PrevEngine == nil) {
+		return false, false
+	}
+	node := s.ExitNode()
+	if !node.Valid() {
+		return false, false
+	}
+
+	cur, prev := s.Engine.LivePeers[node.Key()], s.PrevEngine.LivePeers[node.Key()]
+	if (cur.RxBytes < prev.RxBytes) || (cur.TxBytes < prev.TxBytes) {
+		// The counters are reset if the daemon restarts.
+		return false, false
+	}
+	return (cur.RxBytes > prev.RxBytes) || (cur.TxBytes > prev.TxBytes), true
+}
+
+// IncomingAllowed returns true if other devices on the tailnet may be
+// able to connect to the local node. If they can't, reason briefly
+// says why, such as "shields up".
+func (s *IPNStatus) IncomingAllowed() (allowed bool, reason string) {
+	if s.Prefs.Valid() && s.Prefs.ShieldsUp() {
+		return false, "shields up"
+	}
+	if (s.NetMap != nil) && (len(s.NetMap.PacketFilter) == 0) {
+		return false, "no access rules"
+	}
+	return true, ""
+}
+
+// AutoUpdateEnabled returns true if the local Tailscale client is
+// configured to install updates automatically.
+func (s *IPNStatus) AutoUpdateEnabled() bool {
+	return s.Prefs.Valid() && s.Prefs.AutoUpdate().Apply.EqualBool(true)
+}
+
+// UpdatePending returns true if a newer version of the local
+// Tailscale client is available and will be installed automatically,
+// along with that version.
+func (s *IPNStatus) UpdatePending() (string, bool) {
+	if !s.AutoUpdateEnabled() || (s.ClientUpdate == nil) || s.ClientUpdate.RunningLatest {
+		return "", false
+	}
+	return s.ClientUpdate.LatestVersion, s.ClientUpdate.LatestVersion != ""
+}
+
+// ServingAsExitNode returns true if the local node advertises itself
+// as an exit node and the control server has approved it, meaning
+// that other peers can route their traffic through it.
+func (s *IPNStatus) ServingAsExitNode() bool {
+	if !s.Prefs.Valid() || !s.Prefs.AdvertisesExitNode() {
+		return false
+	}
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
+		return false
+	}
+	return tsaddr.ContainsExitRoutes(s.NetMap.SelfNode.AllowedIPs())
+}
+
+// An AdvertisedRoute is a subnet route that the local node advertises
+// and whether or not the control server has approved it.
+type AdvertisedRoute struct {
+	Prefix   netip.Prefix
+	Approved bool
+}
+
+// SelfAdvertisedRoutes returns the subnet routes other than exit
+// routes that the local node advertises, sorted by prefix. Routes that
+// haven't been approved yet are advertised but can't be used by other
+// peers until an admin approves them.
+func (s *IPNStatus) SelfAdvertisedRoutes() []AdvertisedRoute {
+	if !s.Prefs.Valid() {
+		return nil
+	}
+
+	var approved []netip.Prefix
+	if (s.NetMap != nil) && s.NetMap.SelfNode.Valid() {
+		approved = s.NetMap.SelfNode.AllowedIPs().AsSlice()
+	}
+
+	var routes []AdvertisedRoute
+	for _, p := range s.Prefs.AdvertiseRoutes().All() {
+		if tsaddr.IsExitRoute(p) {
+			continue
+		}
+		routes = append(routes, AdvertisedRoute{
+			Prefix:   p,
+			Approved: slices.Contains(approved, p),
+		})
+	}
+	slices.SortFunc(routes, func(r, r2 AdvertisedRoute) int {
+		return xnetip.ComparePrefixes(r.Prefix, r2.Prefix)
+	})
+	return routes
+}
+
 func (s *IPNStatus) ExitNode() tailcfg.NodeView {
 	if node, ok := s.Peers[s.Prefs.ExitNodeID()]; ok {
 		return node
@@ -353,18 +682,174 @@ func (s *IPNStatus) ExitNode() tailcfg.NodeView {
 	return tailcfg.NodeView{}
 }
 
+// ExitNodeLocation returns the ISO 3166-1 alpha-2 country code of
+// the current exit node's location, or an empty string if there is no
+// exit node or it doesn't have any location data.
+func (s *IPNStatus) ExitNodeLocation() string {
+	node := s.ExitNode()
+	if !node.Valid() || !node.Hostinfo().Valid() {
+		return ""
+	}
+	loc := node.Hostinfo().Location()
+	if !loc.Valid() {
+		return ""
+	}
+	return loc.CountryCode()
+}
+
+// ExitNodePriority returns the priority that the exit node with the
+// given ID advertises in its location data, such as Mullvad exit nodes
+// do, for choosing between exit nodes in the same location. Higher is
+// preferred. It returns false if the node doesn't advertise one.
+// Nodes don't advertise their bandwidth, so there's no equivalent for
+// that.
+func (s *IPNStatus) ExitNodePriority(id tailcfg.StableNodeID) (int, bool) {
+	peer, ok := s.Peers[id]
+	if !ok || !peer.Hostinfo().Valid() {
+		return 0, false
+	}
+	loc := peer.Hostinfo().Location()
+	if !loc.Valid() || (loc.Priority() <= 0) {
+		return 0, false
+	}
+	return loc.Priority(), true
+}
+
+// An ExitNodeCountry is a group of exit nodes that are all in the
+// same country.
+type ExitNodeCountry struct {
+	Name   string // Name is the country's name, such as "Canada".
+	Code   string // Code is the country's ISO 3166-1 alpha-2 code.
+	Cities []ExitNodeCity
+}
+
+// An ExitNodeCity is a group of exit nodes that are all in the same
+// city.
+type ExitNodeCity struct {
+	Name  string
+	Nodes []tailcfg.NodeView
+}
+
+// ExitNodesByLocation returns the Mullvad exit nodes that have
+// location data grouped by country and then by city. Countries,
+// cities, and the nodes in each city are sorted.
+func (s *IPNStatus) ExitNodesByLocation() []ExitNodeCountry {
+	var peers []tailcfg.NodeView
+	for id, peer := range s.Peers {
+		if IsMullvad(peer) && HasLocation(peer) && s.PeerCaps(id).Has(PeerExitNode) {
+			peers = append(peers, peer)
+		}
+	}
+	slices.SortFunc(peers, ComparePeers)
+
+	var countries []ExitNodeCountry
+	for _, peer := range peers {
+		loc := peer.Hostinfo().Location()
+		if (len(countries) == 0) || (countries[len(countries)-1].Name != loc.Country()) {
+			countries = append(countries, ExitNodeCountry{Name: loc.Country(), Code: loc.CountryCode()})
+		}
+		country := &countries[len(countries)-1]
+
+		if (len(country.Cities) == 0) || (country.Cities[len(country.Cities)-1].Name != loc.City()) {
+			country.Cities = append(country.Cities, ExitNodeCity{Name: loc.City()})
+		}
+		city := &country.Cities[len(country.Cities)-1]
+		city.Nodes = append(city.Nodes, peer)
+	}
+	return countries
+}
+
+// SuggestedExitNode returns the peer that the backend suggests using
+// as an exit node. The returned node is invalid if there is no
+// suggestion or if the suggested node is not a known peer.
+func (s *IPNStatus) SuggestedExitNode() tailcfg.NodeView {
+	return s.Peers[s.SuggestedExitNodeID]
+}
+
+// ControlURL returns the URL of the control plane server that the
+// local node is configured to use.
+func (s *IPNStatus) ControlURL() string {
+	if s.Prefs.Valid() && (s.Prefs.ControlURL() != "") {
+		return s.Prefs.ControlURL()
+	}
+	return ipn.DefaultControlURL
+}
+
+// DNSSuffix returns the MagicDNS suffix of the tailnet, such as
+// "tail1234.ts.net". It returns an empty string if MagicDNS is
+// disabled.
+func (s *IPNStatus) DNSSuffix() string {
+	if (s.NetMap == nil) || !s.NetMap.DNS.Proxied {
+		return ""
+	}
+	return s.NetMap.MagicDNSSuffix()
+}
+
+// MagicDNSActive returns true if MagicDNS names can be resolved
+// locally. That requires both that MagicDNS is enabled for the tailnet
+// and that the local node is using Tailscale's DNS settings.
+func (s *IPNStatus) MagicDNSActive() bool {
+	return (s.DNSSuffix() != "") && s.Prefs.CorpDNS()
+}
+
+// TailnetLock returns whether tailnet lock is enabled for the tailnet
+// and, if it is, whether the local node's key has been signed by a
+// trusted key. Unsigned nodes can't communicate with the rest of the
+// tailnet until they are.
+func (s *IPNStatus) TailnetLock() (enabled, signed bool) {
+	if (s.NetMap == nil) || !s.NetMap.TKAEnabled {
+		return false, false
+	}
+	return true, s.NetMap.SelfNode.Valid() && (s.NetMap.SelfNode.KeySignature().Len() > 0)
+}
+
+// AdminURL returns the URL of the web-based admin console for the
+// control plane server in use. It returns false if the server isn't
+// known to have one, as is the case with Headscale.
+func (s *IPNStatus) AdminURL() (string, bool) {
+	if ipn.IsLoginServerSynonym(s.ControlURL()) {
+		return "https://login.tailscale.com/admin", true
+	}
+	return "", false
+}
+
+// SelfAdminURL returns the URL of the local node's page in the admin
+// console, where its routes can be approved. Like AdminURL, it returns
+// false if the control server isn't Tailscale's.
+func (s *IPNStatus) SelfAdminURL() (string, bool) {
+	admin, ok := s.AdminURL()
+	addr := s.SelfAddr()
+	if !ok || !addr.IsValid() {
+		return "", false
+	}
+	return admin + "/machines/" + addr.String(), true
+}
+
+// currentUser returns the user that the app is running as, which
+// can't change while it runs.
+var currentUser = sync.OnceValues(user.Current)
+
+// OperatorIsCurrent returns true if the user that the app is running
+// as can change Tailscale's settings. On Linux, only root and the user
+// set as the operator, such as with "tailscale set --operator=$USER",
+// can do so. Other platforms have no such restriction. It also returns
+// true if the daemon's prefs aren't known, so that nothing is disabled
+// without reason.
 func (s *IPNStatus) OperatorIsCurrent() bool {
-	current, err := user.Current()
+	if (runtime.GOOS != "linux") || !s.Prefs.Valid() {
+		return true
+	}
+
+	current, err := currentUser()
 	if err != nil {
 		slog.Error("get current user", "err", err)
 		return false
 	}
-
-	return s.Prefs.OperatorUser() == current.Username
+	return (current.Uid == "0") || (s.Prefs.OperatorUser() == current.Username)
 }
 
 func (s *IPNStatus) SelfAddr() netip.Addr {
-	if s.NetMap == nil {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
 		return netip.Addr{}
 	}
 
@@ -382,6 +867,441 @@ func (s *IPNStatus) SelfAddr() netip.Addr {
 	return addr.Addr()
 }
 
+// SelfAddrs returns all of the local node's Tailscale addresses, with
+// IPv4 addresses first. Most nodes have exactly one of each family,
+// but some setups assign more.
+func (s *IPNStatus) SelfAddrs() []netip.Addr {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, a := range s.NetMap.SelfNode.Addresses().All() {
+		if a.IsSingleIP() {
+			prefixes = append(prefixes, a)
+		}
+	}
+	slices.SortFunc(prefixes, xnetip.ComparePrefixes)
+
+	addrs := make([]netip.Addr, 0, len(prefixes))
+	for _, p := range prefixes {
+		addrs = append(addrs, p.Addr())
+	}
+	return addrs
+}
+
+// SelfAddr4 returns the local node's Tailscale IPv4 address. It
+// returns an invalid address if there isn't one.
+func (s *IPNStatus) SelfAddr4() netip.Addr {
+	return s.selfAddrMatching(netip.Addr.Is4)
+}
+
+// SelfAddr6 returns the local node's Tailscale IPv6 address. It
+// returns an invalid address if there isn't one.
+func (s *IPNStatus) SelfAddr6() netip.Addr {
+	return s.selfAddrMatching(netip.Addr.Is6)
+}
+
+// SelfDNSName returns the local node's fully-qualified MagicDNS name
+// without a trailing dot. It returns an empty string if it isn't
+// known.
+func (s *IPNStatus) SelfDNSName() string {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
+		return ""
+	}
+	return strings.TrimSuffix(s.NetMap.SelfNode.Name(), ".")
+}
+
+// SelfTags returns the ACL tags of the local node, such as
+// "tag:server". Tagged nodes are owned by their tags instead of by a
+// user and their keys don't expire. It returns nil if the node isn't
+// tagged or its tags aren't known.
+func (s *IPNStatus) SelfTags() []string {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
+		return nil
+	}
+	return s.NetMap.SelfNode.Tags().AsSlice()
+}
+
+// SelfOwner returns the login name of the user that owns the local
+// node. It returns an empty string if it isn't known, including for
+// tagged nodes, which no user owns.
+func (s *IPNStatus) SelfOwner() string {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() || s.NetMap.SelfNode.IsTagged() {
+		return ""
+	}
+	user, ok := s.NetMap.UserProfiles[s.NetMap.SelfNode.User()]
+	if !ok {
+		return ""
+	}
+	return user.LoginName()
+}
+
+// SelfOS returns the name of the local node's operating system as
+// reported to the control server. It returns an empty string if it
+// isn't known.
+func (s *IPNStatus) SelfOS() string {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() || !s.NetMap.SelfNode.Hostinfo().Valid() {
+		return ""
+	}
+	return s.NetMap.SelfNode.Hostinfo().OS()
+}
+
+// ClientVersion returns the version of the local Tailscale daemon as
+// reported to the control server. It returns an empty string if it
+// isn't known.
+func (s *IPNStatus) ClientVersion() string {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() || !s.NetMap.SelfNode.Hostinfo().Valid() {
+		return ""
+	}
+	return s.NetMap.SelfNode.Hostinfo().IPNVersion()
+}
+
+// DaemonVersionSupported returns false if the local Tailscale daemon
+// is known to be older than min, such as "1.80.0". Anything after the
+// version number itself, such as a commit hash, is ignored. It returns
+// true if min is empty or the daemon's version isn't known, so that an
+// unknown version isn't reported as a problem.
+func (s *IPNStatus) DaemonVersionSupported(min string) bool {
+	version, _, _ := strings.Cut(s.ClientVersion(), "-")
+	if (min == "") || (version == "") {
+		return true
+	}
+	return !cmpver.Less(version, min)
+}
+
+// DERPLatency is the latency from the local node to a DERP region.
+type DERPLatency struct {
+	RegionID int
+	Name     string
+	Latency  time.Duration
+
+	// Preferred is true if this is the local node's home region.
+	Preferred bool
+}
+
+// DERPLatencies returns the most recently measured latencies from the
+// local node to each DERP region, fastest first. A region's latency is
+// the lower of its IPv4 and IPv6 latencies. It returns nil if none
+// have been measured.
+func (s *IPNStatus) DERPLatencies() []DERPLatency {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() || !s.NetMap.SelfNode.Hostinfo().Valid() {
+		return nil
+	}
+	info := s.NetMap.SelfNode.Hostinfo().NetInfo()
+	if !info.Valid() {
+		return nil
+	}
+
+	latencies := make(map[int]time.Duration)
+	for key, seconds := range info.DERPLatency().All() {
+		// Keys are of the form "1-v4".
+		region, _, _ := strings.Cut(key, "-")
+		id, err := strconv.Atoi(region)
+		if err != nil {
+			continue
+		}
+		d := time.Duration(seconds * float64(time.Second))
+		if prev, ok := latencies[id]; !ok || (d < prev) {
+			latencies[id] = d
+		}
+	}
+
+	result := make([]DERPLatency, 0, len(latencies))
+	for id, d := range latencies {
+		result = append(result, DERPLatency{
+			RegionID:  id,
+			Name:      s.derpRegionName(id),
+			Latency:   d,
+			Preferred: id == info.PreferredDERP(),
+		})
+	}
+	slices.SortFunc(result, func(l1, l2 DERPLatency) int {
+		return cmp.Or(
+			cmp.Compare(l1.Latency, l2.Latency),
+			cmp.Compare(l1.RegionID, l2.RegionID),
+		)
+	})
+	return result
+}
+
+// IPFamilies reports which IP families the local node has working
+// internet connectivity over, going by its most recent network check.
+// A family counts as working if the check reached a DERP region over
+// it, or, for IPv6, if the check found IPv6 to work in general. It
+// returns false if no check has been reported yet.
+//
+// The backend has no preference for choosing between families. It
+// uses whichever paths work, so this is informational only.
+func (s *IPNStatus) IPFamilies() (v4, v6, ok bool) {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() || !s.NetMap.SelfNode.Hostinfo().Valid() {
+		return false, false, false
+	}
+	info := s.NetMap.SelfNode.Hostinfo().NetInfo()
+	if !info.Valid() {
+		return false, false, false
+	}
+
+	v6, _ = info.WorkingIPv6().Get()
+	for key := range info.DERPLatency().All() {
+		switch {
+		case strings.HasSuffix(key, "-v4"):
+			v4 = true
+		case strings.HasSuffix(key, "-v6"):
+			v6 = true
+		}
+	}
+	return v4, v6, true
+}
+
+// derpRegionName returns the human-readable name of the DERP region
+// with the given ID, falling back to its code or its ID if the DERP
+// map doesn't name it.
+func (s *IPNStatus) derpRegionName(id int) string {
+	if s.NetMap.DERPMap != nil {
+		if region, ok := s.NetMap.DERPMap.Regions[id]; ok && (region != nil) {
+			if name := cmp.Or(region.RegionName, region.RegionCode); name != "" {
+				return name
+			}
+		}
+	}
+	return fmt.Sprintf("Region %v", id)
+}
+
+func (s *IPNStatus) selfAddrMatching(f func(netip.Addr) bool) netip.Addr {
+	if (s.NetMap == nil) || !s.NetMap.SelfNode.Valid() {
+		return netip.Addr{}
+	}
+
+	for _, a := range s.NetMap.SelfNode.Addresses().All() {
+		if a.IsSingleIP() && f(a.Addr()) {
+			return a.Addr()
+		}
+	}
+	return netip.Addr{}
+}
+
+// PeerLastSeen returns the time at which the peer with the given ID
+// was last seen online. It returns false if the peer is unknown or
+// the time isn't known.
+func (s *IPNStatus) PeerLastSeen(id tailcfg.StableNodeID) (time.Time, bool) {
+	peer, ok := s.Peers[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	return peer.LastSeen().GetOk()
+}
+
+// PeerMagicDNSName returns the fully-qualified MagicDNS name of the
+// peer with the given ID without a trailing dot. It returns an empty
+// string if the peer is unknown or has no name.
+func (s *IPNStatus) PeerMagicDNSName(id tailcfg.StableNodeID) string {
+	peer, ok := s.Peers[id]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSuffix(peer.Name(), ".")
+}
+
+// PeerSSHCommand returns a command, such as "ssh laptop.tail1234.ts.net",
+// for connecting to the peer with the given ID via Tailscale SSH. It
+// returns an empty string if the peer doesn't run Tailscale SSH or has
+// no MagicDNS name.
+func (s *IPNStatus) PeerSSHCommand(id tailcfg.StableNodeID) string {
+	name := s.PeerMagicDNSName(id)
+	if (name == "") || !s.PeerCaps(id).Has(PeerSSH) {
+		return ""
+	}
+	return "ssh " + name
+}
+
+// webPorts are the TCP ports that are assumed to serve web content,
+// and the scheme to use for each, when listing a peer's services. The
+// backend only reports which ports a peer listens on, not what they
+// speak, so other ports aren't listed.
+var webPorts = map[uint16]string{
+	80:   "http",
+	443:  "https",
+	8080: "http",
+	8443: "https",
+}
+
+// PeerServiceURLs returns URLs, sorted by port, for the web services
+// that the peer with the given ID appears to offer, going by the
+// well-known web ports that it reports listening on. The peer's
+// services are only reported if the tailnet has service collection
+// enabled. It returns nil if the peer has no MagicDNS name that is a
+// valid host name.
+func (s *IPNStatus) PeerServiceURLs(id tailcfg.StableNodeID) []string {
+	peer, ok := s.Peers[id]
+	if !ok || !peer.Hostinfo().Valid() {
+		return nil
+	}
+	name := s.PeerMagicDNSName(id)
+	if (name == "") || (dnsname.ValidHostname(name) != nil) {
+		return nil
+	}
+
+	var ports []uint16
+	for _, service := range peer.Hostinfo().Services().All() {
+		if _, ok := webPorts[service.Port]; ok && (service.Proto == tailcfg.TCP) && !slices.Contains(ports, service.Port) {
+			ports = append(ports, service.Port)
+		}
+	}
+	slices.Sort(ports)
+
+	urls := make([]string, 0, len(ports))
+	for _, port := range ports {
+		u := url.URL{Scheme: webPorts[port], Host: name, Path: "/"}
+		if (port != 80) && (port != 443) {
+			u.Host = net.JoinHostPort(name, strconv.FormatUint(uint64(port), 10))
+		}
+		urls = append(urls, u.String())
+	}
+	return urls
+}
+
+// PeerCaps is a set of notable capabilities that a peer advertises.
+type PeerCaps uint
+
+const (
+	// PeerExitNode indicates that a peer can be used as an exit node.
+	PeerExitNode PeerCaps = 1 << iota
+
+	// PeerSubnetRouter indicates that a peer routes at least one
+	// subnet other than an exit route.
+	PeerSubnetRouter
+
+	// PeerTaildrop indicates that files can be sent to a peer via
+	// Taildrop.
+	PeerTaildrop
+
+	// PeerSSH indicates that a peer runs Tailscale SSH.
+	PeerSSH
+)
+
+var peerCapNames = []struct {
+	cap  PeerCaps
+	name string
+}{
+	{PeerExitNode, "exit node"},
+	{PeerSubnetRouter, "subnet"},
+	{PeerTaildrop, "Taildrop"},
+	{PeerSSH, "SSH"},
+}
+
+// Has returns true if caps contains all of the capabilities in c.
+func (caps PeerCaps) Has(c PeerCaps) bool {
+	return caps&c == c
+}
+
+// String returns a comma-separated list of short, human-readable
+// names of the capabilities in caps, such as "exit node, SSH".
+func (caps PeerCaps) String() string {
+	var names []string
+	for _, c := range peerCapNames {
+		if caps.Has(c.cap) {
+			names = append(names, c.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// PeerCaps classifies the capabilities advertised by the peer with
+// the given ID. It returns 0 if there is no such peer.
+func (s *IPNStatus) PeerCaps(id tailcfg.StableNodeID) PeerCaps {
+	peer, ok := s.Peers[id]
+	if !ok {
+		return 0
+	}
+
+	var caps PeerCaps
+	if tsaddr.ContainsExitRoutes(peer.AllowedIPs()) {
+		caps |= PeerExitNode
+	}
+	if tsaddr.ContainsNonExitSubnetRoutes(peer.PrimaryRoutes()) {
+		caps |= PeerSubnetRouter
+	}
+	if s.FileTargets.Contains(id) {
+		caps |= PeerTaildrop
+	}
+	if peer.Hostinfo().Valid() && (peer.Hostinfo().SSH_HostKeys().Len() > 0) {
+		caps |= PeerSSH
+	}
+	return caps
+}
+
+// RouterPeers returns the IDs of the peers that advertise subnet
+// routes other than exit routes.
+func (s *IPNStatus) RouterPeers() set.Set[tailcfg.StableNodeID] {
+	routers := make(set.Set[tailcfg.StableNodeID])
+	for id := range s.Peers {
+		if s.PeerCaps(id).Has(PeerSubnetRouter) {
+			routers.Add(id)
+		}
+	}
+	return routers
+}
+
+// A RouteConflict is a subnet that is covered by the subnet routes of
+// more than one peer.
+type RouteConflict struct {
+	Prefix netip.Prefix
+	Nodes  []tailcfg.StableNodeID
+}
+
+// RouteConflicts returns the subnets that are covered by the primary
+// routes of more than one peer, sorted by prefix. Each conflict is the
+// narrower of the overlapping routes, so a peer routing 10.0.0.0/16
+// and another routing 10.0.1.0/24 conflict over the latter. Exit
+// routes are ignored, and nil is returned if the local node isn't
+// accepting routes, as the routes have no effect on it then.
+func (s *IPNStatus) RouteConflicts() []RouteConflict {
+	if !s.Prefs.Valid() || !s.Prefs.RouteAll() {
+		return nil
+	}
+
+	type route struct {
+		prefix netip.Prefix
+		node   tailcfg.StableNodeID
+	}
+	var routes []route
+	for id, peer := range s.Peers {
+		for _, p := range peer.PrimaryRoutes().All() {
+			if tsaddr.IsExitRoute(p) {
+				continue
+			}
+			routes = append(routes, route{prefix: p.Masked(), node: id})
+		}
+	}
+
+	var conflicts []RouteConflict
+	seen := make(set.Set[netip.Prefix])
+	for _, r := range routes {
+		if seen.Contains(r.prefix) {
+			continue
+		}
+		seen.Add(r.prefix)
+
+		nodes := make(set.Set[tailcfg.StableNodeID])
+		for _, r2 := range routes {
+			if (r2.prefix.Bits() <= r.prefix.Bits()) && r2.prefix.Contains(r.prefix.Addr()) {
+				nodes.Add(r2.node)
+			}
+		}
+		if len(nodes) > 1 {
+			conflicts = append(conflicts, RouteConflict{
+				Prefix: r.prefix,
+				Nodes:  slices.Sorted(maps.Keys(nodes)),
+			})
+		}
+	}
+	slices.SortFunc(conflicts, func(c, c2 RouteConflict) int {
+		return xnetip.ComparePrefixes(c.Prefix, c2.Prefix)
+	})
+	return conflicts
+}
+
 type FileStatus struct {
 	Files []apitype.WaitingFile
 }
@@ -395,6 +1315,75 @@ type ProfileStatus struct {
 
 func (*ProfileStatus) status() {}
 
+// TailnetLockStatus is the state of tailnet lock as seen by the local
+// node.
+type TailnetLockStatus struct {
+	Lock *ipnstate.NetworkLockStatus
+}
+
+func (*TailnetLockStatus) status() {}
+
+// Signer returns true if the local node's tailnet lock key is one of
+// the trusted keys, meaning that it can sign other nodes.
+func (s *TailnetLockStatus) Signer() bool {
+	if (s.Lock == nil) || !s.Lock.Enabled {
+		return false
+	}
+	return slices.ContainsFunc(s.Lock.TrustedKeys, func(k ipnstate.TKAKey) bool {
+		return k.Key == s.Lock.PublicKey
+	})
+}
+
+// PendingNodes returns the peers that are waiting for their node keys
+// to be signed, sorted by name, if the local node can sign them. It
+// returns nil if it can't.
+func (s *TailnetLockStatus) PendingNodes() []*ipnstate.TKAPeer {
+	if !s.Signer() {
+		return nil
+	}
+
+	pending := slices.Clone(s.Lock.FilteredPeers)
+	slices.SortFunc(pending, func(p1, p2 *ipnstate.TKAPeer) int {
+		return cmp.Or(
+			cmp.Compare(p1.Name, p2.Name),
+			cmp.Compare(p1.StableID, p2.StableID),
+		)
+	})
+	return pending
+}
+
+// PeerPathStatus is how the local node is currently reaching the
+// peers that it has active connections to. The IPN bus doesn't report
+// this, so it is polled from the full status.
+type PeerPathStatus struct {
+	// Direct maps the ID of each peer with an active connection to
+	// whether it is reached directly rather than through a relay.
+	Direct map[tailcfg.StableNodeID]bool
+}
+
+func (*PeerPathStatus) status() {}
+
+// NewPeerPathStatus returns the paths to the active peers in st. A
+// peer is reached directly if it has a current endpoint address.
+func NewPeerPathStatus(st *ipnstate.Status) *PeerPathStatus {
+	direct := make(map[tailcfg.StableNodeID]bool)
+	for _, peer := range st.Peer {
+		if !peer.Active {
+			continue
+		}
+		direct[peer.ID] = peer.CurAddr != ""
+	}
+	return &PeerPathStatus{Direct: direct}
+}
+
+// PeerDirect returns whether the peer with the given ID is reached
+// directly. It returns false for ok if there is no active connection
+// to it.
+func (s *PeerPathStatus) PeerDirect(id tailcfg.StableNodeID) (direct, ok bool) {
+	direct, ok = s.Direct[id]
+	return direct, ok
+}
+
 type notifier struct {
 	notify chan struct{}
 	next   *notifier
@@ -0,0 +1,574 @@
+package tsutil_test
+
+import (
+	"net/netip"
+	"os/user"
+	"runtime"
+	"testing"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsconst"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/persist"
+)
+
+func TestAdminURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		controlURL string
+		admin      string
+	}{
+		{name: "Unset", controlURL: "", admin: "https://login.tailscale.com/admin"},
+		{name: "Default", controlURL: ipn.DefaultControlURL, admin: "https://login.tailscale.com/admin"},
+		{name: "Login", controlURL: "https://login.tailscale.com", admin: "https://login.tailscale.com/admin"},
+		{name: "Headscale", controlURL: "https://headscale.example.com", admin: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prefs := ipn.NewPrefs()
+			prefs.ControlURL = test.controlURL
+			status := &tsutil.IPNStatus{Prefs: prefs.View()}
+
+			admin, ok := status.AdminURL()
+			require.Equal(t, test.admin != "", ok)
+			require.Equal(t, test.admin, admin)
+		})
+	}
+}
+
+func TestSelfAdvertisedRoutes(t *testing.T) {
+	require.Nil(t, (&tsutil.IPNStatus{}).SelfAdvertisedRoutes())
+
+	prefs := ipn.NewPrefs()
+	prefs.AdvertiseRoutes = []netip.Prefix{
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("::/0"),
+	}
+	status := &tsutil.IPNStatus{
+		Prefs: prefs.View(),
+		NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+			Addresses:  []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32"), netip.MustParsePrefix("192.168.1.0/24")},
+		}).View()},
+	}
+	require.Equal(t, []tsutil.AdvertisedRoute{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/16"), Approved: false},
+		{Prefix: netip.MustParsePrefix("192.168.1.0/24"), Approved: true},
+	}, status.SelfAdvertisedRoutes())
+
+	url, ok := status.SelfAdminURL()
+	require.True(t, ok)
+	require.Equal(t, "https://login.tailscale.com/admin/machines/100.64.0.1", url)
+}
+
+func TestSelfAddrs(t *testing.T) {
+	require.Nil(t, (&tsutil.IPNStatus{}).SelfAddrs())
+
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+		Addresses: []netip.Prefix{
+			netip.MustParsePrefix("fd7a:115c:a1e0::1/128"),
+			netip.MustParsePrefix("100.64.0.2/32"),
+			netip.MustParsePrefix("10.1.0.0/16"),
+			netip.MustParsePrefix("100.64.0.1/32"),
+		},
+	}).View()}}
+	require.Equal(t, []netip.Addr{
+		netip.MustParseAddr("100.64.0.1"),
+		netip.MustParseAddr("100.64.0.2"),
+		netip.MustParseAddr("fd7a:115c:a1e0::1"),
+	}, status.SelfAddrs())
+}
+
+func TestDaemonVersionSupported(t *testing.T) {
+	status := &tsutil.IPNStatus{}
+	require.True(t, status.DaemonVersionSupported("1.80.0"))
+
+	status.NetMap = &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+		Hostinfo: (&tailcfg.Hostinfo{IPNVersion: "1.76.1-t77ae916c7-g6a1b2c3d4"}).View(),
+	}).View()}
+	require.True(t, status.DaemonVersionSupported(""))
+	require.True(t, status.DaemonVersionSupported("1.76.1"))
+	require.True(t, status.DaemonVersionSupported("1.9.0"))
+	require.False(t, status.DaemonVersionSupported("1.76.2"))
+	require.False(t, status.DaemonVersionSupported("1.80.0"))
+}
+
+func TestOperatorIsCurrent(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	require.True(t, (&tsutil.IPNStatus{}).OperatorIsCurrent())
+
+	prefs := ipn.NewPrefs()
+	prefs.OperatorUser = current.Username
+	status := &tsutil.IPNStatus{Prefs: prefs.View()}
+	require.True(t, status.OperatorIsCurrent())
+
+	prefs.OperatorUser = current.Username + "-other"
+	status.Prefs = prefs.View()
+	require.Equal(t, (runtime.GOOS != "linux") || (current.Uid == "0"), status.OperatorIsCurrent())
+}
+
+func TestDNSSuffix(t *testing.T) {
+	require.Empty(t, (&tsutil.IPNStatus{}).DNSSuffix())
+
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{Name: "self.tail1234.ts.net."}}
+	require.Empty(t, status.DNSSuffix())
+
+	status.NetMap.DNS = tailcfg.DNSConfig{Proxied: true}
+	require.Equal(t, "tail1234.ts.net", status.DNSSuffix())
+}
+
+func TestMagicDNSActive(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	status := &tsutil.IPNStatus{
+		Prefs:  prefs.View(),
+		NetMap: &netmap.NetworkMap{Name: "self.tail1234.ts.net."},
+	}
+	require.False(t, status.MagicDNSActive())
+
+	status.NetMap.DNS = tailcfg.DNSConfig{Proxied: true}
+	require.True(t, status.MagicDNSActive())
+
+	prefs.CorpDNS = false
+	status.Prefs = prefs.View()
+	require.False(t, status.MagicDNSActive())
+}
+
+func TestDERPLatencies(t *testing.T) {
+	require.Nil(t, (&tsutil.IPNStatus{}).DERPLatencies())
+
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+			PreferredDERP: 2,
+			DERPLatency: map[string]float64{
+				"1-v4":  0.050,
+				"1-v6":  0.040,
+				"2-v4":  0.012,
+				"3-v4":  0.090,
+				"bogus": 0.001,
+			},
+		}}).View()}).View(),
+		DERPMap: &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "nyc", RegionName: "New York City"},
+			2: {RegionID: 2, RegionCode: "fra"},
+		}},
+	}}
+
+	require.Equal(t, []tsutil.DERPLatency{
+		{RegionID: 2, Name: "fra", Latency: 12 * time.Millisecond, Preferred: true},
+		{RegionID: 1, Name: "New York City", Latency: 40 * time.Millisecond},
+		{RegionID: 3, Name: "Region 3", Latency: 90 * time.Millisecond},
+	}, status.DERPLatencies())
+}
+
+func TestIPFamilies(t *testing.T) {
+	_, _, ok := (&tsutil.IPNStatus{}).IPFamilies()
+	require.False(t, ok)
+
+	status := func(info *tailcfg.NetInfo) *tsutil.IPNStatus {
+		return &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: info}).View()}).View(),
+		}}
+	}
+
+	v4, v6, ok := status(&tailcfg.NetInfo{DERPLatency: map[string]float64{"1-v4": 0.01}}).IPFamilies()
+	require.True(t, ok)
+	require.True(t, v4)
+	require.False(t, v6)
+
+	v4, v6, _ = status(&tailcfg.NetInfo{DERPLatency: map[string]float64{"1-v4": 0.01, "2-v6": 0.02}}).IPFamilies()
+	require.True(t, v4)
+	require.True(t, v6)
+
+	v4, v6, _ = status(&tailcfg.NetInfo{WorkingIPv6: opt.NewBool(true)}).IPFamilies()
+	require.False(t, v4)
+	require.True(t, v6)
+}
+
+func TestTailnetLock(t *testing.T) {
+	enabled, _ := (&tsutil.IPNStatus{}).TailnetLock()
+	require.False(t, enabled)
+
+	status := &tsutil.IPNStatus{NetMap: &netmap.NetworkMap{
+		TKAEnabled: true,
+		SelfNode:   (&tailcfg.Node{}).View(),
+	}}
+	enabled, signed := status.TailnetLock()
+	require.True(t, enabled)
+	require.False(t, signed)
+
+	status.NetMap.SelfNode = (&tailcfg.Node{KeySignature: []byte("sig")}).View()
+	_, signed = status.TailnetLock()
+	require.True(t, signed)
+}
+
+func TestPendingNodes(t *testing.T) {
+	self := key.NewNLPrivate().Public()
+	other := key.NewNLPrivate().Public()
+	pending := []*ipnstate.TKAPeer{
+		{Name: "phone.example.ts.net.", StableID: "phone"},
+		{Name: "laptop.example.ts.net.", StableID: "laptop"},
+	}
+
+	status := &tsutil.TailnetLockStatus{Lock: &ipnstate.NetworkLockStatus{
+		Enabled:       true,
+		PublicKey:     self,
+		TrustedKeys:   []ipnstate.TKAKey{{Key: other}},
+		FilteredPeers: pending,
+	}}
+	require.False(t, status.Signer())
+	require.Nil(t, status.PendingNodes())
+
+	status.Lock.TrustedKeys = append(status.Lock.TrustedKeys, ipnstate.TKAKey{Key: self})
+	require.True(t, status.Signer())
+	require.Equal(t, []*ipnstate.TKAPeer{pending[1], pending[0]}, status.PendingNodes())
+	require.Equal(t, "phone", string(pending[0].StableID))
+
+	require.False(t, (&tsutil.TailnetLockStatus{}).Signer())
+}
+
+func TestPeerServiceURLs(t *testing.T) {
+	services := []tailcfg.Service{
+		{Proto: tailcfg.TCP, Port: 8443},
+		{Proto: tailcfg.TCP, Port: 22},
+		{Proto: tailcfg.TCP, Port: 80},
+		{Proto: tailcfg.UDP, Port: 443},
+		{Proto: tailcfg.TCP, Port: 443},
+		{Proto: tailcfg.TCP, Port: 80},
+	}
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"server": (&tailcfg.Node{
+			Name:     "server.example.ts.net.",
+			Hostinfo: (&tailcfg.Hostinfo{Services: services}).View(),
+		}).View(),
+		"bogus": (&tailcfg.Node{
+			Name:     "bad_name/evil.example.ts.net.",
+			Hostinfo: (&tailcfg.Hostinfo{Services: services}).View(),
+		}).View(),
+		"quiet": (&tailcfg.Node{
+			Name:     "quiet.example.ts.net.",
+			Hostinfo: (&tailcfg.Hostinfo{}).View(),
+		}).View(),
+	}}
+
+	require.Equal(t, []string{
+		"http://server.example.ts.net/",
+		"https://server.example.ts.net/",
+		"https://server.example.ts.net:8443/",
+	}, status.PeerServiceURLs("server"))
+	require.Nil(t, status.PeerServiceURLs("bogus"))
+	require.Empty(t, status.PeerServiceURLs("quiet"))
+	require.Nil(t, status.PeerServiceURLs("missing"))
+}
+
+func TestPeerCaps(t *testing.T) {
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"ssh": (&tailcfg.Node{
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+			Hostinfo:   (&tailcfg.Hostinfo{SSH_HostKeys: []string{"ssh-ed25519 AAAA"}}).View(),
+		}).View(),
+		"bare": (&tailcfg.Node{}).View(),
+	}}
+
+	require.Equal(t, tsutil.PeerExitNode|tsutil.PeerSSH, status.PeerCaps("ssh"))
+	require.Zero(t, status.PeerCaps("bare"))
+	require.Zero(t, status.PeerCaps("missing"))
+}
+
+func TestExitNodePriority(t *testing.T) {
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"ranked":   (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{Location: &tailcfg.Location{City: "New York", Priority: 100}}).View()}).View(),
+		"unranked": (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{Location: &tailcfg.Location{City: "New York"}}).View()}).View(),
+		"nowhere":  (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{}).View()}).View(),
+	}}
+
+	priority, ok := status.ExitNodePriority("ranked")
+	require.True(t, ok)
+	require.Equal(t, 100, priority)
+	_, ok = status.ExitNodePriority("unranked")
+	require.False(t, ok)
+	_, ok = status.ExitNodePriority("nowhere")
+	require.False(t, ok)
+	_, ok = status.ExitNodePriority("missing")
+	require.False(t, ok)
+}
+
+func TestServingAsExitNode(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	status := &tsutil.IPNStatus{
+		Prefs:  prefs.View(),
+		NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{}).View()},
+	}
+	require.False(t, status.ServingAsExitNode())
+
+	prefs.SetAdvertiseExitNode(true)
+	status.Prefs = prefs.View()
+	require.False(t, status.ServingAsExitNode())
+
+	status.NetMap.SelfNode = (&tailcfg.Node{AllowedIPs: tsaddr.ExitRoutes()}).View()
+	require.True(t, status.ServingAsExitNode())
+}
+
+func TestUpdatePending(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	status := &tsutil.IPNStatus{
+		Prefs:        prefs.View(),
+		ClientUpdate: &tailcfg.ClientVersion{LatestVersion: "1.78.0"},
+	}
+	_, ok := status.UpdatePending()
+	require.False(t, ok)
+
+	prefs.AutoUpdate.Apply.Set(true)
+	status.Prefs = prefs.View()
+	require.True(t, status.AutoUpdateEnabled())
+	version, ok := status.UpdatePending()
+	require.True(t, ok)
+	require.Equal(t, "1.78.0", version)
+
+	status.ClientUpdate.RunningLatest = true
+	_, ok = status.UpdatePending()
+	require.False(t, ok)
+}
+
+func mullvadNode(id tailcfg.StableNodeID, country, code, city string) tailcfg.NodeView {
+	return (&tailcfg.Node{
+		StableID:   id,
+		Tags:       []string{"tag:mullvad-exit-node"},
+		AllowedIPs: tsaddr.ExitRoutes(),
+		Hostinfo: (&tailcfg.Hostinfo{
+			Hostname: string(id),
+			Location: &tailcfg.Location{Country: country, CountryCode: code, City: city},
+		}).View(),
+	}).View()
+}
+
+func TestExitNodesByLocation(t *testing.T) {
+	status := &tsutil.IPNStatus{Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+		"ca-tor-2": mullvadNode("ca-tor-2", "Canada", "CA", "Toronto"),
+		"ca-tor-1": mullvadNode("ca-tor-1", "Canada", "CA", "Toronto"),
+		"ca-van-1": mullvadNode("ca-van-1", "Canada", "CA", "Vancouver"),
+		"se-sto-1": mullvadNode("se-sto-1", "Sweden", "SE", "Stockholm"),
+		"unknown":  mullvadNode("unknown", "", "", ""),
+		"laptop":   (&tailcfg.Node{StableID: "laptop", Hostinfo: (&tailcfg.Hostinfo{}).View()}).View(),
+	}}
+
+	countries := status.ExitNodesByLocation()
+	require.Len(t, countries, 2)
+	require.Equal(t, "Canada", countries[0].Name)
+	require.Equal(t, "CA", countries[0].Code)
+	require.Len(t, countries[0].Cities, 2)
+	require.Equal(t, "Toronto", countries[0].Cities[0].Name)
+	require.Len(t, countries[0].Cities[0].Nodes, 2)
+	require.EqualValues(t, "ca-tor-1", countries[0].Cities[0].Nodes[0].StableID())
+	require.Equal(t, "Vancouver", countries[0].Cities[1].Name)
+	require.Equal(t, "Sweden", countries[1].Name)
+
+	require.Empty(t, (&tsutil.IPNStatus{}).ExitNodesByLocation())
+}
+
+func TestPendingAuthURL(t *testing.T) {
+	status := &tsutil.IPNStatus{State: ipn.NeedsLogin}
+	_, ok := status.PendingAuthURL()
+	require.False(t, ok)
+
+	status.BrowseToURL = "https://login.tailscale.com/a/1234"
+	url, ok := status.PendingAuthURL()
+	require.True(t, ok)
+	require.Equal(t, "https://login.tailscale.com/a/1234", url)
+
+	status.State = ipn.Running
+	_, ok = status.PendingAuthURL()
+	require.False(t, ok)
+}
+
+func TestThroughput(t *testing.T) {
+	now := time.Now()
+	status := &tsutil.IPNStatus{Engine: &ipn.EngineStatus{RBytes: 1000, WBytes: 500}, EngineTime: now}
+	_, _, ok := status.Throughput()
+	require.False(t, ok)
+
+	status.PrevEngine, status.PrevEngineTime = status.Engine, now
+	status.Engine = &ipn.EngineStatus{RBytes: 5000, WBytes: 1500}
+	status.EngineTime = now.Add(2 * time.Second)
+	rx, tx, ok := status.Throughput()
+	require.True(t, ok)
+	require.Equal(t, 2000.0, rx)
+	require.Equal(t, 500.0, tx)
+
+	status.Engine = &ipn.EngineStatus{}
+	_, _, ok = status.Throughput()
+	require.False(t, ok)
+}
+
+func TestExitNodeRouting(t *testing.T) {
+	exitKey := key.NewNode().Public()
+	prefs := ipn.NewPrefs()
+	engine := func(rx, tx int64) *ipn.EngineStatus {
+		return &ipn.EngineStatus{LivePeers: map[key.NodePublic]ipnstate.PeerStatusLite{
+			exitKey: {NodeKey: exitKey, RxBytes: rx, TxBytes: tx},
+		}}
+	}
+	status := &tsutil.IPNStatus{
+		Prefs:      prefs.View(),
+		Peers:      map[tailcfg.StableNodeID]tailcfg.NodeView{"exit": (&tailcfg.Node{StableID: "exit", Key: exitKey}).View()},
+		Engine:     engine(100, 100),
+		PrevEngine: engine(100, 100),
+	}
+	_, ok := status.ExitNodeRouting()
+	require.False(t, ok)
+
+	prefs.ExitNodeID = "exit"
+	status.Prefs = prefs.View()
+	routing, ok := status.ExitNodeRouting()
+	require.True(t, ok)
+	require.False(t, routing)
+
+	status.Engine = engine(5000, 100)
+	routing, ok = status.ExitNodeRouting()
+	require.True(t, ok)
+	require.True(t, routing)
+
+	status.Engine = engine(0, 0)
+	_, ok = status.ExitNodeRouting()
+	require.False(t, ok)
+
+	status.PrevEngine = nil
+	_, ok = status.ExitNodeRouting()
+	require.False(t, ok)
+}
+
+func TestPeerPathStatus(t *testing.T) {
+	status := tsutil.NewPeerPathStatus(&ipnstate.Status{Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+		key.NewNode().Public(): {ID: "direct", Active: true, CurAddr: "203.0.113.1:41641", Relay: "nyc"},
+		key.NewNode().Public(): {ID: "relayed", Active: true, Relay: "nyc"},
+		key.NewNode().Public(): {ID: "idle", Relay: "nyc"},
+	}})
+
+	direct, ok := status.PeerDirect("direct")
+	require.True(t, ok)
+	require.True(t, direct)
+	direct, ok = status.PeerDirect("relayed")
+	require.True(t, ok)
+	require.False(t, direct)
+	_, ok = status.PeerDirect("idle")
+	require.False(t, ok)
+}
+
+func TestSelfIdentity(t *testing.T) {
+	status := &tsutil.IPNStatus{}
+	require.Nil(t, status.SelfTags())
+	require.Empty(t, status.SelfOwner())
+
+	status.NetMap = &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{User: 1}).View(),
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfileView{
+			1: (&tailcfg.UserProfile{LoginName: "user@example.com"}).View(),
+		},
+	}
+	require.Nil(t, status.SelfTags())
+	require.Equal(t, "user@example.com", status.SelfOwner())
+
+	status.NetMap.SelfNode = (&tailcfg.Node{User: 1, Tags: []string{"tag:server", "tag:ci"}}).View()
+	require.Equal(t, []string{"tag:server", "tag:ci"}, status.SelfTags())
+	require.Empty(t, status.SelfOwner())
+}
+
+func TestLoginState(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	require.Equal(t, tsutil.LoggedIn, (&tsutil.IPNStatus{State: ipn.Running, Prefs: prefs.View()}).LoginState())
+	require.Equal(t, tsutil.NeverLoggedIn, (&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()}).LoginState())
+
+	prefs.Persist = &persist.Persist{NodeID: "self"}
+	require.Equal(t, tsutil.KeyExpired, (&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()}).LoginState())
+
+	prefs.LoggedOut = true
+	require.Equal(t, tsutil.LoggedOut, (&tsutil.IPNStatus{State: ipn.NeedsLogin, Prefs: prefs.View()}).LoginState())
+
+	status := &tsutil.IPNStatus{
+		State:  ipn.NeedsLogin,
+		Prefs:  ipn.NewPrefs().View(),
+		NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{Expired: true}).View()},
+	}
+	require.Equal(t, tsutil.KeyExpired, status.LoginState())
+}
+
+func TestRouteConflicts(t *testing.T) {
+	router := func(id tailcfg.StableNodeID, routes ...string) tailcfg.NodeView {
+		var prefixes []netip.Prefix
+		for _, r := range routes {
+			prefixes = append(prefixes, netip.MustParsePrefix(r))
+		}
+		return (&tailcfg.Node{StableID: id, PrimaryRoutes: prefixes}).View()
+	}
+
+	prefs := ipn.NewPrefs()
+	prefs.RouteAll = true
+	status := &tsutil.IPNStatus{
+		Prefs: prefs.View(),
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"a": router("a", "10.0.0.0/16", "0.0.0.0/0", "::/0"),
+			"b": router("b", "10.0.1.0/24"),
+			"c": router("c", "10.0.1.0/24", "192.168.1.0/24", "0.0.0.0/0"),
+			"d": router("d", "192.168.2.0/24"),
+		},
+	}
+	require.Equal(t, []tsutil.RouteConflict{
+		{Prefix: netip.MustParsePrefix("10.0.1.0/24"), Nodes: []tailcfg.StableNodeID{"a", "b", "c"}},
+	}, status.RouteConflicts())
+
+	status.Peers["d"] = router("d", "192.168.1.128/25")
+	require.Equal(t, []tsutil.RouteConflict{
+		{Prefix: netip.MustParsePrefix("10.0.1.0/24"), Nodes: []tailcfg.StableNodeID{"a", "b", "c"}},
+		{Prefix: netip.MustParsePrefix("192.168.1.128/25"), Nodes: []tailcfg.StableNodeID{"c", "d"}},
+	}, status.RouteConflicts())
+
+	prefs.RouteAll = false
+	status.Prefs = prefs.View()
+	require.Nil(t, status.RouteConflicts())
+}
+
+func TestCaptivePortal(t *testing.T) {
+	status := &tsutil.IPNStatus{}
+	require.False(t, status.CaptivePortal())
+
+	status.Health = &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+		tsconst.HealthWarnableTLSConnectionFailed: {Text: "connection refused"},
+	}}
+	require.False(t, status.CaptivePortal())
+
+	status.Health.Warnings["captive-portal-detected"] = health.UnhealthyState{Title: "Captive portal detected"}
+	require.True(t, status.CaptivePortal())
+}
+
+func TestClockSkew(t *testing.T) {
+	status := &tsutil.IPNStatus{}
+	require.False(t, status.ClockSkew())
+
+	status.Health = &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+		tsconst.HealthWarnableTLSConnectionFailed: {Text: "Tailscale could not establish an encrypted connection with '\"controlplane.tailscale.com\"': connection refused"},
+	}}
+	require.False(t, status.ClockSkew())
+
+	status.Health = &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+		tsconst.HealthWarnableTLSConnectionFailed: {Text: "x509: certificate has expired or is not yet valid"},
+	}}
+	require.True(t, status.ClockSkew())
+
+	status.Health = &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+		"control-health.clock": {Title: "System clock is wrong"},
+	}}
+	require.True(t, status.ClockSkew())
+}
@@ -0,0 +1,104 @@
+package tsutil
+
+import (
+	"time"
+)
+
+// QualityFactors are the measurements that a connection quality score
+// is calculated from. See [QualityFactors.Score].
+type QualityFactors struct {
+	// Latency is the latency to the home DERP region, or zero if it
+	// hasn't been measured.
+	Latency time.Duration
+
+	// Relayed is true if UDP doesn't work, meaning that no direct
+	// connections to peers are possible and all traffic has to be
+	// relayed via DERP.
+	Relayed bool
+
+	// HardNAT is true if the NAT's mappings vary by destination,
+	// which makes direct connections to many peers unlikely.
+	HardNAT bool
+
+	// Warnings is the number of health warnings that don't affect
+	// connectivity and ConnectivityWarnings is the number that do.
+	Warnings             int
+	ConnectivityWarnings int
+}
+
+// Score returns a connection quality score from 0 to 100. A score
+// starts at 100 and has deducted from it:
+//
+//   - 1 point for every full 5ms of latency over 20ms, up to 40
+//   - 30 points if traffic has to be relayed
+//   - 10 points for a hard NAT
+//   - 20 points for each warning that affects connectivity and 5 for
+//     each other warning, up to 40 in total
+//
+// The result is never less than 0.
+func (f QualityFactors) Score() int {
+	score := 100
+
+	if over := f.Latency - 20*time.Millisecond; over > 0 {
+		score -= min(int(over/(5*time.Millisecond)), 40)
+	}
+	if f.Relayed {
+		score -= 30
+	}
+	if f.HardNAT {
+		score -= 10
+	}
+	score -= min(20*f.ConnectivityWarnings+5*f.Warnings, 40)
+
+	return max(score, 0)
+}
+
+// QualityFactors returns the measurements from the status that are
+// relevant to the quality of the connection to the tailnet. It
+// returns false if the local node isn't online.
+func (s *IPNStatus) QualityFactors() (QualityFactors, bool) {
+	if !s.Online() {
+		return QualityFactors{}, false
+	}
+
+	var f QualityFactors
+	for _, l := range s.DERPLatencies() {
+		if l.Preferred {
+			f.Latency = l.Latency
+			break
+		}
+	}
+
+	if (s.NetMap != nil) && s.NetMap.SelfNode.Valid() && s.NetMap.SelfNode.Hostinfo().Valid() {
+		if info := s.NetMap.SelfNode.Hostinfo().NetInfo(); info.Valid() {
+			udp, ok := info.WorkingUDP().Get()
+			f.Relayed = ok && !udp
+			f.HardNAT, _ = info.MappingVariesByDestIP().Get()
+		}
+	}
+
+	if s.Health != nil {
+		for _, w := range s.Health.Warnings {
+			if w.ImpactsConnectivity {
+				f.ConnectivityWarnings++
+				continue
+			}
+			f.Warnings++
+		}
+	}
+
+	return f, true
+}
+
+// ConnectionQuality returns a score from 0 to 100 that summarizes how
+// good the connection to the tailnet is, combining latency, whether
+// direct connections are possible, and health warnings. See
+// [QualityFactors.Score] for how it is calculated. It returns false
+// if the local node isn't online.
+func (s *IPNStatus) ConnectionQuality() (int, bool) {
+	f, ok := s.QualityFactors()
+	if !ok {
+		return 0, false
+	}
+	return f.Score(), true
+}
@@ -0,0 +1,78 @@
+package tsutil_test
+
+import (
+	"testing"
+	"time"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/opt"
+)
+
+func TestQualityScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors tsutil.QualityFactors
+		score   int
+	}{
+		{"perfect", tsutil.QualityFactors{}, 100},
+		{"low latency", tsutil.QualityFactors{Latency: 20 * time.Millisecond}, 100},
+		{"moderate latency", tsutil.QualityFactors{Latency: 72 * time.Millisecond}, 90},
+		{"high latency", tsutil.QualityFactors{Latency: time.Second}, 60},
+		{"relayed", tsutil.QualityFactors{Relayed: true}, 70},
+		{"hard NAT", tsutil.QualityFactors{HardNAT: true}, 90},
+		{"warnings", tsutil.QualityFactors{Warnings: 2, ConnectivityWarnings: 1}, 70},
+		{"many warnings", tsutil.QualityFactors{ConnectivityWarnings: 5}, 60},
+		{"everything", tsutil.QualityFactors{Latency: time.Second, Relayed: true, HardNAT: true, ConnectivityWarnings: 3}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.score, test.factors.Score())
+		})
+	}
+}
+
+func TestConnectionQuality(t *testing.T) {
+	_, ok := (&tsutil.IPNStatus{State: ipn.Stopped}).ConnectionQuality()
+	require.False(t, ok)
+
+	status := &tsutil.IPNStatus{
+		State: ipn.Running,
+		NetMap: &netmap.NetworkMap{
+			SelfNode: (&tailcfg.Node{Hostinfo: (&tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+				PreferredDERP:         2,
+				WorkingUDP:            opt.NewBool(false),
+				MappingVariesByDestIP: opt.NewBool(true),
+				DERPLatency: map[string]float64{
+					"1-v4": 0.010,
+					"2-v4": 0.045,
+				},
+			}}).View()}).View(),
+		},
+		Health: &health.State{Warnings: map[health.WarnableCode]health.UnhealthyState{
+			"a": {ImpactsConnectivity: true},
+			"b": {},
+		}},
+	}
+
+	f, ok := status.QualityFactors()
+	require.True(t, ok)
+	require.Equal(t, tsutil.QualityFactors{
+		Latency:              45 * time.Millisecond,
+		Relayed:              true,
+		HardNAT:              true,
+		Warnings:             1,
+		ConnectivityWarnings: 1,
+	}, f)
+
+	score, ok := status.ConnectionQuality()
+	require.True(t, ok)
+	require.Equal(t, 30, score)
+
+	score, _ = (&tsutil.IPNStatus{State: ipn.Running}).ConnectionQuality()
+	require.Equal(t, 100, score)
+}
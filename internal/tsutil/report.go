@@ -0,0 +1,126 @@
+package tsutil
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+// StatusReport returns a multi-line, human-readable summary of s
+// that is suitable for attaching to support requests. The output is
+// deterministic for a given status.
+func (s *IPNStatus) StatusReport() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "State: %v\n", s.State)
+
+	if addr := s.SelfAddr(); addr.IsValid() {
+		fmt.Fprintf(&b, "This machine: %v (%v)\n", s.NetMap.SelfNode.DisplayName(true), addr)
+	} else {
+		fmt.Fprintf(&b, "This machine: not connected\n")
+	}
+
+	exit := "none"
+	if s.ExitNodeActive() {
+		exit = cmp.Or(s.exitNodeName(), "unknown")
+	}
+	fmt.Fprintf(&b, "Exit node: %v\n", exit)
+
+	peers := slices.SortedFunc(maps.Values(s.Peers), ComparePeers)
+	fmt.Fprintf(&b, "Peers: %v\n", len(peers))
+	for _, peer := range peers {
+		online := "offline"
+		if peer.Online().Get() {
+			online = "online"
+		}
+		fmt.Fprintf(&b, "  %v (%v) %v", peer.DisplayName(true), peerAddr(peer), online)
+		if caps := s.PeerCaps(peer.StableID()); caps != 0 {
+			fmt.Fprintf(&b, " [%v]", caps)
+		}
+		b.WriteByte('\n')
+	}
+
+	if (s.Health == nil) || (len(s.Health.Warnings) == 0) {
+		fmt.Fprintf(&b, "Health: OK\n")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Health: %v warning(s)\n", len(s.Health.Warnings))
+	codes := slices.Sorted(maps.Keys(s.Health.Warnings))
+	for _, code := range codes {
+		w := s.Health.Warnings[code]
+		fmt.Fprintf(&b, "  %v: %v\n", w.Title, w.Text)
+	}
+
+	return b.String()
+}
+
+// exitNodeName returns the display name of the exit node in use, or
+// an empty string if there isn't one or it isn't known.
+func (s *IPNStatus) exitNodeName() string {
+	if !s.ExitNodeActive() {
+		return ""
+	}
+	if node := s.ExitNode(); node.Valid() {
+		return node.DisplayName(true)
+	}
+	return ""
+}
+
+// StatusSummary is a machine-readable summary of an IPNStatus. It
+// holds a subset of the information in [IPNStatus.StatusReport].
+type StatusSummary struct {
+	State  string `json:"state"`
+	Online bool   `json:"online"`
+
+	// ExitNode is the display name of the exit node in use. It is
+	// empty if there isn't one or it isn't known, in which case
+	// ExitNodeActive tells them apart.
+	ExitNode       string `json:"exitNode"`
+	ExitNodeActive bool   `json:"exitNodeActive"`
+
+	Peers       int `json:"peers"`
+	OnlinePeers int `json:"onlinePeers"`
+
+	// Health holds the titles of any health warnings, sorted by
+	// warning code. It is empty if everything is OK.
+	Health []string `json:"health"`
+}
+
+// Summary returns a summary of s.
+func (s *IPNStatus) Summary() StatusSummary {
+	summary := StatusSummary{
+		State:          s.State.String(),
+		Online:         s.Online(),
+		ExitNode:       s.exitNodeName(),
+		ExitNodeActive: s.ExitNodeActive(),
+		Peers:          len(s.Peers),
+		Health:         []string{},
+	}
+	for _, peer := range s.Peers {
+		if peer.Online().Get() {
+			summary.OnlinePeers++
+		}
+	}
+	if s.Health != nil {
+		codes := slices.Sorted(maps.Keys(s.Health.Warnings))
+		for _, code := range codes {
+			summary.Health = append(summary.Health, s.Health.Warnings[code].Title)
+		}
+	}
+	return summary
+}
+
+// peerAddr returns the primary address of peer as a string, or "no
+// address" if it doesn't have one.
+func peerAddr(peer tailcfg.NodeView) string {
+	addrs := peer.Addresses()
+	if addrs.Len() == 0 {
+		return "no address"
+	}
+	return addrs.At(0).Addr().String()
+}
@@ -0,0 +1,77 @@
+package tsutil_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"deedles.dev/trayscale/internal/tsutil"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+)
+
+func TestStatusReport(t *testing.T) {
+	self := (&tailcfg.Node{
+		Name:                 "self.example.ts.net.",
+		ComputedNameWithHost: "self",
+		Addresses:            []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+	}).View()
+	laptop := (&tailcfg.Node{
+		StableID:             "laptop",
+		Name:                 "laptop.example.ts.net.",
+		ComputedNameWithHost: "laptop",
+		Addresses:            []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+		Online:               ptr.To(true),
+		Hostinfo:             (&tailcfg.Hostinfo{Hostname: "laptop", SSH_HostKeys: []string{"key"}}).View(),
+	}).View()
+	server := (&tailcfg.Node{
+		StableID:             "server",
+		Name:                 "server.example.ts.net.",
+		ComputedNameWithHost: "server",
+		Addresses:            []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")},
+		AllowedIPs:           []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+		Hostinfo:             (&tailcfg.Hostinfo{Hostname: "server"}).View(),
+	}).View()
+
+	status := tsutil.IPNStatus{
+		State:  ipn.Running,
+		Prefs:  (&ipn.Prefs{ExitNodeID: "server"}).View(),
+		NetMap: &netmap.NetworkMap{SelfNode: self},
+		Peers: map[tailcfg.StableNodeID]tailcfg.NodeView{
+			"laptop": laptop,
+			"server": server,
+		},
+		Health: &health.State{
+			Warnings: map[health.WarnableCode]health.UnhealthyState{
+				"b": {Title: "Second", Text: "Something else is wrong."},
+				"a": {Title: "First", Text: "Something is wrong."},
+			},
+		},
+	}
+
+	const expected = `State: Running
+This machine: self (100.64.0.1)
+Exit node: server
+Peers: 2
+  laptop (100.64.0.2) online [SSH]
+  server (100.64.0.3) offline [exit node]
+Health: 2 warning(s)
+  First: Something is wrong.
+  Second: Something else is wrong.
+`
+	require.Equal(t, expected, status.StatusReport())
+	require.Equal(t, status.StatusReport(), status.StatusReport())
+
+	require.Equal(t, tsutil.StatusSummary{
+		State:          "Running",
+		Online:         true,
+		ExitNode:       "server",
+		ExitNodeActive: true,
+		Peers:          2,
+		OnlinePeers:    1,
+		Health:         []string{"First", "Second"},
+	}, status.Summary())
+}
@@ -32,6 +32,12 @@ func IsMullvad(peer tailcfg.NodeView) bool {
 	})
 }
 
+// HasLocation returns true if peer has location data that includes at
+// least its country.
+func HasLocation(peer tailcfg.NodeView) bool {
+	return peer.Hostinfo().Valid() && peer.Hostinfo().Location().Valid() && (peer.Hostinfo().Location().Country() != "")
+}
+
 // CanMullvad returns true if peer is allowed to access Mullvad exit
 // nodes.
 func CanMullvad(peer tailcfg.NodeView) bool {
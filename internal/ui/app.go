@@ -1,12 +1,15 @@
 package ui
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
 	"slices"
 	"time"
 
@@ -22,11 +25,17 @@ import (
 	"github.com/inhies/go-bytesize"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 )
 
 //go:embed app.css
 var appCSS string
 
+// minDaemonVersion is the oldest version of tailscaled that the tray
+// doesn't warn about. Older daemons lack parts of the LocalAPI that
+// the app relies on.
+const minDaemonVersion = "1.80.0"
+
 // App is the main type for the app, containing all of the state
 // necessary to run it.
 type App struct {
@@ -125,7 +134,7 @@ func (a *App) update(status tsutil.Status) {
 		}
 
 		if a.tray != nil {
-			a.tray.Update(status)
+			a.tray.UpdateIPN(status)
 		}
 
 		if a.win != nil {
@@ -143,11 +152,29 @@ func (a *App) update(status tsutil.Status) {
 		}
 		a.files = &status.Files
 
+		if a.tray != nil {
+			a.tray.Update(status)
+		}
+
 		if a.win != nil {
 			a.win.Update(status)
 		}
 
+	case *tsutil.TailnetLockStatus:
+		if a.tray != nil {
+			a.tray.Update(status)
+		}
+
+	case *tsutil.PeerPathStatus:
+		if a.tray != nil {
+			a.tray.Update(status)
+		}
+
 	case *tsutil.ProfileStatus:
+		if a.tray != nil {
+			a.tray.Update(status)
+		}
+
 		if a.win != nil {
 			a.win.Update(status)
 		}
@@ -344,6 +371,7 @@ func (a *App) onAppActivate(ctx context.Context) {
 	a.win.MainWindow.ConnectCloseRequest(func() bool {
 		if a.tray != nil {
 			a.tray.HideDock()
+			a.tray.SetWindowVisible(false)
 		}
 		a.win = nil
 		return false
@@ -351,6 +379,9 @@ func (a *App) onAppActivate(ctx context.Context) {
 
 	<-a.poller.Poll()
 	a.win.MainWindow.Present()
+	if a.tray != nil {
+		a.tray.SetWindowVisible(true)
+	}
 
 	glib.IdleAdd(func() {
 		a.update(<-a.poller.GetIPN())
@@ -360,25 +391,36 @@ func (a *App) onAppActivate(ctx context.Context) {
 func (a *App) initTray(ctx context.Context) {
 	slog.Warn("Starting.....")
 	if a.tray != nil {
-		err := a.tray.Start(<-a.poller.GetIPN())
-		if err != nil {
-			slog.Error("failed to start tray icon", "err", err)
-		}
+		a.startTray()
 		return
 	}
 
+	if err := tray.ValidateIcons(); err != nil {
+		if v, _ := metadata.Version(); v == "(devel)" {
+			panic(err)
+		}
+		slog.Error("invalid tray icons", "err", err)
+	}
+
+	version, _ := metadata.Version()
 	a.tray = tray.New(tray.Callbacks{
-		OnShow: func() {
+		OnShowWithHint: func(hint tray.ShowHint) {
 			glib.IdleAdd(func() {
-				if a.tray != nil {
-					a.tray.ShowDock()
-				}
+				hint.Activate()
 				if a.app != nil {
 					a.app.Activate()
 				}
 			})
 		},
 
+		OnHide: func() {
+			glib.IdleAdd(func() {
+				if a.win != nil {
+					a.win.MainWindow.Close()
+				}
+			})
+		},
+
 		OnConnToggle: func() {
 			glib.IdleAdd(func() {
 				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -397,12 +439,55 @@ func (a *App) initTray(ctx context.Context) {
 			})
 		},
 
+		OnServiceStartStop: func(start bool) {
+			go func() {
+				err := setServiceRunning(ctx, start)
+				if err != nil {
+					slog.Error("start or stop Tailscale service from tray", "start", start, "err", err)
+					glib.IdleAdd(func() { a.notify("Tailscale service", err.Error()) })
+					return
+				}
+				<-a.poller.Poll()
+			}()
+		},
+
+		OnConfirmDisconnect: func(proceed func()) {
+			glib.IdleAdd(func() {
+				Confirmation{
+					Heading: "Disconnect?",
+					Body:    "This machine will be unable to reach the tailnet until it reconnects.",
+					Accept:  "_Disconnect",
+					Reject:  "_Cancel",
+				}.Show(a, func(accept bool) {
+					if accept {
+						proceed()
+					}
+				})
+			})
+		},
+
+		OnPanic: func() {
+			glib.IdleAdd(func() {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+
+				err := tsutil.Lockdown(ctx, a.trayPanicStopsExitNode())
+				if err != nil {
+					a.notify("Lock down", err.Error())
+					slog.Error("lock down from tray", "err", err)
+					return
+				}
+				a.notify("Locked down", "Disconnected with incoming connections blocked")
+				<-a.poller.Poll()
+			})
+		},
+
 		OnExitToggle: func() {
 			glib.IdleAdd(func() {
 				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 				defer cancel()
 
-				s := <-a.poller.GetIPN()
+				s := a.trayStatus()
 				toggle := !s.ExitNodeActive()
 				err := tsutil.SetUseExitNode(ctx, toggle)
 				if err != nil {
@@ -419,9 +504,43 @@ func (a *App) initTray(ctx context.Context) {
 			})
 		},
 
+		OnSignNode: func(nodeKey key.NodePublic) {
+			glib.IdleAdd(func() {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+
+				err := tsutil.SignNode(ctx, nodeKey)
+				if err != nil {
+					a.notify("Sign node", err.Error())
+					slog.Error("sign node from tray", "err", err)
+					return
+				}
+				a.notify("Tailnet lock", "Node signed")
+				<-a.poller.Poll()
+			})
+		},
+
+		OnUseSuggestedExit: func() {
+			glib.IdleAdd(func() {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+
+				s := a.trayStatus()
+				if s.SuggestedExitNodeID == "" {
+					return
+				}
+				err := tsutil.ExitNode(ctx, s.SuggestedExitNodeID)
+				if err != nil {
+					a.notify("Use suggested exit node", err.Error())
+					slog.Error("use suggested exit node from tray", "err", err)
+					return
+				}
+			})
+		},
+
 		OnSelfNode: func() {
 			glib.IdleAdd(func() {
-				s := <-a.poller.GetIPN()
+				s := a.trayStatus()
 				addr := s.SelfAddr()
 				if !addr.IsValid() {
 					return
@@ -436,15 +555,191 @@ func (a *App) initTray(ctx context.Context) {
 		OnQuit: func() {
 			a.Quit()
 		},
-	})
+
+		OnExitNodeSelect: func(id tailcfg.StableNodeID, allowLAN bool) {
+			glib.IdleAdd(func() {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+
+				err := tsutil.AllowLANAccess(ctx, allowLAN)
+				if err != nil {
+					a.notify("Select exit node", err.Error())
+					slog.Error("set LAN access for exit node from tray", "err", err)
+					return
+				}
+				err = tsutil.ExitNode(ctx, id)
+				if err != nil {
+					a.notify("Select exit node", err.Error())
+					slog.Error("select exit node from tray", "err", err)
+					return
+				}
+			})
+		},
+
+		OnDefaultLANAccessToggle: func(allow bool) {
+			glib.IdleAdd(func() {
+				a.setExitNodeLANAccess(allow)
+			})
+		},
+
+		OnLastExitNodeChanged: func(id tailcfg.StableNodeID) {
+			glib.IdleAdd(func() {
+				a.setLastExitNode(id)
+			})
+		},
+
+		OnPeerPinToggle: func(id tailcfg.StableNodeID) {
+			glib.IdleAdd(func() {
+				a.togglePinnedPeer(id)
+			})
+		},
+
+		OnOpenURL: func(url string) {
+			glib.IdleAdd(func() {
+				gtk.NewURILauncher(url).Launch(ctx, a.window(), nil)
+			})
+		},
+
+		OnLogin: func() {
+			glib.IdleAdd(func() {
+				a.app.ActivateAction("login", nil)
+			})
+		},
+
+		OnRename: func() {
+			glib.IdleAdd(func() {
+				a.showRename()
+			})
+		},
+
+		OnAbout: func() {
+			glib.IdleAdd(func() {
+				a.showAbout()
+			})
+		},
+
+		OnResume: func() {
+			<-a.poller.Poll()
+		},
+
+		OnShowQR: func(text string) {
+			glib.IdleAdd(func() {
+				err := QRCode{Heading: "QR Code", Text: text}.Show(a)
+				if err != nil {
+					slog.Error("show QR code from tray", "err", err)
+				}
+			})
+		},
+
+		OnCopy: func(text string) {
+			glib.IdleAdd(func() {
+				a.clip(glib.NewValue(text))
+				a.notify("Trayscale", "Copied to clipboard")
+			})
+		},
+
+		OnPeerPathChanged: func(id tailcfg.StableNodeID, direct bool) {
+			glib.IdleAdd(func() {
+				peer, ok := a.trayStatus().Peers[id]
+				if !ok {
+					return
+				}
+				path := "relayed"
+				if direct {
+					path = "direct"
+				}
+				a.notify(peer.DisplayName(true), fmt.Sprintf("Connection is now %v", path))
+			})
+		},
+
+		OnCopySSHCommand: func(id tailcfg.StableNodeID) {
+			s := a.tray.CurrentStatus()
+			if s == nil {
+				return
+			}
+			cmd := s.PeerSSHCommand(id)
+			if cmd == "" {
+				return
+			}
+			glib.IdleAdd(func() {
+				a.clip(glib.NewValue(cmd))
+				a.notify("Trayscale", "Copied to clipboard")
+			})
+		},
+
+		OnOpenTaildropDir: func() {
+			glib.IdleAdd(func() {
+				dir := glib.GetUserSpecialDir(glib.UserDirectoryDownload)
+				if dir == "" {
+					return
+				}
+				gtk.NewFileLauncher(gio.NewFileForPath(dir)).Launch(ctx, a.window(), nil)
+			})
+		},
+
+		OnClearTaildrop: func() {
+			glib.IdleAdd(func() {
+				a.clearWaitingFiles(ctx)
+			})
+		},
+	},
+		tray.WithQRItems(a.trayQRItems()),
+		tray.WithConfirmDisconnect(a.trayConfirmDisconnect()),
+		tray.WithPanicItem(a.trayPanicItem()),
+		tray.WithSelfLabelFormat(a.traySelfLabelFormat()),
+		tray.WithHealthEndpoint(a.trayHealthAddress()),
+		tray.WithLeftClickShows(a.trayLeftClickShows()),
+		tray.WithStateFile(a.trayStateFile()),
+		tray.WithMinDaemonVersion(minDaemonVersion),
+		tray.WithAboutItem(true),
+		tray.WithVersion(version),
+	)
+
+	a.tray.SetPinnedPeers(a.pinnedPeers())
+	a.tray.SetLastExitNode(a.lastExitNode())
+	a.tray.SetDefaultLANAccess(a.exitNodeLANAccess())
 
 	slog.Warn("Starting tray")
+	a.startTray()
+}
+
+func (a *App) startTray() {
 	err := a.tray.Start(<-a.poller.GetIPN())
 	if err != nil {
 		slog.Error("failed to start tray icon", "err", err)
+		if errors.Is(err, tray.ErrNoTrayHost) {
+			a.notify("System Tray Unavailable", "No system tray was found. On GNOME, install the AppIndicator extension to use the tray icon.")
+		}
 	}
 }
 
+// trayStatus returns the status that the tray is currently showing so
+// that tray callbacks act on what the user saw, falling back to asking
+// the poller if the tray doesn't have one yet.
+func (a *App) trayStatus() *tsutil.IPNStatus {
+	if a.tray != nil {
+		if s := a.tray.CurrentStatus(); s != nil {
+			return s
+		}
+	}
+	return <-a.poller.GetIPN()
+}
+
+// setServiceRunning starts or stops the tailscaled systemd service.
+// Doing so requires privileges, so polkit is asked to authorize it.
+func setServiceRunning(ctx context.Context, start bool) error {
+	verb := "stop"
+	if start {
+		verb = "start"
+	}
+
+	out, err := exec.CommandContext(ctx, "pkexec", "systemctl", verb, "tailscaled.service").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v tailscaled: %w: %s", verb, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
 // Quit exits the app completely, causing Run to return.
 func (a *App) Quit() {
 	if a.tray != nil {
@@ -1,9 +1,14 @@
 package ui
 
 import (
+	"fmt"
+
 	"deedles.dev/trayscale/internal/gutil"
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/skip2/go-qrcode"
 )
 
 func (a *App) window() *gtk.Window {
@@ -163,3 +168,33 @@ func (d Select[T]) Show(a *App, res func([]SelectOption[T])) {
 
 	dialog.Present(gutil.PointerToWidgetter(a.window()))
 }
+
+type QRCode struct {
+	Heading string
+	Text    string
+}
+
+func (d QRCode) Show(a *App) error {
+	const size = 256
+
+	png, err := qrcode.Encode(d.Text, qrcode.Medium, size)
+	if err != nil {
+		return fmt.Errorf("encode QR code: %w", err)
+	}
+	texture, err := gdk.NewTextureFromBytes(glib.NewBytes(png))
+	if err != nil {
+		return fmt.Errorf("load QR code: %w", err)
+	}
+
+	picture := gtk.NewPictureForPaintable(texture)
+	picture.SetSizeRequest(size, size)
+
+	dialog := adw.NewAlertDialog(d.Heading, d.Text)
+	dialog.SetExtraChild(picture)
+	dialog.AddResponse("close", "_Close")
+	dialog.SetCloseResponse("close")
+	dialog.SetDefaultResponse("close")
+
+	dialog.Present(gutil.PointerToWidgetter(a.window()))
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"log/slog"
+	"slices"
 
 	"deedles.dev/trayscale/internal/giofs"
 	"deedles.dev/trayscale/internal/tsutil"
@@ -71,3 +72,30 @@ func (a *App) saveFile(ctx context.Context, name string, file gio.Filer) {
 	<-a.poller.Poll()
 	slog.Info("done saving file")
 }
+
+// clearWaitingFiles deletes every received file that has yet to be
+// saved after asking the user to confirm.
+func (a *App) clearWaitingFiles(ctx context.Context) {
+	if (a.files == nil) || (len(*a.files) == 0) {
+		return
+	}
+	files := slices.Clone(*a.files)
+
+	Confirmation{
+		Heading: "Delete received files?",
+		Body:    "If you delete these files, you will no longer be able to save them to your local machine.",
+		Accept:  "_Delete",
+		Reject:  "_Cancel",
+	}.Show(a, func(accept bool) {
+		if !accept {
+			return
+		}
+		for _, file := range files {
+			err := tsutil.DeleteWaitingFile(ctx, file.Name)
+			if err != nil {
+				slog.Error("delete file", "name", file.Name, "err", err)
+			}
+		}
+		<-a.poller.Poll()
+	})
+}
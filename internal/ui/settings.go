@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"slices"
+	"strings"
 	"time"
 
 	"deedles.dev/trayscale/internal/metadata"
+	"deedles.dev/trayscale/internal/tray"
 	"deedles.dev/trayscale/internal/tsutil"
 	"deedles.dev/xiter"
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
@@ -15,6 +18,7 @@ import (
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
 )
 
 func (a *App) initSettings(ctx context.Context) {
@@ -42,6 +46,16 @@ func (a *App) initSettings(ctx context.Context) {
 
 		case "polling-interval":
 			a.poller.SetInterval() <- a.getInterval()
+
+		case "pinned-peers":
+			if a.tray != nil {
+				a.tray.SetPinnedPeers(a.pinnedPeers())
+			}
+
+		case "exit-node-lan-access":
+			if a.tray != nil {
+				a.tray.SetDefaultLANAccess(a.exitNodeLANAccess())
+			}
 		}
 	})
 
@@ -87,6 +101,37 @@ func (a *App) showChangeControlServer() {
 	})
 }
 
+// showRename prompts for a new name for the local node and submits it
+// to the control plane.
+func (a *App) showRename() {
+	status := <-a.poller.GetIPN()
+	name, _, _ := strings.Cut(status.SelfDNSName(), ".")
+
+	Prompt{
+		Heading: "Rename This Machine",
+		Body:    "The new name is used in the tailnet once the control server accepts it.",
+		Responses: []PromptResponse{
+			{ID: "cancel", Label: "_Cancel"},
+			{ID: "rename", Label: "_Rename", Appearance: adw.ResponseSuggested, Default: true},
+		},
+	}.Show(a, name, func(response, val string) {
+		if response != "rename" {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := tsutil.SetHostname(ctx, strings.TrimSpace(val))
+		if err != nil {
+			slog.Error("rename machine", "err", err, "name", val)
+			a.notify("Rename machine", err.Error())
+			return
+		}
+		<-a.poller.Poll()
+	})
+}
+
 func (a *App) showPreferences() {
 	if a.settings == nil {
 		a.win.Toast("Settings schema not found")
@@ -122,6 +167,132 @@ func (a *App) showAbout() {
 	dialog.Present(a.window())
 }
 
+// trayQRItems returns whether the tray should offer QR codes of the
+// local node.
+func (a *App) trayQRItems() bool {
+	return (a.settings != nil) && a.settings.Boolean("tray-qr-codes")
+}
+
+// trayConfirmDisconnect returns whether disconnecting from the tray
+// should be confirmed first.
+func (a *App) trayConfirmDisconnect() bool {
+	return (a.settings != nil) && a.settings.Boolean("tray-confirm-disconnect")
+}
+
+// trayPanicItem returns whether the tray should offer an item for
+// locking this machine down immediately.
+func (a *App) trayPanicItem() bool {
+	return (a.settings != nil) && a.settings.Boolean("tray-panic-item")
+}
+
+// trayPanicStopsExitNode returns whether locking down from the tray
+// should also stop offering this machine as an exit node.
+func (a *App) trayPanicStopsExitNode() bool {
+	return (a.settings != nil) && a.settings.Boolean("tray-panic-stops-exit-node")
+}
+
+// trayLeftClickShows returns whether left-clicking the tray icon
+// should show the window instead of opening the menu.
+func (a *App) trayLeftClickShows() bool {
+	return (a.settings == nil) || a.settings.Boolean("tray-left-click-shows")
+}
+
+// traySelfLabelFormat returns how the tray should describe the local
+// node.
+func (a *App) traySelfLabelFormat() tray.SelfLabelFormat {
+	if a.settings == nil {
+		return tray.SelfLabelNameAndAddr
+	}
+
+	switch a.settings.String("tray-self-label") {
+	case "address":
+		return tray.SelfLabelAddr
+	case "name":
+		return tray.SelfLabelName
+	default:
+		return tray.SelfLabelNameAndAddr
+	}
+}
+
+// trayStateFile returns the path of the file that the tray saves its
+// icon to between sessions.
+func (a *App) trayStateFile() string {
+	return filepath.Join(glib.GetUserCacheDir(), "trayscale", "tray-state.json")
+}
+
+// trayHealthAddress returns the address that the tray should serve
+// its status on, or an empty string if it shouldn't.
+func (a *App) trayHealthAddress() string {
+	if a.settings == nil {
+		return ""
+	}
+	return a.settings.String("tray-health-address")
+}
+
+// pinnedPeers returns the peers that the user has pinned to the top
+// of the tray's peers submenu.
+func (a *App) pinnedPeers() []tailcfg.StableNodeID {
+	if a.settings == nil {
+		return nil
+	}
+
+	pinned := a.settings.Strv("pinned-peers")
+	ids := make([]tailcfg.StableNodeID, 0, len(pinned))
+	for _, id := range pinned {
+		ids = append(ids, tailcfg.StableNodeID(id))
+	}
+	return ids
+}
+
+// togglePinnedPeer pins id if it isn't pinned and unpins it if it is.
+func (a *App) togglePinnedPeer(id tailcfg.StableNodeID) {
+	if a.settings == nil {
+		slog.Warn("settings schema not found, can't pin peer", "id", id)
+		return
+	}
+
+	pinned := a.settings.Strv("pinned-peers")
+	if i := slices.Index(pinned, string(id)); i >= 0 {
+		pinned = slices.Delete(pinned, i, i+1)
+	} else {
+		pinned = append(pinned, string(id))
+	}
+	a.settings.SetStrv("pinned-peers", pinned)
+}
+
+// exitNodeLANAccess returns whether exit nodes selected from the tray
+// should allow local network access.
+func (a *App) exitNodeLANAccess() bool {
+	return (a.settings != nil) && a.settings.Boolean("exit-node-lan-access")
+}
+
+// setExitNodeLANAccess sets whether exit nodes selected from the tray
+// should allow local network access.
+func (a *App) setExitNodeLANAccess(allow bool) {
+	if a.settings == nil {
+		slog.Warn("settings schema not found, can't set exit node LAN access default")
+		return
+	}
+	a.settings.SetBoolean("exit-node-lan-access", allow)
+}
+
+// lastExitNode returns the exit node that was most recently used.
+func (a *App) lastExitNode() tailcfg.StableNodeID {
+	if a.settings == nil {
+		return ""
+	}
+	return tailcfg.StableNodeID(a.settings.String("last-exit-node"))
+}
+
+// setLastExitNode remembers id as the exit node that was most
+// recently used.
+func (a *App) setLastExitNode(id tailcfg.StableNodeID) {
+	if a.settings == nil {
+		return
+	}
+	a.settings.SetString("last-exit-node", string(id))
+}
+
 func (a *App) getInterval() time.Duration {
 	if a.settings == nil {
 		return 5 * time.Second